@@ -0,0 +1,86 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEvent represents authentication and authorization decision
+type AuditEvent struct {
+	Time   time.Time `json:"time"`   // time of decision
+	Login  string    `json:"login"`  // user login
+	DN     string    `json:"dn"`     // user DN
+	Path   string    `json:"path"`   // request path
+	Result bool      `json:"result"` // decision result
+	Reason string    `json:"reason"` // reason of failure
+
+	// login of operator acting as user, see CMSAuth.Impersonate
+	Impersonator string `json:"impersonator,omitempty"`
+}
+
+// AuditLogger defines interface to record authentication and authorization
+// decisions
+type AuditLogger interface {
+	Log(event AuditEvent) error
+}
+
+// SetAuditLogger sets audit logger used by CheckAuthnAuthz, CheckAuthnAuthzRequest
+// and Middleware to record every decision, nil logger disables audit
+func (a *CMSAuth) SetAuditLogger(logger AuditLogger) {
+	a.auditLogger = logger
+}
+
+// helper function to record decision for given headers and request path in
+// audit log
+func (a *CMSAuth) audit(headers map[string][]string, uri string, status bool, reason string) {
+	if a.auditLogger == nil {
+		return
+	}
+	user := a.UserFromHeader(http.Header(headers))
+	event := AuditEvent{
+		Time:   time.Now(),
+		Login:  user.Login,
+		DN:     user.DN,
+		Result: status,
+		Path:   uri,
+		Reason: reason,
+	}
+	if err := a.auditLogger.Log(event); err != nil {
+		GetLogger().Errorf("CMSAuth, unable to write audit event, error %v", err)
+	}
+}
+
+// JSONAuditLogger writes audit events as JSON lines into a file
+type JSONAuditLogger struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewJSONAuditLogger creates JSONAuditLogger which appends events to given file
+func NewJSONAuditLogger(fname string) (*JSONAuditLogger, error) {
+	file, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONAuditLogger{file: file}, nil
+}
+
+// Log implements AuditLogger interface
+func (l *JSONAuditLogger) Log(event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes audit log file
+func (l *JSONAuditLogger) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,40 @@
+//go:build !windows && !plan9
+
+package cmsauth
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditLogger writes audit events as JSON messages to syslog
+type SyslogAuditLogger struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditLogger creates SyslogAuditLogger with given syslog tag
+func NewSyslogAuditLogger(tag string) (*SyslogAuditLogger, error) {
+	writer, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditLogger{writer: writer}, nil
+}
+
+// Log implements AuditLogger interface, failed decisions are logged with
+// warning priority
+func (l *SyslogAuditLogger) Log(event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Result {
+		return l.writer.Info(string(data))
+	}
+	return l.writer.Warning(string(data))
+}
+
+// Close closes connection to syslog
+func (l *SyslogAuditLogger) Close() error {
+	return l.writer.Close()
+}
@@ -0,0 +1,50 @@
+package cmsauth
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestJSONAuditLogger function
+func TestJSONAuditLogger(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewJSONAuditLogger(fname)
+	assert.Nil(t, err)
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SetAuditLogger(logger)
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	userData := map[string]interface{}{"cern_upn": "user", "dn": "/CN=user"}
+	cmsAuth.SetCMSHeaders(r, userData, CricRecords{}, false)
+	header := lowerHeaders(r.Header)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	header["cms-authn-hmac"] = []string{"invalid"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	cmsAuth.SetAuthorizer(&mockAuthorizer{grant: false})
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(r), false)
+	assert.Nil(t, logger.Close())
+
+	file, err := os.Open(fname)
+	assert.Nil(t, err)
+	defer file.Close()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[0].Login, "user")
+	assert.Equal(t, events[0].DN, "/CN=user")
+	assert.Equal(t, events[0].Path, "/path")
+	assert.Equal(t, events[0].Result, true)
+	assert.Equal(t, events[1].Reason, "hmac_mismatch")
+	assert.Equal(t, events[2].Reason, "authorization")
+}
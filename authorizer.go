@@ -0,0 +1,206 @@
+package cmsauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User represents authenticated CMS user obtained from CMS headers
+type User struct {
+	Name  string              `json:"name"`  // user name
+	Login string              `json:"login"` // user login
+	DN    string              `json:"dn"`    // user DN
+	Roles map[string][]string `json:"roles"` // user roles and their groups/sites
+}
+
+// UserFromHeader creates User from CMS authn/authz headers
+func UserFromHeader(header http.Header) User {
+	return userFromHeader(header, DefaultHeaderPrefix)
+}
+
+// UserFromHeader creates User from authn/authz headers of configured namespace
+func (a *CMSAuth) UserFromHeader(header http.Header) User {
+	return userFromHeader(header, a.headerPrefix())
+}
+
+// helper function to create User from authn/authz headers of given prefix
+func userFromHeader(header http.Header, prefix string) User {
+	user := User{Roles: make(map[string][]string)}
+	authz := headerKey(prefix, "authz-")
+	for key, vals := range header {
+		if len(vals) == 0 {
+			continue
+		}
+		k := strings.ToLower(key)
+		switch {
+		case k == headerKey(prefix, "authn-name"):
+			user.Name = vals[0]
+		case k == headerKey(prefix, "authn-login"):
+			user.Login = vals[0]
+		case k == headerKey(prefix, "authn-dn"):
+			user.DN = vals[0]
+		case strings.HasPrefix(k, authz):
+			role := strings.TrimPrefix(k, authz)
+			for _, v := range vals {
+				user.Roles[role] = append(user.Roles[role], strings.Fields(v)...)
+			}
+		}
+	}
+	return user
+}
+
+// UserInfo represents authentication context of CMS user obtained from
+// cms-authn-* and cms-authz-* headers set by SetCMSHeaders
+type UserInfo struct {
+	Login      string              `json:"login"`       // user login
+	DN         string              `json:"dn"`          // user DN
+	Name       string              `json:"name"`        // user name
+	CernID     string              `json:"cern_id"`     // CERN person ID
+	Email      string              `json:"email"`       // user email
+	Roles      map[string][]string `json:"roles"`       // user roles and their groups/sites
+	AuthMethod string              `json:"auth_method"` // authentication method
+	Expiry     time.Time           `json:"expiry"`      // expiration time of authentication
+}
+
+// GetUserInfo parses CMS headers of given HTTP request into UserInfo
+func GetUserInfo(r *http.Request) (*UserInfo, error) {
+	return getUserInfo(r, DefaultHeaderPrefix)
+}
+
+// GetUserInfo parses headers of configured namespace of given HTTP request
+// into UserInfo
+func (a *CMSAuth) GetUserInfo(r *http.Request) (*UserInfo, error) {
+	return getUserInfo(r, a.headerPrefix())
+}
+
+// helper function to parse headers of given prefix into UserInfo
+func getUserInfo(r *http.Request, prefix string) (*UserInfo, error) {
+	if status := HeaderValue(r.Header, headerKey(prefix, "auth-status")); status != "ok" {
+		return nil, fmt.Errorf("request is not authenticated, %s '%s'", headerKey(prefix, "auth-status"), status)
+	}
+	user := userFromHeader(r.Header, prefix)
+	info := &UserInfo{
+		Login:      user.Login,
+		DN:         user.DN,
+		Name:       user.Name,
+		CernID:     HeaderValue(r.Header, headerKey(prefix, "cern-id")),
+		Email:      HeaderValue(r.Header, headerKey(prefix, "email")),
+		Roles:      user.Roles,
+		AuthMethod: HeaderValue(r.Header, headerKey(prefix, "authn-method")),
+	}
+	if exp := HeaderValue(r.Header, headerKey(prefix, "auth-expire")); exp != "" {
+		sec, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header '%s': %v", headerKey(prefix, "auth-expire"), exp, err)
+		}
+		info.Expiry = time.Unix(sec, 0)
+	}
+	return info, nil
+}
+
+// Authorizer defines interface to authorize authenticated user to perform
+// given action, e.g. to access given request URI
+type Authorizer interface {
+	Authorize(ctx context.Context, user User, action string) (bool, error)
+}
+
+// HeaderAuthorizer authorizes user based on CMS roles obtained from cms-authz
+// headers. The zero value authorizes any authenticated user.
+type HeaderAuthorizer struct {
+	Role  string // required role
+	Group string // required group
+	Site  string // required site
+}
+
+// Authorize implements Authorizer interface
+func (h HeaderAuthorizer) Authorize(ctx context.Context, user User, action string) (bool, error) {
+	if h.Role == "" && h.Group == "" && h.Site == "" {
+		return true, nil
+	}
+	header := make(http.Header)
+	for role, vals := range user.Roles {
+		header[RoleHeaderKey(role)] = []string{strings.Join(vals, " ")}
+	}
+	var a CMSAuth
+	return a.CheckCMSAuthz(header, h.Role, h.Group, h.Site), nil
+}
+
+// AuthzRule defines authorization rule for given path prefix
+type AuthzRule struct {
+	Path  string `json:"path"`  // request path prefix
+	Role  string `json:"role"`  // required role
+	Group string `json:"group"` // required group
+	Site  string `json:"site"`  // required site
+}
+
+// RuleAuthorizer authorizes user based on set of path rules. The request
+// path is cleaned, e.g. /dbs//write and /dbs/./write become /dbs/write, and
+// rule path matches it on path segment boundaries, i.e. /dbs/write rule
+// applies to /dbs/write and /dbs/write/file but not to /dbs/writer. The rule
+// with the longest matching path is applied, requests which do not match any
+// rule are authorized unless DefaultDeny is set.
+type RuleAuthorizer struct {
+	Rules       []AuthzRule
+	DefaultDeny bool // deny requests which do not match any rule
+}
+
+// NewRuleAuthorizer creates RuleAuthorizer from given JSON rule file, e.g.
+// [{"path": "/dbs/write", "role": "operator", "group": "dbs"}]. YAML rule
+// files are not supported.
+func NewRuleAuthorizer(fname string) (*RuleAuthorizer, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AuthzRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse authorization rules %s: %v", fname, err)
+	}
+	for _, r := range rules {
+		if r.Path == "" {
+			return nil, fmt.Errorf("authorization rule without path in %s", fname)
+		}
+	}
+	return &RuleAuthorizer{Rules: rules}, nil
+}
+
+// Authorize implements Authorizer interface
+func (p *RuleAuthorizer) Authorize(ctx context.Context, user User, action string) (bool, error) {
+	upath := action
+	if idx := strings.IndexAny(upath, "?#"); idx >= 0 {
+		upath = upath[:idx]
+	}
+	upath = path.Clean("/" + upath)
+	var rule *AuthzRule
+	var rlen int
+	for i, r := range p.Rules {
+		rpath := path.Clean("/" + r.Path)
+		if !matchPath(upath, rpath) {
+			continue
+		}
+		if rule == nil || len(rpath) > rlen {
+			rule, rlen = &p.Rules[i], len(rpath)
+		}
+	}
+	if rule == nil {
+		return !p.DefaultDeny, nil
+	}
+	h := HeaderAuthorizer{Role: rule.Role, Group: rule.Group, Site: rule.Site}
+	return h.Authorize(ctx, user, action)
+}
+
+// helper function to check if cleaned rule path matches cleaned request path
+// on path segment boundary
+func matchPath(upath, rpath string) bool {
+	if rpath == "/" || upath == rpath {
+		return true
+	}
+	return strings.HasPrefix(upath, rpath+"/")
+}
@@ -0,0 +1,163 @@
+package cmsauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAuthorizer grants or denies access to all users
+type mockAuthorizer struct {
+	grant  bool
+	err    error
+	action string
+	calls  int
+}
+
+// Authorize implements Authorizer interface
+func (m *mockAuthorizer) Authorize(ctx context.Context, user User, action string) (bool, error) {
+	m.action = action
+	m.calls++
+	return m.grant, m.err
+}
+
+// TestAuthorizer function
+func TestAuthorizer(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-login", "user")
+	r.Header.Set("cms-authz-user", "group:dbs")
+	r.Header.Set("cms-request-uri", "/dbs/datasets")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+
+	// default authorizer grants access to authenticated user
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	authorizer := &mockAuthorizer{grant: true}
+	cmsAuth.SetAuthorizer(authorizer)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	assert.Equal(t, authorizer.action, "/dbs/datasets")
+
+	authorizer.grant = false
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	authorizer.grant = true
+	authorizer.err = errors.New("policy service is not available")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	// authorizer is not consulted when authentication fails
+	authorizer.err = nil
+	authorizer.action = ""
+	header["cms-authn-hmac"] = []string{"invalid"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	assert.Equal(t, authorizer.action, "")
+
+	// HTTP requests are authorized on URL path, not on unsigned
+	// cms-request-uri header
+	r.Header.Set("cms-authn-hmac", hmac)
+	r.Header.Set("cms-request-uri", "/public")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(r), true)
+	assert.Equal(t, authorizer.action, "/path")
+}
+
+// TestHeaderAuthorizer function
+func TestHeaderAuthorizer(t *testing.T) {
+	header := make(http.Header)
+	header.Set("cms-authn-login", "user")
+	header.Set("cms-authz-operator", "group:dbs site:T1_US_FNAL")
+	user := UserFromHeader(header)
+	assert.Equal(t, user.Login, "user")
+	assert.Equal(t, user.Roles["operator"], []string{"group:dbs", "site:T1_US_FNAL"})
+
+	ctx := context.Background()
+	ok, _ := HeaderAuthorizer{}.Authorize(ctx, user, "")
+	assert.Equal(t, ok, true)
+	ok, _ = HeaderAuthorizer{Role: "operator", Group: "group:dbs"}.Authorize(ctx, user, "")
+	assert.Equal(t, ok, true)
+	ok, _ = HeaderAuthorizer{Role: "admin", Group: "group:dbs"}.Authorize(ctx, user, "")
+	assert.Equal(t, ok, false)
+}
+
+// TestRuleAuthorizer function
+func TestRuleAuthorizer(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "rules.json")
+	rules := `[
+		{"path": "/dbs", "role": "operator", "group": "group:dbs"},
+		{"path": "/dbs/admin", "role": "admin"}
+	]`
+	err := os.WriteFile(fname, []byte(rules), 0600)
+	assert.Nil(t, err)
+	authorizer, err := NewRuleAuthorizer(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, len(authorizer.Rules), 2)
+
+	header := make(http.Header)
+	header.Set("cms-authz-operator", "group:dbs")
+	user := UserFromHeader(header)
+	ctx := context.Background()
+	ok, _ := authorizer.Authorize(ctx, user, "/dbs/datasets?dataset=/a/b/c")
+	assert.Equal(t, ok, true)
+	ok, _ = authorizer.Authorize(ctx, user, "/dbs/admin/config")
+	assert.Equal(t, ok, false)
+	ok, _ = authorizer.Authorize(ctx, user, "/das")
+	assert.Equal(t, ok, true)
+
+	// request path is cleaned and rules match on path segments
+	for _, action := range []string{"/dbs//admin/config", "/dbs/./admin", "/dbs/x/../admin", "dbs/admin"} {
+		ok, _ = authorizer.Authorize(ctx, user, action)
+		assert.Equal(t, ok, false)
+	}
+	ok, _ = authorizer.Authorize(ctx, user, "/dbs/administrator")
+	assert.Equal(t, ok, true)
+	ok, _ = authorizer.Authorize(ctx, UserFromHeader(make(http.Header)), "/dbsx")
+	assert.Equal(t, ok, true)
+	authorizer.DefaultDeny = true
+	ok, _ = authorizer.Authorize(ctx, user, "/dbsx")
+	assert.Equal(t, ok, false)
+	ok, _ = authorizer.Authorize(ctx, user, "/dbs/datasets")
+	assert.Equal(t, ok, true)
+
+	err = os.WriteFile(fname, []byte(`[{"role": "admin"}]`), 0600)
+	assert.Nil(t, err)
+	_, err = NewRuleAuthorizer(fname)
+	assert.NotNil(t, err)
+}
+
+// TestGetUserInfo function
+func TestGetUserInfo(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/path", nil)
+	_, err := GetUserInfo(r)
+	assert.NotNil(t, err)
+
+	userData := map[string]interface{}{
+		"name":           "First Last",
+		"cern_upn":       "user",
+		"cern_person_id": 123,
+		"email":          "user@cern.ch",
+		"exp":            float64(1700000000),
+	}
+	var cmsAuth CMSAuth
+	cmsAuth.SetCMSHeaders(r, userData, CricRecords{}, false)
+	r.Header.Set("cms-authz-operator", "group:dbs")
+	info, err := GetUserInfo(r)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Login, "user")
+	assert.Equal(t, info.Name, "First Last")
+	assert.Equal(t, info.CernID, "123")
+	assert.Equal(t, info.Email, "user@cern.ch")
+	assert.Equal(t, info.AuthMethod, "X509Cert")
+	assert.Equal(t, info.Roles["operator"], []string{"group:dbs"})
+	assert.Equal(t, info.Expiry.Unix(), int64(1700000000))
+
+	r.Header.Set("cms-auth-expire", "never")
+	_, err = GetUserInfo(r)
+	assert.NotNil(t, err)
+}
@@ -1,14 +1,26 @@
 package cmsauth
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // StringList allows to sort string keys
@@ -22,124 +34,1156 @@ func (s StringList) Less(i, j int) bool { return s[i] < s[j] }
 type CMSAuth struct {
 	afile string
 	hkey  []byte
+	// prevKey holds previous HMAC key which is accepted until prevExpire
+	prevKey    []byte
+	prevExpire time.Time
+	// ring holds additional HMAC keys accepted for verification
+	ring []namedKey
+	// kmutex keeps lock for hkey updates
+	kmutex sync.RWMutex
+
+	// KeyGracePeriod defines period during which previous HMAC key is still
+	// accepted after key rotation, see ReloadKey and WatchKeyFile
+	KeyGracePeriod time.Duration
+
+	// Canonicalize enables canonicalization of cms-authn/cms-authz header values
+	// (trim and collapse internal whitespace) before HMAC computation. It should
+	// be enabled on both signing and verifying peers, otherwise the HMAC values
+	// will not match.
+	Canonicalize bool
+
+	// URLEncode enables percent-encoding of cms-authn/cms-authz header values
+	// before HMAC computation which keeps signed string ASCII only. Like
+	// Canonicalize it should be enabled on both signing and verifying peers.
+	URLEncode bool
+
+	// SignMethodHost enables incorporation of HTTP method and host of the
+	// request into HMAC computation. Such requests should be verified with
+	// CheckAuthnAuthzRequest.
+	SignMethodHost bool
+
+	// TimestampSkew defines allowed clock skew between signing time provided
+	// by cms-authn-timestamp header and verification time, if not set the
+	// DefaultTimestampSkew is used
+	TimestampSkew time.Duration
+
+	// AffiliationHeaders enables cms-authn-institute, cms-authn-country,
+	// cms-authn-vo and cms-authn-status headers in SetCMSHeaders when user
+	// CRIC record provides these attributes
+	AffiliationHeaders bool
+
+	// StrictMode disables acceptance of requests with cms-auth-status NONE,
+	// i.e. every request should carry valid HMAC
+	StrictMode bool
+
+	// HeaderPrefix defines namespace of authentication headers, e.g. with
+	// atlas prefix CMSAuth signs and verifies atlas-auth-status, atlas-authn-*
+	// and atlas-authz-* headers. If not set the DefaultHeaderPrefix is used.
+	// Like Canonicalize it should be the same on signing and verifying peers.
+	HeaderPrefix string
+
+	// ClientCAs defines trusted CAs used by CheckX509 to verify client
+	// certificate chains which were not verified by TLS, e.g. proxy
+	// certificates requested with tls.RequestClientCert. If not set such
+	// chains are rejected.
+	ClientCAs *x509.CertPool
+
+	// hexclude holds lower-case header keys excluded from HMAC computation
+	hexclude map[string]bool
+
+	// peerKeys holds HMAC keys of trusted peer realms
+	peerKeys map[string][]byte
+
+	// required holds lower-case header keys which must be present in request
+	required []string
+
+	// scheme defines HMAC scheme used for signing, default is HmacSHA1
+	scheme HmacScheme
+
+	// accepted holds HMAC schemes accepted during verification
+	accepted []HmacScheme
+
+	// authorizer performs authorization after successful authentication
+	authorizer Authorizer
+
+	// auditLogger records authentication and authorization decisions
+	auditLogger AuditLogger
+
+	// decisions caches authorization decisions
+	decisions *DecisionCache
+
+	// roleMapper translates CRIC roles into service roles
+	roleMapper *RoleMapper
+}
+
+// DefaultHeaderPrefix defines default namespace of authentication headers
+// used by CMSAuth without HeaderPrefix and by package level functions, e.g.
+// UserFromHeader and RoleHeaderKey
+var DefaultHeaderPrefix = "cms"
+
+// helper function to return lower-case header prefix of CMSAuth
+func (a *CMSAuth) headerPrefix() string {
+	if a.HeaderPrefix == "" {
+		return DefaultHeaderPrefix
+	}
+	return strings.ToLower(strings.TrimSuffix(a.HeaderPrefix, "-"))
 }
 
-// Init method initializes CMSAuth auth file, i.e. read the key
+// HeaderKey returns lower-case key of authentication header with given name
+// in configured namespace, e.g. authn-login becomes cms-authn-login
+func (a *CMSAuth) HeaderKey(name string) string {
+	return headerKey(a.headerPrefix(), name)
+}
+
+// helper function to return header key of given name within given prefix
+func headerKey(prefix, name string) string {
+	return prefix + "-" + name
+}
+
+// DefaultTimestampSkew defines default allowed clock skew for cms-authn-timestamp
+var DefaultTimestampSkew = 15 * time.Minute
+
+// HmacScheme defines HMAC construction scheme
+type HmacScheme string
+
+// supported HMAC schemes
+const (
+	HmacSHA1   HmacScheme = "sha1"   // HMAC-SHA1, legacy scheme
+	HmacSHA256 HmacScheme = "sha256" // HMAC-SHA256
+)
+
+// helper function to return hash constructor of HMAC scheme
+func (s HmacScheme) hashFunc() func() hash.Hash {
+	switch s {
+	case HmacSHA1:
+		return sha1.New
+	case HmacSHA256:
+		return sha256.New
+	}
+	return nil
+}
+
+// Init method initializes CMSAuth auth file, i.e. read the key. The auth file
+// can be either a file name or a reference resolved by registered KeyProvider,
+// e.g. https://host/key or vault://path. Errors are printed, use InitError
+// to handle them programmatically.
 func (a *CMSAuth) Init(afile string) {
+	if err := a.InitError(afile); err != nil {
+		GetLogger().Errorf("%v", err)
+	}
+}
+
+// InitError method initializes CMSAuth auth file similar to Init and returns
+// an error if the key can not be read. The auth file is kept even if the key
+// can not be read, i.e. all signed requests are rejected until the key is
+// loaded by ReloadKey.
+func (a *CMSAuth) InitError(afile string) error {
 	a.afile = afile
-	if len(afile) != 0 {
-		hkey, err := os.ReadFile(afile)
+	if len(afile) == 0 {
+		return nil
+	}
+	hkey, err := FetchKey(afile)
+	if err != nil {
+		return fmt.Errorf("CMSAuth, unable to read %s, error %v", afile, err)
+	}
+	a.setKey(hkey)
+	return nil
+}
+
+// helper function to return HMAC key
+func (a *CMSAuth) key() []byte {
+	a.kmutex.RLock()
+	defer a.kmutex.RUnlock()
+	return a.hkey
+}
+
+// helper function to set HMAC key
+func (a *CMSAuth) setKey(hkey []byte) {
+	a.kmutex.Lock()
+	defer a.kmutex.Unlock()
+	if a.KeyGracePeriod > 0 && len(a.hkey) > 0 && !bytes.Equal(a.hkey, hkey) {
+		a.prevKey = a.hkey
+		a.prevExpire = time.Now().Add(a.KeyGracePeriod)
+	}
+	a.hkey = hkey
+}
+
+// namedKey represents HMAC key along with its name
+type namedKey struct {
+	name string
+	key  []byte
+}
+
+// helper function to return HMAC keys accepted for verification, i.e. the
+// current key, previous key within grace period and key ring keys
+func (a *CMSAuth) verificationKeys() []namedKey {
+	a.kmutex.RLock()
+	defer a.kmutex.RUnlock()
+	keys := []namedKey{{name: filepath.Base(a.afile), key: a.hkey}}
+	if len(a.prevKey) > 0 && time.Now().Before(a.prevExpire) {
+		keys = append(keys, namedKey{name: "previous", key: a.prevKey})
+	}
+	return append(keys, a.ring...)
+}
+
+// SetKeyRing sets additional HMAC keys accepted for verification of incoming
+// requests, e.g. keys of frontends which rotate keys at different times. Each
+// path can be either a key file or a directory with key files, the key name is
+// the file name. Requests are still signed with the key provided in Init. The
+// name of key which verified the request is set in cms-auth-key header.
+func (a *CMSAuth) SetKeyRing(paths ...string) error {
+	var ring []namedKey
+	for _, p := range paths {
+		info, err := os.Stat(p)
 		if err != nil {
-			msg := fmt.Sprintf("CMSAuth, unable to read %s, error %v", afile, err)
-			fmt.Println(msg)
-			return
+			return err
+		}
+		files := []string{p}
+		if info.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return err
+			}
+			files = nil
+			for _, e := range entries {
+				if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+		for _, fname := range files {
+			hkey, err := os.ReadFile(fname)
+			if err != nil {
+				return err
+			}
+			if len(hkey) == 0 {
+				return fmt.Errorf("HMAC key file %s is empty", fname)
+			}
+			ring = append(ring, namedKey{name: filepath.Base(fname), key: hkey})
 		}
-		a.hkey = hkey
 	}
+	a.kmutex.Lock()
+	a.ring = ring
+	a.kmutex.Unlock()
+	return nil
 }
 
-// helper function which checks Authentication
-func (a *CMSAuth) checkAuthentication(headers http.Header) bool {
-	var val interface{}
-	val = headers["cms-auth-status"]
-	if val == nil {
+// AddPeerKey registers HMAC key of trusted peer realm. Requests carrying
+// cms-authn-realm header will be verified with the key of that realm, while
+// requests without it are verified with the local key.
+func (a *CMSAuth) AddPeerKey(realm string, key []byte) {
+	a.kmutex.Lock()
+	defer a.kmutex.Unlock()
+	if a.peerKeys == nil {
+		a.peerKeys = make(map[string][]byte)
+	}
+	a.peerKeys[realm] = key
+}
+
+// helper function to return HMAC key of given peer realm
+func (a *CMSAuth) peerKey(realm string) ([]byte, bool) {
+	a.kmutex.RLock()
+	defer a.kmutex.RUnlock()
+	key, ok := a.peerKeys[realm]
+	return key, ok
+}
+
+// SetRequiredHeaders sets list of headers which must be present in request,
+// otherwise authentication fails regardless of HMAC validity
+func (a *CMSAuth) SetRequiredHeaders(headers ...string) {
+	a.required = nil
+	for _, h := range headers {
+		a.required = append(a.required, strings.ToLower(h))
+	}
+}
+
+// helper function to check that all required headers are present
+func (a *CMSAuth) hasRequiredHeaders(headers map[string][]string) bool {
+	if len(a.required) == 0 {
+		return true
+	}
+	present := make(map[string]bool)
+	for key, values := range headers {
+		if len(values) > 0 && values[0] != "" {
+			present[strings.ToLower(key)] = true
+		}
+	}
+	for _, key := range a.required {
+		if !present[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetHmacScheme sets HMAC scheme used to sign requests
+func (a *CMSAuth) SetHmacScheme(scheme HmacScheme) error {
+	if scheme.hashFunc() == nil {
+		return fmt.Errorf("unsupported HMAC scheme %s", scheme)
+	}
+	a.scheme = scheme
+	return nil
+}
+
+// SetAcceptedSchemes sets list of HMAC schemes accepted during verification,
+// e.g. to accept both legacy and new schemes during migration period. By
+// default only signing scheme is accepted.
+func (a *CMSAuth) SetAcceptedSchemes(schemes ...HmacScheme) error {
+	for _, scheme := range schemes {
+		if scheme.hashFunc() == nil {
+			return fmt.Errorf("unsupported HMAC scheme %s", scheme)
+		}
+	}
+	a.accepted = schemes
+	return nil
+}
+
+// helper function to return HMAC scheme used for signing
+func (a *CMSAuth) signingScheme() HmacScheme {
+	if a.scheme == "" {
+		return HmacSHA1
+	}
+	return a.scheme
+}
+
+// helper function to return HMAC schemes accepted during verification
+func (a *CMSAuth) acceptedSchemes() []HmacScheme {
+	if len(a.accepted) == 0 {
+		return []HmacScheme{a.signingScheme()}
+	}
+	return a.accepted
+}
+
+// helper function to check that signing timestamp is within allowed clock skew
+func (a *CMSAuth) validTimestamp(timestamp string) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
 		return false
 	}
-	values := val.([]string)
+	skew := a.TimestampSkew
+	if skew == 0 {
+		skew = DefaultTimestampSkew
+	}
+	diff := time.Since(time.Unix(sec, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= skew
+}
+
+// helper function which checks Authentication
+func (a *CMSAuth) checkAuthentication(headers map[string][]string) bool {
+	return a.checkSignature(headers, "", "")
+}
+
+// helper function which checks Authentication of request signed with given
+// method and host, see SignMethodHost
+func (a *CMSAuth) checkSignature(headers map[string][]string, method, host string) bool {
+	status, _ := a.verifySignature(headers, method, host)
+	return status
+}
+
+// helper function which verifies signature of request signed with given
+// method and host and returns reason of verification failure
+func (a *CMSAuth) verifySignature(headers map[string][]string, method, host string) (bool, string) {
+	// cms-auth-key header is only set by successful verification
+	for key := range headers {
+		if strings.EqualFold(key, a.HeaderKey("auth-key")) {
+			delete(headers, key)
+		}
+	}
+	values := lookupHeader(headers, a.HeaderKey("auth-status"))
+	if values == nil {
+		return false, "missing_status"
+	}
 	if len(values) == 1 && values[0] == "NONE" {
-		// user authentication is optional
-		return true
+		// user authentication is optional, but unauthenticated request should
+		// not carry cms-authn/cms-authz headers which can only be injected
+		// by the client
+		if a.StrictMode {
+			return false, "strict_mode"
+		}
+		if hasAuthHeaders(headers, a.headerPrefix()) {
+			return false, "injected_headers"
+		}
+		return true, ""
+	}
+	if !a.hasRequiredHeaders(headers) {
+		return false, "required_headers"
 	}
 	var hkeys []string
 	for kkk := range headers {
 		hkeys = append(hkeys, kkk)
 	}
 	sort.Sort(StringList(hkeys))
-	var prefix, suffix, hmacValue string
+	var hmacValue, realm, timestamp string
+	signed := make(map[string][]string)
+	aliases := make(map[string][]string)
 	for _, kkk := range hkeys {
 		values := headers[kkk]
 		key := strings.ToLower(kkk)
-		if (strings.HasPrefix(key, "cms-authn") || strings.HasPrefix(key, "cms-authz")) && key != "cms-authn-hmac" {
-			prefix += fmt.Sprintf("h%xv%x", len(key), len(values[0]))
-			suffix += fmt.Sprintf("%s%s", key, values[0])
-			if strings.HasPrefix(key, "cms-authn") {
+		if a.signedHeader(key) {
+			signed[key] = append(signed[key], a.signedValues(values)...)
+			if strings.HasPrefix(key, a.HeaderKey("authn")) {
 				// here the new header "Dn" appears, i.e. cms-authn-dn => dn
-				headers[strings.Replace(key, "cms-authn-", "", 1)] = values
+				aliases[strings.Replace(key, a.HeaderKey("authn-"), "", 1)] = values
 			}
 		}
-		if key == "cms-authn-hmac" {
+		if key == a.HeaderKey("authn-hmac") {
 			hmacValue = values[0]
 		}
+		if key == a.HeaderKey("authn-realm") {
+			realm = values[0]
+		}
+		if key == a.HeaderKey("authn-timestamp") {
+			timestamp = values[0]
+		}
 	}
-	value := []byte(fmt.Sprintf("%s#%s", prefix, suffix))
-	var sha1hex hash.Hash
-	if len(a.afile) != 0 {
-		sha1hex = hmac.New(sha1.New, a.hkey)
-	} else {
-		sha1hex = sha1.New()
+	if timestamp != "" && !a.validTimestamp(timestamp) {
+		return false, "timestamp"
 	}
-	sha1hex.Write(value)
-	hmacFound := fmt.Sprintf("%x", sha1hex.Sum(nil))
-	if hmacFound != hmacValue {
-		return false
+	// select peer realm key if request was signed by trusted peer realm
+	keys := a.verificationKeys()
+	if realm != "" {
+		pkey, ok := a.peerKey(realm)
+		if !ok {
+			return false, "unknown_realm"
+		}
+		keys = []namedKey{{name: realm, key: pkey}}
 	}
-	return true
+	value := []byte(a.bindRequest(canonicalSignValues(signed), method, host))
+	// accept request if any of accepted keys and schemes produces the same HMAC
+	for _, nkey := range keys {
+		for _, scheme := range a.acceptedSchemes() {
+			var hexHash hash.Hash
+			if len(a.afile) != 0 || realm != "" {
+				if len(nkey.key) == 0 {
+					// key is not loaded, e.g. Init failed to read auth
+					// file, requests signed with empty key are rejected
+					continue
+				}
+				hexHash = hmac.New(scheme.hashFunc(), nkey.key)
+			} else {
+				hexHash = scheme.hashFunc()()
+			}
+			hexHash.Write(value)
+			// use constant time comparison to avoid timing attacks
+			if hmac.Equal([]byte(fmt.Sprintf("%x", hexHash.Sum(nil))), []byte(hmacValue)) {
+				// alias headers are only set for verified requests
+				for key, values := range aliases {
+					headers[key] = values
+				}
+				headers[a.HeaderKey("auth-key")] = []string{nkey.name}
+				return true, ""
+			}
+		}
+	}
+	return false, "hmac_mismatch"
+}
+
+// helper function to check if headers contain authn or authz headers of
+// given prefix
+func hasAuthHeaders(headers map[string][]string, prefix string) bool {
+	for key := range headers {
+		k := strings.ToLower(key)
+		if strings.HasPrefix(k, headerKey(prefix, "authn")) || strings.HasPrefix(k, headerKey(prefix, "authz")) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignHeaders calculates HMAC signature of given headers. The signature is
+// computed over CanonicalSignString of cms-authn and cms-authz headers, except
+// cms-authn-hmac and excluded headers, which is the serialization used by
+// CMS frontend. The HTTP method and host are not included, use GetHmac for
+// requests when SignMethodHost is enabled.
+func (a *CMSAuth) SignHeaders(h http.Header) (string, error) {
+	hkey := a.key()
+	if len(hkey) == 0 {
+		return "", errors.New("CMSAuth HMAC key is not initialized")
+	}
+	val := a.hmacInput(h, a.hmacHeaders(h))
+	return hmacDigest(a.signingScheme(), hkey, val), nil
+}
+
+// VerifyHeaders verifies HMAC signature provided by cms-authn-hmac header of
+// given headers and returns an error describing verification failure
+func (a *CMSAuth) VerifyHeaders(h http.Header) error {
+	if len(a.key()) == 0 {
+		return errors.New("CMSAuth HMAC key is not initialized")
+	}
+	if len(lookupHeader(h, a.HeaderKey("auth-status"))) == 0 {
+		return authErrorf(ErrNoAuthHeaders, "missing %s header", a.HeaderKey("auth-status"))
+	}
+	if len(lookupHeader(h, a.HeaderKey("authn-hmac"))) == 0 {
+		return authErrorf(ErrHmacMismatch, "missing %s header", a.HeaderKey("authn-hmac"))
+	}
+	// use copy of headers since signature check sets alias headers
+	if status, reason := a.verifySignature(h.Clone(), "", ""); !status {
+		return authErrorf(ReasonError(reason), "reason %s", reason)
+	}
+	return nil
+}
+
+// SignString returns string used as HMAC input for given headers, it is
+// useful for debugging HMAC mismatches between signing and verifying peers
+func (a *CMSAuth) SignString(h http.Header) string {
+	return a.hmacInput(h, a.hmacHeaders(h))
 }
 
 // GetHmac calculates hmac value from request headers
 func (a *CMSAuth) GetHmac(r *http.Request, verbose bool) (string, error) {
+	return a.GetHmacWithKey(r, a.key(), verbose)
+}
+
+// GetHmacWithKey calculates hmac value from request headers using given key
+// instead of the one loaded into CMSAuth
+func (a *CMSAuth) GetHmacWithKey(r *http.Request, key []byte, verbose bool) (string, error) {
+	val := a.bindRequest(a.hmacInput(r.Header, a.hmacHeaders(r.Header)), r.Method, r.Host)
+	hmac := hmacDigest(a.signingScheme(), key, val)
+	if verbose {
+		GetLogger().Debugf("key sha256:%s", keyFingerprint(key))
+		GetLogger().Infof("val %s", val)
+	}
+	return hmac, nil
+}
+
+// helper function to return truncated SHA256 fingerprint of HMAC key which
+// identifies the key in logs without revealing it
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+// DescribeSigning returns multi-line description of HMAC construction for
+// given request. It lists signed headers along with their key and value
+// lengths, the signed string and resulting digest, but never the secret key.
+func (a *CMSAuth) DescribeSigning(r *http.Request) string {
+	hkeys := a.hmacHeaders(r.Header)
+	val := a.bindRequest(a.hmacInput(r.Header, hkeys), r.Method, r.Host)
+	var lines []string
+	lines = append(lines, fmt.Sprintf("signed headers: %d", len(hkeys)))
+	for _, h := range hkeys {
+		var size int
+		for _, v := range a.signedValues(r.Header[h]) {
+			size += len(v)
+		}
+		lines = append(lines, fmt.Sprintf("  %s key_len=%d value_len=%d", strings.ToLower(h), len(h), size))
+	}
+	lines = append(lines, fmt.Sprintf("scheme: %s", a.signingScheme()))
+	lines = append(lines, fmt.Sprintf("canonicalize: %v", a.Canonicalize))
+	lines = append(lines, fmt.Sprintf("urlencode: %v", a.URLEncode))
+	lines = append(lines, fmt.Sprintf("sign method and host: %v", a.SignMethodHost))
+	lines = append(lines, fmt.Sprintf("val: %s", val))
+	lines = append(lines, fmt.Sprintf("digest: %s", hmacDigest(a.signingScheme(), a.key(), val)))
+	return strings.Join(lines, "\n")
+}
+
+// SignedHeaderKeys returns sorted list of lower-case header keys which are
+// included in HMAC computation by GetHmac and checkAuthentication
+func (a *CMSAuth) SignedHeaderKeys(h http.Header) []string {
+	var keys []string
+	for _, k := range a.hmacHeaders(h) {
+		key := strings.ToLower(k)
+		if !contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// helper function to return sorted list of headers used in HMAC computation
+func (a *CMSAuth) hmacHeaders(header http.Header) []string {
 	var hkeys []string
-	for h := range r.Header {
-		key := strings.ToLower(h)
-		if (strings.HasPrefix(key, "cms-authn") || strings.HasPrefix(key, "cms-authz")) && key != "cms-authn-hmac" {
+	for h := range header {
+		if a.signedHeader(strings.ToLower(h)) {
 			hkeys = append(hkeys, h)
 		}
 	}
-	var prefix, suffix string
 	sort.Sort(StringList(hkeys))
-	for _, h := range hkeys {
-		v := r.Header.Get(h)
-		prefix = fmt.Sprintf("%sh%xv%x", prefix, len(h), len(v))
-		suffix = fmt.Sprintf("%s%s%s", suffix, strings.ToLower(h), v)
-	}
-	val := fmt.Sprintf("%s#%s", prefix, suffix)
-	var sha1hex hash.Hash
-	sha1hex = hmac.New(sha1.New, a.hkey)
-	sha1hex.Write([]byte(val))
-	hmac := fmt.Sprintf("%x", sha1hex.Sum(nil))
-	if verbose {
-		fmt.Println("key", string(a.hkey))
-		fmt.Println("val", val)
+	return hkeys
+}
+
+// SetHmacExcludeHeaders sets list of cms-authn/cms-authz headers which are
+// excluded from HMAC computation. The same list should be used on both signing
+// and verifying sides.
+func (a *CMSAuth) SetHmacExcludeHeaders(headers ...string) {
+	a.hexclude = make(map[string]bool)
+	for _, h := range headers {
+		a.hexclude[strings.ToLower(h)] = true
 	}
-	return hmac, nil
 }
 
-// helper function to perform authorization action
-func (a *CMSAuth) checkAuthorization(header http.Header) bool {
+// helper function to check if given lower-case header key is used in HMAC computation
+func (a *CMSAuth) signedHeader(key string) bool {
+	if !strings.HasPrefix(key, a.HeaderKey("authn")) && !strings.HasPrefix(key, a.HeaderKey("authz")) {
+		return false
+	}
+	if key == a.HeaderKey("authn-hmac") || a.hexclude[key] {
+		return false
+	}
 	return true
 }
 
+// VerifyDetached verifies detached HMAC signature, e.g. provided by webhook
+// in a single header, computed over given headers. The headers are
+// canonicalized in the same way as cms-authn/cms-authz headers, i.e. sorted
+// by lower-case keys, and signature is compared in constant time.
+func (a *CMSAuth) VerifyDetached(headers map[string]string, signature string) (bool, error) {
+	hkey := a.key()
+	if len(hkey) == 0 {
+		return false, errors.New("CMSAuth HMAC key is not initialized")
+	}
+	if signature == "" {
+		return false, errors.New("empty signature")
+	}
+	values := make(map[string]string)
+	for k, v := range headers {
+		values[strings.ToLower(k)] = a.canonicalValue(v)
+	}
+	val := CanonicalSignString(values)
+	expect := hmacDigest(a.signingScheme(), hkey, val)
+	return hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))), nil
+}
+
+// CanonicalSignString returns string used as HMAC input for given headers.
+// The header keys are lower-cased and sorted byte-wise, then for every header
+// the prefix part "h<key length in hex>v<value length in hex>" and the suffix
+// part "<key><value>" are accumulated, and final string is "prefix#suffix".
+// For example, {"cms-authn-name": "user"} produces "hev4#cms-authn-nameuser".
+// Headers with multiple values, see canonicalSignValues, use prefix part
+// "h<key length>m<number of values>v<value length>..." and suffix part with
+// all values concatenated, e.g. {"cms-authz-user": {"a", "b"}} produces
+// "hem2v1v1#cms-authz-userab", therefore values are never ambiguous.
+// The header values are used as is, i.e. callers should apply
+// canonicalization, if any, before calling this function.
+func CanonicalSignString(headers map[string]string) string {
+	values := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		values[k] = []string{v}
+	}
+	return canonicalSignValues(values)
+}
+
+// helper function to build canonical sign string of headers with one or
+// more values, see CanonicalSignString
+func canonicalSignValues(headers map[string][]string) string {
+	lower := make(map[string][]string)
+	var keys []string
+	for k, v := range headers {
+		key := strings.ToLower(k)
+		if _, ok := lower[key]; !ok {
+			keys = append(keys, key)
+		}
+		lower[key] = v
+	}
+	sort.Strings(keys)
+	// the sign string is built in single buffer, prefix holds hex lengths of
+	// keys and values and suffix holds keys and values themselves
+	size := 1
+	for _, k := range keys {
+		size += len(k) + 4
+		for _, v := range lower[k] {
+			size += len(v) + 4
+		}
+	}
+	buf := make([]byte, 0, size)
+	for _, k := range keys {
+		buf = append(buf, 'h')
+		buf = strconv.AppendInt(buf, int64(len(k)), 16)
+		if vals := lower[k]; len(vals) != 1 {
+			buf = append(buf, 'm')
+			buf = strconv.AppendInt(buf, int64(len(vals)), 16)
+		}
+		for _, v := range lower[k] {
+			buf = append(buf, 'v')
+			buf = strconv.AppendInt(buf, int64(len(v)), 16)
+		}
+	}
+	buf = append(buf, '#')
+	for _, k := range keys {
+		buf = append(buf, k...)
+		for _, v := range lower[k] {
+			buf = append(buf, v...)
+		}
+	}
+	return string(buf)
+}
+
+// helper function to add request method and host to HMAC input string
+func (a *CMSAuth) bindRequest(val, method, host string) string {
+	if !a.SignMethodHost {
+		return val
+	}
+	return val + "#" + strings.ToUpper(method) + "#" + strings.ToLower(host)
+}
+
+// helper function to build HMAC input string from given headers, values of
+// headers which keys differ only by case are signed as values of one header
+func (a *CMSAuth) hmacInput(header http.Header, hkeys []string) string {
+	signed := make(map[string][]string)
+	for _, h := range hkeys {
+		key := strings.ToLower(h)
+		signed[key] = append(signed[key], a.signedValues(header[h])...)
+	}
+	return canonicalSignValues(signed)
+}
+
+// helper function to compute HMAC hex digest of given value
+func hmacDigest(scheme HmacScheme, key []byte, val string) string {
+	var hexHash hash.Hash
+	hexHash = hmac.New(scheme.hashFunc(), key)
+	io.WriteString(hexHash, val)
+	return hex.EncodeToString(hexHash.Sum(nil))
+}
+
+// CanonicalHeaderValue returns given header value with leading and trailing
+// whitespace removed and internal whitespace collapsed into a single space
+func CanonicalHeaderValue(v string) string {
+	return strings.Join(strings.Fields(v), " ")
+}
+
+// helper function to return header values used in HMAC computation, every
+// value is signed separately, see CanonicalSignString
+func (a *CMSAuth) signedValues(values []string) []string {
+	vals := make([]string, len(values))
+	for i, v := range values {
+		vals[i] = a.canonicalValue(v)
+	}
+	return vals
+}
+
+// helper function to return canonical header value used in HMAC computation
+func (a *CMSAuth) canonicalValue(v string) string {
+	if a.Canonicalize {
+		v = CanonicalHeaderValue(v)
+	}
+	if a.URLEncode {
+		v = url.QueryEscape(v)
+	}
+	return v
+}
+
+// SetAuthorizer sets authorizer used by CheckAuthnAuthz after successful
+// authentication, by default HeaderAuthorizer is used
+func (a *CMSAuth) SetAuthorizer(authorizer Authorizer) {
+	a.authorizer = authorizer
+}
+
+// SetDecisionCache sets cache of authorization decisions used by
+// CheckAuthnAuthz, nil disables caching. Cached decisions are keyed by user
+// login, request path, method and user roles, therefore the cache should be
+// invalidated when Authorizer depends on other data, e.g. CRIC records.
+func (a *CMSAuth) SetDecisionCache(cache *DecisionCache) {
+	a.decisions = cache
+}
+
+// helper function to perform authorization action for given request path
+func (a *CMSAuth) checkAuthorization(header http.Header, method, uri string) bool {
+	authorizer := a.authorizer
+	if authorizer == nil {
+		authorizer = HeaderAuthorizer{}
+	}
+	user := a.UserFromHeader(header)
+	var key [sha256.Size]byte
+	if a.decisions != nil {
+		key = decisionKey(user, uri, method)
+		if status, ok := a.decisions.get(key); ok {
+			return status
+		}
+	}
+	status, err := authorizer.Authorize(context.Background(), user, uri)
+	if err != nil {
+		GetLogger().Errorf("CMSAuth, unable to authorize user %s, error %v", user.Login, err)
+		return false
+	}
+	if a.decisions != nil {
+		a.decisions.add(key, status)
+	}
+	return status
+}
+
 // CheckAuthnAuthz function performs Authentication and Authorization
 func (a *CMSAuth) CheckAuthnAuthz(header http.Header) bool {
+	return a.CheckAuthnAuthzMap(header)
+}
+
+// CheckAuthnAuthzMap function performs Authentication and Authorization of
+// headers provided by non HTTP carrier, e.g. gRPC metadata. The header keys
+// are matched case-insensitively. Since request path is not known the
+// Authorizer is given the value of cms-request-uri header which is not
+// covered by HMAC signature, i.e. path based authorization relies on the
+// frontend setting it. Use CheckAuthnAuthzRequest for HTTP requests.
+func (a *CMSAuth) CheckAuthnAuthzMap(headers map[string][]string) bool {
 	if a.afile == "" { // no auth file is provided
 		return true
 	}
-	status := a.checkAuthentication(header)
-	if !status {
-		return status
+	var uri string
+	if values := lookupHeader(headers, a.HeaderKey("request-uri")); len(values) > 0 {
+		uri = values[0]
 	}
-	return a.checkAuthorization(header)
+	status, _ := a.authnAuthz(headers, "", "", uri)
+	return status
+}
+
+// helper function which performs Authentication and Authorization of request
+// signed with given method and host and authorizes access to given request
+// path. It records decision in metrics and audit log and returns reason of
+// failure.
+func (a *CMSAuth) authnAuthz(headers map[string][]string, method, host, uri string) (bool, string) {
+	status, reason := a.verifySignature(headers, method, host)
+	if status && !a.checkAuthorization(http.Header(headers), method, uri) {
+		status, reason = false, "authorization"
+	}
+	if status {
+		recordAuthSuccess()
+	} else {
+		recordAuthFailure(reason)
+	}
+	a.audit(headers, uri, status, reason)
+	return status, reason
+}
+
+// HeaderValues returns values of given header key. Unlike http.Header.Values
+// the key is matched case-insensitively, i.e. it works for both canonical
+// http.Header keys (Cms-Auth-Status) and raw lower-case keys (cms-auth-status)
+// used by CMS frontend and non HTTP carriers.
+func HeaderValues(headers map[string][]string, key string) []string {
+	return lookupHeader(headers, key)
+}
+
+// HeaderValue returns first value of given header key matched
+// case-insensitively, see HeaderValues
+func HeaderValue(headers map[string][]string, key string) string {
+	if values := lookupHeader(headers, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// helper function to lookup header values by case-insensitive key
+func lookupHeader(headers map[string][]string, key string) []string {
+	if values, ok := headers[key]; ok {
+		return values
+	}
+	if values, ok := headers[http.CanonicalHeaderKey(key)]; ok {
+		return values
+	}
+	for k, values := range headers {
+		if strings.EqualFold(k, key) {
+			return values
+		}
+	}
+	return nil
+}
+
+// CheckAuthnAuthzRequest function performs Authentication and Authorization
+// of given HTTP request, it should be used when SignMethodHost is enabled.
+// The Authorizer is given path of request URL.
+func (a *CMSAuth) CheckAuthnAuthzRequest(r *http.Request) bool {
+	if a.afile == "" { // no auth file is provided
+		return true
+	}
+	status, _ := a.authnAuthz(r.Header, r.Method, r.Host, r.URL.Path)
+	return status
+}
+
+// Verify performs Authentication and Authorization of given HTTP request and
+// returns information about authenticated user. Unlike CheckAuthnAuthzRequest
+// it reports the failure as AuthError, e.g. ErrHmacMismatch or ErrRoleDenied,
+// which can be mapped to HTTP status code with ErrorStatus.
+func (a *CMSAuth) Verify(r *http.Request) (*UserInfo, error) {
+	if a.afile != "" {
+		if status, reason := a.authnAuthz(r.Header, r.Method, r.Host, r.URL.Path); !status {
+			return nil, ReasonError(reason)
+		}
+	}
+	switch HeaderValue(r.Header, a.HeaderKey("auth-status")) {
+	case "":
+		return nil, ErrNoAuthHeaders
+	case "NONE":
+		return nil, ErrNotAuthenticated
+	}
+	info, err := a.GetUserInfo(r)
+	if err != nil {
+		return nil, authErrorf(ErrNotAuthenticated, "%v", err)
+	}
+	if !info.Expiry.IsZero() && time.Now().After(info.Expiry) {
+		return nil, authErrorf(ErrExpiredToken, "expired on %v", info.Expiry)
+	}
+	if info.Login == "" {
+		return nil, ErrUserNotInCric
+	}
+	return info, nil
 }
 
 // CheckCMSAuthz function performs CMS Authorization based on provided
 // role and group or site attributes
 func (a *CMSAuth) CheckCMSAuthz(header http.Header, role, group, site string) bool {
+	status, _, _ := a.CheckCMSAuthzDetail(header, role, group, site)
+	return status
+}
+
+// CheckCMSAuthzDetail function performs CMS Authorization based on provided
+// role and group or site attributes. Along with authorization decision it
+// returns matched role header and matched group or site token.
+func (a *CMSAuth) CheckCMSAuthzDetail(header http.Header, role, group, site string) (bool, string, string) {
+	if a.roleMapper != nil {
+		header = a.roleMapper.mapHeader(header, a.headerPrefix())
+	}
 	for key, vals := range header {
-		if strings.HasPrefix(strings.ToLower(key), "cms-authz") && strings.Contains(strings.ToLower(key), strings.ToLower(role)) {
+		if roleHeaderMatch(key, a.headerPrefix(), role) {
 			for _, val := range vals {
 				v := strings.ToLower(val)
 				if strings.Contains(v, strings.ToLower(group)) || strings.Contains(v, strings.ToLower(site)) {
-					return true
+					return true, key, matchedToken(val, group, site)
+				}
+			}
+		}
+	}
+	return false, "", ""
+}
+
+// RoleHeaderKey returns cms-authz header key for given CRIC role. The role is
+// lower-cased and any character other than a-z, 0-9 and dash is replaced by
+// dash, e.g. "Data Manager" becomes cms-authz-data-manager
+func RoleHeaderKey(role string) string {
+	return roleHeaderKey(DefaultHeaderPrefix, role)
+}
+
+// RoleHeaderKey returns authz header key for given CRIC role in configured
+// namespace, see RoleHeaderKey function
+func (a *CMSAuth) RoleHeaderKey(role string) string {
+	return roleHeaderKey(a.headerPrefix(), role)
+}
+
+// helper function to return authz header key of given role and prefix
+func roleHeaderKey(prefix, role string) string {
+	return headerKey(prefix, "authz-"+normalizeRole(role))
+}
+
+// helper function to normalize role name
+func normalizeRole(role string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(role) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// helper function to check if given header key is authz header of given
+// prefix and role, empty role matches any authz header
+func roleHeaderMatch(key, prefix, role string) bool {
+	key = strings.ToLower(key)
+	if !strings.HasPrefix(key, headerKey(prefix, "authz")) {
+		return false
+	}
+	if role == "" {
+		return true
+	}
+	return key == roleHeaderKey(prefix, role)
+}
+
+// helper function to find group or site token within header value
+func matchedToken(val, group, site string) string {
+	for _, token := range strings.Fields(val) {
+		t := strings.ToLower(token)
+		if group != "" && strings.Contains(t, strings.ToLower(group)) {
+			return token
+		}
+		if site != "" && strings.Contains(t, strings.ToLower(site)) {
+			return token
+		}
+	}
+	return val
+}
+
+// AuthzExplanation describes CMS authorization decision made by CheckCMSAuthz
+type AuthzExplanation struct {
+	Allowed       bool     `json:"allowed"`        // authorization decision
+	Examined      []string `json:"examined"`       // examined cms-authz headers
+	RoleMatched   []string `json:"role_matched"`   // cms-authz headers matched the role
+	MatchedHeader string   `json:"matched_header"` // header which granted access
+	MatchedToken  string   `json:"matched_token"`  // group or site token which granted access
+	Reason        string   `json:"reason"`         // human readable reason of the decision
+}
+
+// ExplainAuthz explains CMS authorization decision for given role and group
+// or site attributes, e.g. to debug authorization denials
+func (a *CMSAuth) ExplainAuthz(header http.Header, role, group, site string) AuthzExplanation {
+	var exp AuthzExplanation
+	for key := range header {
+		if strings.HasPrefix(strings.ToLower(key), a.HeaderKey("authz")) {
+			exp.Examined = append(exp.Examined, key)
+			if roleHeaderMatch(key, a.headerPrefix(), role) {
+				exp.RoleMatched = append(exp.RoleMatched, key)
+			}
+		}
+	}
+	sort.Strings(exp.Examined)
+	sort.Strings(exp.RoleMatched)
+	exp.Allowed, exp.MatchedHeader, exp.MatchedToken = a.CheckCMSAuthzDetail(header, role, group, site)
+	switch {
+	case exp.Allowed:
+		exp.Reason = fmt.Sprintf("granted via %s with %s", exp.MatchedHeader, exp.MatchedToken)
+	case len(exp.Examined) == 0:
+		exp.Reason = "no cms-authz headers found"
+	case len(exp.RoleMatched) == 0:
+		exp.Reason = fmt.Sprintf("no cms-authz header matches role %s", role)
+	default:
+		var vals []string
+		for _, key := range exp.RoleMatched {
+			vals = append(vals, header[key]...)
+		}
+		exp.Reason = fmt.Sprintf("role %s matched but neither group %s nor site %s found in %v", role, group, site, vals)
+	}
+	return exp
+}
+
+// AuthzRequirement represents role and group or site requirement of a policy
+type AuthzRequirement struct {
+	Role  string `json:"role"`  // required role
+	Group string `json:"group"` // required group
+	Site  string `json:"site"`  // required site
+}
+
+// RequirementResult represents evaluation outcome of single policy requirement
+type RequirementResult struct {
+	Requirement   AuthzRequirement `json:"requirement"`    // evaluated requirement
+	Satisfied     bool             `json:"satisfied"`      // requirement is satisfied
+	MatchedHeader string           `json:"matched_header"` // header which satisfied requirement
+	MatchedToken  string           `json:"matched_token"`  // group or site token which satisfied requirement
+}
+
+// PolicyResult represents evaluation outcome of policy requirements
+type PolicyResult struct {
+	Allowed bool                `json:"allowed"` // all requirements are satisfied
+	Results []RequirementResult `json:"results"` // per requirement outcome
+}
+
+// Satisfied returns list of satisfied requirements
+func (p PolicyResult) Satisfied() []AuthzRequirement {
+	var reqs []AuthzRequirement
+	for _, r := range p.Results {
+		if r.Satisfied {
+			reqs = append(reqs, r.Requirement)
+		}
+	}
+	return reqs
+}
+
+// EvaluatePolicy evaluates each policy requirement against cms-authz headers.
+// Empty group and site of requirement are not matched, i.e. requirement with
+// role only is satisfied by any header of that role.
+func (a *CMSAuth) EvaluatePolicy(header http.Header, requirements []AuthzRequirement) PolicyResult {
+	result := PolicyResult{Allowed: len(requirements) > 0}
+	for _, req := range requirements {
+		res := RequirementResult{Requirement: req}
+		res.Satisfied, res.MatchedHeader, res.MatchedToken = matchRequirement(header, a.headerPrefix(), req)
+		if !res.Satisfied {
+			result.Allowed = false
+		}
+		result.Results = append(result.Results, res)
+	}
+	return result
+}
+
+// helper function to match policy requirement against cms-authz headers
+func matchRequirement(header http.Header, prefix string, req AuthzRequirement) (bool, string, string) {
+	var keys []string
+	for key := range header {
+		if roleHeaderMatch(key, prefix, req.Role) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	group := strings.ToLower(req.Group)
+	site := strings.ToLower(req.Site)
+	for _, key := range keys {
+		for _, val := range header[key] {
+			if group == "" && site == "" {
+				return true, key, val
+			}
+			v := strings.ToLower(val)
+			if (group != "" && strings.Contains(v, group)) || (site != "" && strings.Contains(v, site)) {
+				return true, key, matchedToken(val, req.Group, req.Site)
+			}
+		}
+	}
+	return false, "", ""
+}
+
+// AuthorizeDN performs CMS Authorization of given DN directly against CRIC
+// records, i.e. without HTTP headers. The CRIC entry is found by sorted DN
+// among primary and secondary DNs of CRIC records, and its role should
+// contain given group or site token.
+func (a *CMSAuth) AuthorizeDN(records CricRecords, dn, role, group string) (bool, error) {
+	rec, ok := findCricEntryByDN(records, dn)
+	if !ok {
+		return false, authErrorf(ErrUserNotInCric, "DN %s is not found in CRIC records", dn)
+	}
+	for r, vals := range rec.Roles {
+		if normalizeRole(r) != normalizeRole(role) {
+			continue
+		}
+		if group == "" {
+			return true, nil
+		}
+		for _, v := range vals {
+			if strings.Contains(strings.ToLower(v), strings.ToLower(group)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// helper function to find CRIC entry by its primary or secondary DN
+func findCricEntryByDN(records CricRecords, dn string) (CricEntry, bool) {
+	sortedDN := GetSortedDN(dn)
+	if rec, ok := records[sortedDN]; ok {
+		return rec, true
+	}
+	for _, rec := range records {
+		if hasCricDN(rec, sortedDN) {
+			return rec, true
+		}
+	}
+	return CricEntry{}, false
+}
+
+// helper function to check if CRIC entry has given sorted primary or
+// secondary DN
+func hasCricDN(rec CricEntry, sortedDN string) bool {
+	for _, dn := range append([]string{rec.DN}, rec.DNs...) {
+		if dn != "" && GetSortedDN(dn) == sortedDN {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCMSAuthzHierarchical function performs CMS Authorization based on provided
+// role and hierarchical group, e.g. group:cms/dbs/expert implies membership in
+// group:cms/dbs and group:cms
+func (a *CMSAuth) CheckCMSAuthzHierarchical(header http.Header, role, group string) bool {
+	group = strings.TrimSuffix(strings.ToLower(group), "/")
+	if group == "" {
+		return false
+	}
+	for key, vals := range header {
+		if roleHeaderMatch(key, a.headerPrefix(), role) {
+			for _, val := range vals {
+				for _, g := range strings.Fields(strings.ToLower(val)) {
+					if g == group || strings.HasPrefix(g, group+"/") {
+						return true
+					}
 				}
 			}
 		}
@@ -150,51 +1194,92 @@ func (a *CMSAuth) CheckCMSAuthz(header http.Header, role, group, site string) bo
 // SetCMSHeaders sets HTTP headers for given http request based on on provider user and CRIC data
 func (a *CMSAuth) SetCMSHeaders(r *http.Request, userData map[string]interface{}, cricRecords CricRecords, verbose bool) {
 	// set cms auth headers
-	r.Header.Set("cms-auth-status", "ok")
-	r.Header.Set("cms-authn-name", iString(userData["name"]))
+	r.Header.Set(a.HeaderKey("auth-status"), "ok")
+	r.Header.Set(a.HeaderKey("authn-name"), iString(userData["name"]))
 	login := iString(userData["cern_upn"])
 	dn := iString(userData["dn"])
 	sortedDN := GetSortedDN(dn)
 	if rec, ok := cricRecords[sortedDN]; ok {
 		// set DN
-		r.Header.Set("cms-authn-dn", rec.DN)
-		r.Header.Set("cms-authn-sorted-dn", rec.SortedDN)
-		r.Header.Set("cms-auth-cert", rec.DN)
+		r.Header.Set(a.HeaderKey("authn-dn"), rec.DN)
+		r.Header.Set(a.HeaderKey("authn-sorted-dn"), rec.SortedDN)
+		r.Header.Set(a.HeaderKey("auth-cert"), rec.DN)
 		// set group roles
-		for k, v := range rec.Roles {
-			key := fmt.Sprintf("cms-authz-%s", k)
+		for k, v := range a.mappedRoles(rec.Roles) {
+			key := a.RoleHeaderKey(k)
 			val := strings.Join(v, " ")
 			r.Header.Set(key, val)
 		}
+		if a.AffiliationHeaders {
+			a.setAffiliationHeaders(r, rec)
+		}
+	}
+	a.setDNHeaders(r, userData)
+	r.Header.Set(a.HeaderKey("authn-login"), login)
+	r.Header.Set(a.HeaderKey("authn-method"), "X509Cert")
+	r.Header.Set(a.HeaderKey("cern-id"), iString(userData["cern_person_id"]))
+	r.Header.Set(a.HeaderKey("email"), iString(userData["email"]))
+	r.Header.Set(a.HeaderKey("auth-time"), iString(userData["auth_time"]))
+	r.Header.Set(a.HeaderKey("auth-expire"), iString(userData["exp"]))
+	r.Header.Set(a.HeaderKey("session"), iString(userData["session_state"]))
+	r.Header.Set(a.HeaderKey("request-uri"), r.URL.Path)
+	if scopes := ScopesFromClaims(userData); len(scopes) > 0 {
+		r.Header.Set(a.HeaderKey("authn-scope"), strings.Join(scopes, " "))
 	}
-	setDNHeaders(r, userData)
-	r.Header.Set("cms-authn-login", login)
-	r.Header.Set("cms-authn-method", "X509Cert")
-	r.Header.Set("cms-cern-id", iString(userData["cern_person_id"]))
-	r.Header.Set("cms-email", iString(userData["email"]))
-	r.Header.Set("cms-auth-time", iString(userData["auth_time"]))
-	r.Header.Set("cms-auth-expire", iString(userData["exp"]))
-	r.Header.Set("cms-session", iString(userData["session_state"]))
-	r.Header.Set("cms-request-uri", r.URL.Path)
+	r.Header.Set(a.HeaderKey("authn-timestamp"), fmt.Sprintf("%d", time.Now().Unix()))
 	if hmac, err := a.GetHmac(r, verbose); err == nil {
-		r.Header.Set("cms-authn-hmac", hmac)
+		r.Header.Set(a.HeaderKey("authn-hmac"), hmac)
+	}
+}
+
+// SetCMSHeadersClean removes all existing cms-* headers from given http request
+// and sets fresh set of CMS headers based on provided user and CRIC data
+func (a *CMSAuth) SetCMSHeadersClean(r *http.Request, userData map[string]interface{}, cricRecords CricRecords, verbose bool) {
+	a.clearCMSHeaders(r)
+	a.SetCMSHeaders(r, userData, cricRecords, verbose)
+}
+
+// helper function to remove all headers of configured prefix, e.g. cms-*,
+// from http request
+func (a *CMSAuth) clearCMSHeaders(r *http.Request) {
+	for key := range r.Header {
+		if strings.HasPrefix(strings.ToLower(key), a.HeaderKey("")) {
+			delete(r.Header, key)
+		}
+	}
+}
+
+// helper function to set affiliation headers from CRIC record
+func (a *CMSAuth) setAffiliationHeaders(r *http.Request, rec CricEntry) {
+	if rec.Institute != "" {
+		r.Header.Set(a.HeaderKey("authn-institute"), rec.Institute)
+	}
+	if rec.Country != "" {
+		r.Header.Set(a.HeaderKey("authn-country"), rec.Country)
+	}
+	if len(rec.VOs) > 0 {
+		r.Header.Set(a.HeaderKey("authn-vo"), strings.Join(rec.VOs, " "))
+	}
+	if rec.Status != "" {
+		r.Header.Set(a.HeaderKey("authn-status"), rec.Status)
 	}
 }
 
 // helper function to check and set proper CMS DN values in HTTP header
-func setDNHeaders(r *http.Request, userData map[string]interface{}) {
+func (a *CMSAuth) setDNHeaders(r *http.Request, userData map[string]interface{}) {
 	// check that we properly set cms-auth-cert header if it is not set assign DN value to it
-	if r.Header.Get("Cms-Auth-Cert") == "" {
+	if HeaderValue(r.Header, a.HeaderKey("auth-cert")) == "" {
 		if dn, ok := userData["dn"]; ok {
-			r.Header.Set("Cms-Auth-Cert", dn.(string))
+			r.Header.Set(a.HeaderKey("auth-cert"), NormalizeDN(dn.(string)))
 		}
 	}
 	// if CMS user has multiple user DNs then we should set his/her DN properly based on list matched DN
+	// DN provided in RFC 2253 form is set in slash form used by CRIC records
 	if dnValue, ok := userData["dn"]; ok {
-		dn := dnValue.(string)
-		if r.Header.Get("Cms-Authn-Dn") != dn {
-			r.Header.Set("cms-authn-dn", dn)
-			r.Header.Set("cms-auth-cert", dn)
+		dn := NormalizeDN(dnValue.(string))
+		if HeaderValue(r.Header, a.HeaderKey("authn-dn")) != dn {
+			r.Header.Set(a.HeaderKey("authn-dn"), dn)
+			r.Header.Set(a.HeaderKey("auth-cert"), dn)
 		}
 	}
 	// set all DNs if user have them
@@ -202,7 +1287,7 @@ func setDNHeaders(r *http.Request, userData map[string]interface{}) {
 		switch dns := val.(type) {
 		case []string:
 			for _, dn := range dns {
-				r.Header.Add("Cms-DNs", dn)
+				r.Header.Add(a.HeaderKey("dns"), NormalizeDN(dn))
 			}
 		}
 	}
@@ -211,33 +1296,34 @@ func setDNHeaders(r *http.Request, userData map[string]interface{}) {
 // SetCMSHeadersByKey sets HTTP headers for given http request based on on provider user and CRIC data
 func (a *CMSAuth) SetCMSHeadersByKey(r *http.Request, userData map[string]interface{}, cricRecords CricRecords, key, method string, verbose bool) {
 	// set cms auth headers
-	r.Header.Set("cms-auth-status", "ok")
-	r.Header.Set("cms-authn-name", iString(userData["name"]))
+	r.Header.Set(a.HeaderKey("auth-status"), "ok")
+	r.Header.Set(a.HeaderKey("authn-name"), iString(userData["name"]))
 	if vvv, ok := userData[key]; ok {
 		val := iString(vvv)
 		if rec, ok := cricRecords[val]; ok {
 			// set DN
-			r.Header.Set("cms-authn-dn", rec.DN)
-			r.Header.Set("cms-auth-cert", rec.DN)
-			r.Header.Set("cms-authn-login", rec.Login)
-			r.Header.Set("cms-cern-id", iString(rec.ID))
+			r.Header.Set(a.HeaderKey("authn-dn"), rec.DN)
+			r.Header.Set(a.HeaderKey("auth-cert"), rec.DN)
+			r.Header.Set(a.HeaderKey("authn-login"), rec.Login)
+			r.Header.Set(a.HeaderKey("cern-id"), iString(rec.ID))
 			// set group roles
-			for k, v := range rec.Roles {
-				key := fmt.Sprintf("cms-authz-%s", k)
+			for k, v := range a.mappedRoles(rec.Roles) {
+				key := a.RoleHeaderKey(k)
 				val := strings.Join(v, " ")
 				r.Header.Set(key, val)
 			}
 		}
 	}
-	setDNHeaders(r, userData)
-	r.Header.Set("cms-authn-method", method)
-	r.Header.Set("cms-email", iString(userData["email"]))
-	r.Header.Set("cms-auth-time", iString(userData["auth_time"]))
-	r.Header.Set("cms-auth-expire", iString(userData["exp"]))
-	r.Header.Set("cms-session", iString(userData["session_state"]))
-	r.Header.Set("cms-request-uri", r.URL.Path)
+	a.setDNHeaders(r, userData)
+	r.Header.Set(a.HeaderKey("authn-method"), method)
+	r.Header.Set(a.HeaderKey("email"), iString(userData["email"]))
+	r.Header.Set(a.HeaderKey("auth-time"), iString(userData["auth_time"]))
+	r.Header.Set(a.HeaderKey("auth-expire"), iString(userData["exp"]))
+	r.Header.Set(a.HeaderKey("session"), iString(userData["session_state"]))
+	r.Header.Set(a.HeaderKey("request-uri"), r.URL.Path)
+	r.Header.Set(a.HeaderKey("authn-timestamp"), fmt.Sprintf("%d", time.Now().Unix()))
 	if hmac, err := a.GetHmac(r, verbose); err == nil {
-		r.Header.Set("cms-authn-hmac", hmac)
+		r.Header.Set(a.HeaderKey("authn-hmac"), hmac)
 	}
 }
 
@@ -1,8 +1,16 @@
 package cmsauth
 
 import (
+	hmacpkg "crypto/hmac"
+	"crypto/sha1"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -39,3 +47,798 @@ func TestCheckCMSAuthz(t *testing.T) {
 	res = cmsAuth.CheckCMSAuthz(header, role, group, site)
 	assert.Equal(t, res, true)
 }
+
+// helper function to create auth key file and initialize CMSAuth with it
+func initCMSAuth(t *testing.T) *CMSAuth {
+	fname := filepath.Join(t.TempDir(), "hmac.key")
+	err := os.WriteFile(fname, []byte("secret"), 0600)
+	assert.Nil(t, err)
+	cmsAuth := &CMSAuth{}
+	err = cmsAuth.InitError(fname)
+	assert.Nil(t, err)
+	return cmsAuth
+}
+
+// TestInitError function
+func TestInitError(t *testing.T) {
+	var cmsAuth CMSAuth
+	err := cmsAuth.InitError(filepath.Join(t.TempDir(), "missing.key"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to read")
+
+	// requests signed with empty key are rejected when key is not loaded
+	header := make(http.Header)
+	header.Set("cms-auth-status", "OK")
+	header.Set("cms-authn-dn", "/CN=a")
+	header.Set("cms-authz-user", "group:dbs")
+	sign := func(key []byte) {
+		mac := hmacpkg.New(sha1.New, key)
+		mac.Write([]byte(CanonicalSignString(map[string]string{"cms-authn-dn": "/CN=a", "cms-authz-user": "group:dbs"})))
+		header.Set("cms-authn-hmac", fmt.Sprintf("%x", mac.Sum(nil)))
+	}
+	sign(nil)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	sign([]byte("secret"))
+	assert.Equal(t, initCMSAuth(t).CheckAuthnAuthz(header), true)
+
+	assert.Nil(t, cmsAuth.InitError(""))
+}
+
+// helper function to convert request headers into lower-case header map
+// similar to the one received by backend server from the frontend
+func lowerHeaders(header http.Header) http.Header {
+	hdr := make(http.Header)
+	for k, v := range header {
+		hdr[strings.ToLower(k)] = v
+	}
+	return hdr
+}
+
+// TestCanonicalize function
+func TestCanonicalize(t *testing.T) {
+	assert.Equal(t, CanonicalHeaderValue("  group:dbs \t group:das  "), "group:dbs group:das")
+
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.Canonicalize = true
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	r.Header.Set("cms-authz-user", "group:dbs group:das")
+	hmac, err := cmsAuth.GetHmac(r, false)
+	assert.Nil(t, err)
+
+	header := lowerHeaders(r.Header)
+	header["cms-authn-name"] = []string{"  First   Last "}
+	header["cms-authz-user"] = []string{"group:dbs\tgroup:das "}
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// without canonicalization the extra spaces should break verification
+	cmsAuth.Canonicalize = false
+	header = lowerHeaders(r.Header)
+	header["cms-authn-name"] = []string{"  First   Last "}
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+}
+
+// TestCheckCMSAuthzHierarchical function
+func TestCheckCMSAuthzHierarchical(t *testing.T) {
+	var cmsAuth CMSAuth
+	header := make(http.Header)
+	header["Cms-Authz-Operator"] = []string{"group:cms/dbs/expert site:T1"}
+	role := "operator"
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, "group:cms/dbs/expert"), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, "group:cms/dbs"), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, "group:cms"), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, "group:cms/das"), false)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, "group:cms/db"), false)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, "admin", "group:cms/dbs"), false)
+	assert.Equal(t, cmsAuth.CheckCMSAuthzHierarchical(header, role, ""), false)
+}
+
+// TestDescribeSigning function
+func TestDescribeSigning(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authz-user", "group:dbs")
+	r.Header.Set("cms-authn-name", "First Last")
+	r.Header.Set("cms-auth-status", "ok")
+	desc := cmsAuth.DescribeSigning(r)
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	lines := strings.Split(desc, "\n")
+	assert.Equal(t, lines[0], "signed headers: 2")
+	assert.Equal(t, lines[1], "  cms-authn-name key_len=14 value_len=10")
+	assert.Equal(t, lines[2], "  cms-authz-user key_len=14 value_len=9")
+	assert.Contains(t, desc, "val: he")
+	assert.Contains(t, desc, "digest: "+hmac)
+	assert.NotContains(t, desc, "secret")
+}
+
+// TestHmacExcludeHeaders function
+func TestHmacExcludeHeaders(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SetHmacExcludeHeaders("Cms-Authz-Proxy-Marker")
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	hmac1, _ := cmsAuth.GetHmac(r, false)
+	r.Header.Set("cms-authz-proxy-marker", "value1")
+	hmac2, _ := cmsAuth.GetHmac(r, false)
+	r.Header.Set("cms-authz-proxy-marker", "value2")
+	hmac3, _ := cmsAuth.GetHmac(r, false)
+	assert.Equal(t, hmac1, hmac2)
+	assert.Equal(t, hmac1, hmac3)
+
+	header := lowerHeaders(r.Header)
+	header["cms-authz-proxy-marker"] = []string{"value3"}
+	header["cms-authn-hmac"] = []string{hmac1}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// non-excluded headers are still signed
+	header["cms-authz-user"] = []string{"group:dbs"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	header["cms-authz-user"] = []string{"group:dbs,group:das"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	// joined and separate values produce different signatures
+	hmacs := make(map[string]bool)
+	for _, values := range [][]string{{"a,b"}, {"a", "b"}, {"a, b"}, {"a", " b"}, {"hem2v1v1#a"}} {
+		r, _ := http.NewRequest("GET", "/path", nil)
+		r.Header["Cms-Authz-User"] = values
+		hmac, _ := cmsAuth.GetHmac(r, false)
+		hmacs[hmac] = true
+	}
+	assert.Equal(t, len(hmacs), 5)
+}
+
+// TestPeerKeys function
+func TestPeerKeys(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.AddPeerKey("fnal", []byte("peer-secret"))
+
+	// peer realm signs request with its own key
+	fname := filepath.Join(t.TempDir(), "peer.key")
+	err := os.WriteFile(fname, []byte("peer-secret"), 0600)
+	assert.Nil(t, err)
+	var peerAuth CMSAuth
+	peerAuth.Init(fname)
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	r.Header.Set("cms-authn-realm", "fnal")
+	hmac, _ := peerAuth.GetHmac(r, false)
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// unregistered realm is rejected
+	r.Header.Set("cms-authn-realm", "unknown")
+	hmac, _ = peerAuth.GetHmac(r, false)
+	header = lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	// request without realm is verified with local key
+	r.Header.Del("cms-authn-realm")
+	hmac, _ = cmsAuth.GetHmac(r, false)
+	header = lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// peer keys can be added while requests are verified
+	r.Header.Set("cms-authn-realm", "fnal")
+	hmac, _ = peerAuth.GetHmac(r, false)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cmsAuth.AddPeerKey(fmt.Sprintf("realm%d", i), []byte("secret"))
+		}(i)
+		header := lowerHeaders(r.Header)
+		header["cms-authn-hmac"] = []string{hmac}
+		assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	}
+	wg.Wait()
+}
+
+// TestCheckCMSAuthzDetail function
+func TestCheckCMSAuthzDetail(t *testing.T) {
+	var cmsAuth CMSAuth
+	header := make(http.Header)
+	header["Cms-Authz-Operator"] = []string{"group:dbs group:xcache"}
+	header["Cms-Authz-Admin"] = []string{"group:das site:T1_US_FNAL"}
+	status, role, token := cmsAuth.CheckCMSAuthzDetail(header, "operator", "xcache", "T2")
+	assert.Equal(t, status, true)
+	assert.Equal(t, role, "Cms-Authz-Operator")
+	assert.Equal(t, token, "group:xcache")
+
+	status, role, token = cmsAuth.CheckCMSAuthzDetail(header, "admin", "dbs", "T1_US")
+	assert.Equal(t, status, true)
+	assert.Equal(t, role, "Cms-Authz-Admin")
+	assert.Equal(t, token, "site:T1_US_FNAL")
+
+	status, role, token = cmsAuth.CheckCMSAuthzDetail(header, "admin", "dbs", "T2")
+	assert.Equal(t, status, false)
+	assert.Equal(t, role, "")
+	assert.Equal(t, token, "")
+}
+
+// TestGetHmacWithKey function
+func TestGetHmacWithKey(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-name", "First")
+	key := []byte("other-secret")
+	hmac, err := cmsAuth.GetHmacWithKey(r, key, false)
+	assert.Nil(t, err)
+
+	val := "he" + fmt.Sprintf("v%x", len("First")) + "#cms-authn-nameFirst"
+	mac := hmacpkg.New(sha1.New, key)
+	mac.Write([]byte(val))
+	assert.Equal(t, hmac, fmt.Sprintf("%x", mac.Sum(nil)))
+
+	hmac1, _ := cmsAuth.GetHmac(r, false)
+	assert.NotEqual(t, hmac, hmac1)
+	hmac2, _ := cmsAuth.GetHmacWithKey(r, []byte("secret"), false)
+	assert.Equal(t, hmac1, hmac2)
+}
+
+// TestSetCMSHeadersClean function
+func TestSetCMSHeadersClean(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	cricRecords := CricRecords{
+		GetSortedDN(dn): CricEntry{DN: dn, SortedDN: GetSortedDN(dn), Roles: map[string][]string{"user": {"group:dbs"}}},
+	}
+	userData := map[string]interface{}{"name": "First Last", "dn": dn}
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authz-oldrole", "group:admin")
+	r.Header["cms-authz-injected"] = []string{"group:admin"}
+	r.Header.Set("Accept", "application/json")
+	cmsAuth.SetCMSHeadersClean(r, userData, cricRecords, false)
+	assert.Equal(t, r.Header.Get("cms-authz-oldrole"), "")
+	assert.Nil(t, r.Header["cms-authz-injected"])
+	assert.Equal(t, r.Header.Get("cms-authz-user"), "group:dbs")
+	assert.Equal(t, r.Header.Get("Accept"), "application/json")
+	assert.NotContains(t, cmsAuth.DescribeSigning(r), "oldrole")
+}
+
+// TestURLEncode function
+func TestURLEncode(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.URLEncode = true
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "José Müller")
+	hmac, err := cmsAuth.GetHmac(r, false)
+	assert.Nil(t, err)
+	assert.Contains(t, cmsAuth.DescribeSigning(r), "cms-authn-nameJos%C3%A9+M%C3%BCller")
+
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// verifier without encoding does not accept the signature
+	cmsAuth.URLEncode = false
+	header = lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+}
+
+// TestSignedHeaderKeys function
+func TestSignedHeaderKeys(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SetHmacExcludeHeaders("cms-authz-marker")
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authz-user", "group:dbs")
+	r.Header.Set("cms-authn-name", "First Last")
+	r.Header.Set("cms-authn-hmac", "123")
+	r.Header.Set("cms-authz-marker", "proxy")
+	r.Header.Set("Accept", "application/json")
+	keys := cmsAuth.SignedHeaderKeys(r.Header)
+	assert.Equal(t, keys, []string{"cms-authn-name", "cms-authz-user"})
+
+	// digest only depends on signed headers
+	hmac1, _ := cmsAuth.GetHmac(r, false)
+	r2, _ := http.NewRequest("GET", "/path", nil)
+	for _, k := range keys {
+		r2.Header.Set(k, r.Header.Get(k))
+	}
+	hmac2, _ := cmsAuth.GetHmac(r2, false)
+	assert.Equal(t, hmac1, hmac2)
+}
+
+// TestRequiredHeaders function
+func TestRequiredHeaders(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SetRequiredHeaders("Cms-Authn-Login", "cms-authn-name")
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	r.Header.Set("cms-authn-login", "user")
+	hmac, _ = cmsAuth.GetHmac(r, false)
+	header = lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+}
+
+// TestAcceptedSchemes function
+func TestAcceptedSchemes(t *testing.T) {
+	legacyAuth := initCMSAuth(t)
+	newAuth := initCMSAuth(t)
+	err := newAuth.SetHmacScheme(HmacSHA256)
+	assert.Nil(t, err)
+	err = newAuth.SetHmacScheme("md5")
+	assert.NotNil(t, err)
+
+	verifier := initCMSAuth(t)
+	err = verifier.SetAcceptedSchemes(HmacSHA1, HmacSHA256)
+	assert.Nil(t, err)
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	for _, signer := range []*CMSAuth{legacyAuth, newAuth} {
+		hmac, _ := signer.GetHmac(r, false)
+		header := lowerHeaders(r.Header)
+		header["cms-authn-hmac"] = []string{hmac}
+		assert.Equal(t, verifier.CheckAuthnAuthz(header), true)
+	}
+	legacyHmac, _ := legacyAuth.GetHmac(r, false)
+	newHmac, _ := newAuth.GetHmac(r, false)
+	assert.NotEqual(t, legacyHmac, newHmac)
+
+	// legacy only verifier rejects new scheme
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{newHmac}
+	assert.Equal(t, legacyAuth.CheckAuthnAuthz(header), false)
+
+	// invalid signature is rejected
+	header["cms-authn-hmac"] = []string{"invalid"}
+	assert.Equal(t, verifier.CheckAuthnAuthz(header), false)
+}
+
+// TestTimestampSkew function
+func TestTimestampSkew(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.TimestampSkew = 5 * time.Minute
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	userData := map[string]interface{}{"name": "First Last", "dn": dn}
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, userData, CricRecords{}, false)
+	assert.NotEqual(t, r.Header.Get("cms-authn-timestamp"), "")
+	header := lowerHeaders(r.Header)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	tests := map[time.Duration]bool{
+		time.Minute:       true,
+		-time.Minute:      true,
+		10 * time.Minute:  false,
+		-10 * time.Minute: false,
+	}
+	for offset, expect := range tests {
+		ts := time.Now().Add(offset).Unix()
+		r.Header.Set("cms-authn-timestamp", fmt.Sprintf("%d", ts))
+		hmac, _ := cmsAuth.GetHmac(r, false)
+		header := lowerHeaders(r.Header)
+		header["cms-authn-hmac"] = []string{hmac}
+		assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), expect, offset)
+	}
+}
+
+// TestMultiValuedHeaders function
+func TestMultiValuedHeaders(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Add("cms-authz-user", "group:dbs")
+	hmac1, _ := cmsAuth.GetHmac(r, false)
+	r.Header.Add("cms-authz-user", "group:das")
+	hmac2, _ := cmsAuth.GetHmac(r, false)
+	assert.NotEqual(t, hmac1, hmac2)
+	assert.Contains(t, cmsAuth.DescribeSigning(r), "hem2v9v9#cms-authz-usergroup:dbsgroup:das")
+
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac2}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// tampering with any of the values invalidates signature
+	header["cms-authz-user"] = []string{"group:dbs", "group:admin"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	header["cms-authz-user"] = []string{"group:dbs"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	header["cms-authz-user"] = []string{"group:dbs,group:das"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	// joined and separate values produce different signatures
+	hmacs := make(map[string]bool)
+	for _, values := range [][]string{{"a,b"}, {"a", "b"}, {"a, b"}, {"a", " b"}, {"hem2v1v1#a"}} {
+		r, _ := http.NewRequest("GET", "/path", nil)
+		r.Header["Cms-Authz-User"] = values
+		hmac, _ := cmsAuth.GetHmac(r, false)
+		hmacs[hmac] = true
+	}
+	assert.Equal(t, len(hmacs), 5)
+}
+
+// TestRoleHeaderKey function
+func TestRoleHeaderKey(t *testing.T) {
+	assert.Equal(t, RoleHeaderKey("Operator"), "cms-authz-operator")
+	assert.Equal(t, RoleHeaderKey("Data Manager"), "cms-authz-data-manager")
+	assert.Equal(t, RoleHeaderKey("t0_Ops"), "cms-authz-t0-ops")
+
+	var cmsAuth CMSAuth
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	cricRecords := CricRecords{
+		GetSortedDN(dn): CricEntry{DN: dn, Roles: map[string][]string{"Data Manager": {"group:dbs"}}},
+	}
+	userData := map[string]interface{}{"dn": dn}
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, userData, cricRecords, false)
+	assert.Equal(t, r.Header.Get("cms-authz-data-manager"), "group:dbs")
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(r.Header, "Data Manager", "group:dbs", ""), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(r.Header, "data-manager", "group:dbs", ""), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(r.Header, "manager", "group:dbs", ""), false)
+}
+
+// TestSignMethodHost function
+func TestSignMethodHost(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SignMethodHost = true
+	r, _ := http.NewRequest("GET", "https://cmsweb.cern.ch/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+
+	// helper function to create server side request with given method and host
+	request := func(method, host string) *http.Request {
+		req, _ := http.NewRequest(method, "https://"+host+"/path", nil)
+		req.Header = lowerHeaders(r.Header)
+		req.Header["cms-authn-hmac"] = []string{hmac}
+		return req
+	}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(request("GET", "cmsweb.cern.ch")), true)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(request("POST", "cmsweb.cern.ch")), false)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(request("GET", "other.cern.ch")), false)
+
+	// without request binding the signature can not be verified
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(request("GET", "cmsweb.cern.ch").Header), false)
+}
+
+// TestExplainAuthz function
+func TestExplainAuthz(t *testing.T) {
+	var cmsAuth CMSAuth
+	header := make(http.Header)
+	exp := cmsAuth.ExplainAuthz(header, "operator", "xcache", "T1")
+	assert.Equal(t, exp.Allowed, false)
+	assert.Equal(t, exp.Reason, "no cms-authz headers found")
+
+	header["Cms-Authz-Operator"] = []string{"group:dbs"}
+	header["Cms-Authz-Admin"] = []string{"group:xcache"}
+	exp = cmsAuth.ExplainAuthz(header, "operator", "xcache", "T1")
+	assert.Equal(t, exp.Allowed, false)
+	assert.Equal(t, exp.Examined, []string{"Cms-Authz-Admin", "Cms-Authz-Operator"})
+	assert.Equal(t, exp.RoleMatched, []string{"Cms-Authz-Operator"})
+	assert.Equal(t, exp.Reason, "role operator matched but neither group xcache nor site T1 found in [group:dbs]")
+
+	exp = cmsAuth.ExplainAuthz(header, "developer", "xcache", "T1")
+	assert.Equal(t, exp.Reason, "no cms-authz header matches role developer")
+
+	exp = cmsAuth.ExplainAuthz(header, "admin", "xcache", "T1")
+	assert.Equal(t, exp.Allowed, true)
+	assert.Equal(t, exp.MatchedHeader, "Cms-Authz-Admin")
+	assert.Equal(t, exp.MatchedToken, "group:xcache")
+}
+
+// TestCheckAuthnAuthzMap function
+func TestCheckAuthnAuthzMap(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-name", "First Last")
+	r.Header.Set("cms-authz-user", "group:dbs")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// raw map with non-canonical keys, e.g. gRPC metadata
+	headers := map[string][]string{
+		"CMS-AUTH-STATUS": {"ok"},
+		"Cms-Authn-Name":  {"First Last"},
+		"cms-authz-USER":  {"group:dbs"},
+		"cms-authn-hmac":  {hmac},
+	}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzMap(headers), true)
+	headers["cms-authz-USER"] = []string{"group:admin"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzMap(headers), false)
+	delete(headers, "CMS-AUTH-STATUS")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzMap(headers), false)
+}
+
+// TestStrictMode function
+func TestStrictMode(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	header := http.Header{"cms-auth-status": {"NONE"}}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+
+	// unauthenticated request with injected headers is rejected
+	header["cms-authn-login"] = []string{"admin"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	delete(header, "cms-authn-login")
+	cmsAuth.StrictMode = true
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	// alias headers are not set when HMAC does not match
+	cmsAuth.StrictMode = false
+	header = http.Header{
+		"cms-auth-status": {"ok"},
+		"cms-authn-dn":    {"/CN=admin"},
+		"cms-authn-hmac":  {"invalid"},
+	}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	_, ok := header["dn"]
+	assert.Equal(t, ok, false)
+}
+
+// TestVerifyDetached function
+func TestVerifyDetached(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	headers := map[string]string{"X-Event": "push", "X-Delivery": "123"}
+	val := "hav3h7v4#x-delivery123x-eventpush"
+	mac := hmacpkg.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(val))
+	signature := fmt.Sprintf("%x", mac.Sum(nil))
+
+	ok, err := cmsAuth.VerifyDetached(headers, signature)
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+	ok, err = cmsAuth.VerifyDetached(headers, strings.ToUpper(signature))
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+
+	headers["X-Event"] = "pull"
+	ok, err = cmsAuth.VerifyDetached(headers, signature)
+	assert.Nil(t, err)
+	assert.Equal(t, ok, false)
+
+	_, err = cmsAuth.VerifyDetached(headers, "")
+	assert.NotNil(t, err)
+	var noKey CMSAuth
+	_, err = noKey.VerifyDetached(headers, signature)
+	assert.NotNil(t, err)
+}
+
+// TestCanonicalSignString function
+func TestCanonicalSignString(t *testing.T) {
+	golden := []struct {
+		headers map[string]string
+		expect  string
+	}{
+		{map[string]string{}, "#"},
+		{map[string]string{"cms-authn-name": "user"}, "hev4#cms-authn-nameuser"},
+		{
+			map[string]string{"Cms-Authz-User": "group:dbs", "cms-authn-dn": "/CN=a"},
+			"hcv5hev9#cms-authn-dn/CN=acms-authz-usergroup:dbs",
+		},
+		{
+			map[string]string{"cms-authn-login": "", "cms-authn-login-x": "abcdefghijklmnopq"},
+			"hfv0h11v11#cms-authn-logincms-authn-login-xabcdefghijklmnopq",
+		},
+		{
+			map[string]string{"cms-authn-x": strings.Repeat("a", 300), "cms-auth-status": "ok"},
+			"hfv2hbv12c#cms-auth-statusokcms-authn-x" + strings.Repeat("a", 300),
+		},
+	}
+	for _, g := range golden {
+		assert.Equal(t, CanonicalSignString(g.headers), g.expect)
+	}
+
+	// GetHmac uses canonical sign string
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authz-user", "group:dbs")
+	r.Header.Set("cms-authn-dn", "/CN=a")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	mac := hmacpkg.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(golden[2].expect))
+	assert.Equal(t, hmac, fmt.Sprintf("%x", mac.Sum(nil)))
+}
+
+// TestEvaluatePolicy function
+func TestEvaluatePolicy(t *testing.T) {
+	var cmsAuth CMSAuth
+	header := make(http.Header)
+	header["Cms-Authz-Operator"] = []string{"group:dbs group:xcache"}
+	header["Cms-Authz-Admin"] = []string{"site:T1_US_FNAL"}
+	reqs := []AuthzRequirement{
+		{Role: "operator", Group: "xcache"},
+		{Role: "operator", Group: "das"},
+		{Role: "admin", Site: "T1_US_FNAL"},
+		{Role: "developer"},
+		{Role: "admin"},
+	}
+	res := cmsAuth.EvaluatePolicy(header, reqs)
+	assert.Equal(t, res.Allowed, false)
+	assert.Equal(t, len(res.Results), 5)
+	expect := []bool{true, false, true, false, true}
+	for i, r := range res.Results {
+		assert.Equal(t, r.Requirement, reqs[i])
+		assert.Equal(t, r.Satisfied, expect[i], r.Requirement)
+	}
+	assert.Equal(t, res.Results[0].MatchedHeader, "Cms-Authz-Operator")
+	assert.Equal(t, res.Results[0].MatchedToken, "group:xcache")
+	assert.Equal(t, res.Results[2].MatchedToken, "site:T1_US_FNAL")
+	assert.Equal(t, res.Satisfied(), []AuthzRequirement{reqs[0], reqs[2], reqs[4]})
+
+	res = cmsAuth.EvaluatePolicy(header, []AuthzRequirement{reqs[0], reqs[2]})
+	assert.Equal(t, res.Allowed, true)
+	res = cmsAuth.EvaluatePolicy(header, nil)
+	assert.Equal(t, res.Allowed, false)
+}
+
+// TestAuthorizeDN function
+func TestAuthorizeDN(t *testing.T) {
+	var cmsAuth CMSAuth
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	altDN := "/DC=org/DC=doegrids/OU=People/CN=First Last 123"
+	records := CricRecords{
+		GetSortedDN(dn): CricEntry{
+			DN:    dn,
+			DNs:   []string{dn, altDN},
+			Roles: map[string][]string{"Operator": {"group:dbs", "site:T1_US_FNAL"}},
+		},
+	}
+	ok, err := cmsAuth.AuthorizeDN(records, dn, "operator", "group:dbs")
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+	ok, err = cmsAuth.AuthorizeDN(records, altDN, "operator", "T1_US_FNAL")
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+
+	ok, err = cmsAuth.AuthorizeDN(records, dn, "operator", "group:das")
+	assert.Nil(t, err)
+	assert.Equal(t, ok, false)
+	ok, err = cmsAuth.AuthorizeDN(records, dn, "admin", "group:dbs")
+	assert.Nil(t, err)
+	assert.Equal(t, ok, false)
+
+	ok, err = cmsAuth.AuthorizeDN(records, "/CN=unknown", "operator", "group:dbs")
+	assert.NotNil(t, err)
+	assert.Equal(t, ok, false)
+}
+
+// TestSignHeaders function
+func TestSignHeaders(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	header := make(http.Header)
+	header.Set("cms-auth-status", "ok")
+	header.Set("cms-authn-name", "First Last")
+	header.Set("cms-authn-login", "user")
+	header.Set("cms-authn-method", "X509Cert")
+	header.Set("cms-authz-user", "group:dbs")
+	header.Set("cms-request-uri", "/path")
+
+	// the expected signature is produced by CMS frontend python code
+	// for the same headers and "secret" key
+	sig, err := cmsAuth.SignHeaders(header)
+	assert.Nil(t, err)
+	assert.Equal(t, sig, "13eeb5988b4291d78bca26040aab50a6bad4e5eb")
+
+	header.Set("cms-authn-hmac", sig)
+	assert.Nil(t, cmsAuth.VerifyHeaders(header))
+	_, ok := header["Dn"]
+	assert.Equal(t, ok, false)
+	header.Set("cms-authz-user", "group:admin")
+	assert.NotNil(t, cmsAuth.VerifyHeaders(header))
+	header.Del("cms-authn-hmac")
+	assert.Contains(t, cmsAuth.VerifyHeaders(header).Error(), "missing cms-authn-hmac")
+
+	var noKey CMSAuth
+	_, err = noKey.SignHeaders(header)
+	assert.NotNil(t, err)
+}
+
+// TestHeaderCanonicalization function
+func TestHeaderCanonicalization(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	userData := map[string]interface{}{"cern_upn": "user", "name": "First Last", "email": "user@cern.ch", "exp": 1700000000}
+	cmsAuth.SetCMSHeaders(r, userData, CricRecords{}, false)
+	_, ok := r.Header["Cms-Auth-Status"]
+	assert.Equal(t, ok, true)
+
+	// canonical http.Header keys
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(r.Header.Clone()), true)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(r), true)
+	info, err := GetUserInfo(r)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Email, "user@cern.ch")
+
+	// raw lower-case keys, e.g. populated by frontend or gRPC metadata
+	header := lowerHeaders(r.Header)
+	assert.Equal(t, HeaderValue(header, "Cms-Authn-Login"), "user")
+	assert.Equal(t, HeaderValues(header, "CMS-AUTHN-NAME"), []string{"First Last"})
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	r.Header = lowerHeaders(r.Header)
+	info, err = GetUserInfo(r)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Email, "user@cern.ch")
+	assert.Equal(t, info.AuthMethod, "X509Cert")
+}
+
+// BenchmarkCanonicalSignString function
+func BenchmarkCanonicalSignString(b *testing.B) {
+	headers := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		headers[fmt.Sprintf("cms-authz-role%d", i)] = "group:dbs site:T1_US_FNAL site:T2_CH_CERN"
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CanonicalSignString(headers)
+	}
+}
+
+// BenchmarkGetHmac function
+func BenchmarkGetHmac(b *testing.B) {
+	fname := filepath.Join(b.TempDir(), "hmac")
+	os.WriteFile(fname, []byte("secret"), 0600)
+	cmsAuth := &CMSAuth{}
+	cmsAuth.Init(fname)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-login", "user")
+	r.Header.Set("cms-authn-dn", "/DC=ch/DC=cern/OU=Users/CN=user")
+	for i := 0; i < 20; i++ {
+		r.Header.Set(fmt.Sprintf("cms-authz-role%d", i), "group:dbs site:T1_US_FNAL site:T2_CH_CERN")
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cmsAuth.GetHmac(r, false)
+	}
+}
+
+// TestHeaderPrefix function
+func TestHeaderPrefix(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	atlasAuth := initCMSAuth(t)
+	atlasAuth.HeaderPrefix = "ATLAS-"
+	assert.Equal(t, atlasAuth.HeaderKey("authn-login"), "atlas-authn-login")
+	assert.Equal(t, atlasAuth.RoleHeaderKey("Data Manager"), "atlas-authz-data-manager")
+	assert.Equal(t, cmsAuth.RoleHeaderKey("admin"), RoleHeaderKey("admin"))
+
+	records, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-login", "injected")
+	userData := map[string]interface{}{"cern_upn": "user2", "name": "User2", "dn": "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user2/CN=2/CN=First2 Last2", "exp": time.Now().Add(time.Hour).Unix()}
+	atlasAuth.SetCMSHeadersClean(r, userData, records, false)
+	assert.Equal(t, HeaderValue(r.Header, "atlas-auth-status"), "ok")
+	assert.Equal(t, HeaderValue(r.Header, "atlas-authn-login"), "user2")
+	assert.Equal(t, HeaderValue(r.Header, "cms-authn-login"), "injected")
+	assert.Equal(t, HeaderValue(r.Header, "cms-auth-status"), "")
+
+	assert.Equal(t, atlasAuth.CheckAuthnAuthz(r.Header.Clone()), true)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(r.Header.Clone()), false)
+	assert.Equal(t, atlasAuth.CheckCMSAuthz(r.Header, "admin", "group:das", "group:das"), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(r.Header, "admin", "group:das", "group:das"), false)
+	info, err := atlasAuth.Verify(r)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Login, "user2")
+	assert.Equal(t, atlasAuth.UserFromHeader(r.Header).Roles["admin"], []string{"group:das", "site:T1_US_FNAL"})
+
+	// headers of other namespace are not signed and can not alter signature
+	r.Header.Set("cms-authz-admin", "group:dbs")
+	assert.Nil(t, atlasAuth.VerifyHeaders(r.Header))
+	r.Header.Set("atlas-authz-admin", "group:dbs")
+	assert.ErrorIs(t, atlasAuth.VerifyHeaders(r.Header), ErrHmacMismatch)
+}
@@ -0,0 +1,193 @@
+package cmsauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// AuthzExpr represents parsed authorization expression, e.g.
+// role:operator AND (group:dbs OR site:T1_*). The expression consists of
+// role:<name>, group:<pattern> and site:<pattern> terms combined with AND, OR,
+// NOT operators and parentheses. Group and site patterns are matched exactly
+// against values of role headers and may contain shell wildcards, e.g. T1_*.
+// The expression is evaluated against every role of the user and it is
+// satisfied if it holds for any of them, i.e. role and group/site terms refer
+// to the same role header.
+type AuthzExpr struct {
+	op    string       // operator: AND, OR, NOT or empty for terms
+	args  []*AuthzExpr // operator arguments
+	kind  string       // term kind: role, group or site
+	value string       // term value
+}
+
+// ParseAuthzExpr parses given authorization expression
+func ParseAuthzExpr(expr string) (*AuthzExpr, error) {
+	p := &authzExprParser{tokens: tokenizeAuthzExpr(expr)}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("empty authorization expression")
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token '%s' in authorization expression", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+// CheckAuthzExpr function performs CMS Authorization of given header against
+// authorization expression, see AuthzExpr
+func (a *CMSAuth) CheckAuthzExpr(header http.Header, expr string) (bool, error) {
+	e, err := ParseAuthzExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	return a.MatchAuthzExpr(header, e), nil
+}
+
+// Match checks if any role of cms-authz headers satisfies the expression,
+// use CMSAuth MatchAuthzExpr with custom HeaderPrefix
+func (e *AuthzExpr) Match(header http.Header) bool {
+	return e.MatchUser(UserFromHeader(header))
+}
+
+// MatchAuthzExpr checks if any role of authz headers of configured namespace
+// satisfies given expression
+func (a *CMSAuth) MatchAuthzExpr(header http.Header, e *AuthzExpr) bool {
+	return e.MatchUser(a.UserFromHeader(header))
+}
+
+// MatchUser checks if any role of given user satisfies the expression
+func (e *AuthzExpr) MatchUser(user User) bool {
+	for role, vals := range user.Roles {
+		if e.eval(role, vals) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to evaluate expression for given role and its values
+func (e *AuthzExpr) eval(role string, vals []string) bool {
+	switch e.op {
+	case "AND":
+		return e.args[0].eval(role, vals) && e.args[1].eval(role, vals)
+	case "OR":
+		return e.args[0].eval(role, vals) || e.args[1].eval(role, vals)
+	case "NOT":
+		return !e.args[0].eval(role, vals)
+	}
+	if e.kind == "role" {
+		return normalizeRole(e.value) == role
+	}
+	pattern := e.kind + ":" + e.value
+	for _, v := range vals {
+		if ok, _ := path.Match(pattern, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to split authorization expression into tokens
+func tokenizeAuthzExpr(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+// authzExprParser implements recursive descent parser of authorization
+// expressions
+type authzExprParser struct {
+	tokens []string
+	pos    int
+}
+
+// helper function to return current token in upper case if it is operator
+func (p *authzExprParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	tok := p.tokens[p.pos]
+	switch up := strings.ToUpper(tok); up {
+	case "AND", "OR", "NOT":
+		return up
+	}
+	return tok
+}
+
+// helper function to parse OR expression
+func (p *authzExprParser) parseOr() (*AuthzExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &AuthzExpr{op: "OR", args: []*AuthzExpr{left, right}}
+	}
+	return left, nil
+}
+
+// helper function to parse AND expression
+func (p *authzExprParser) parseAnd() (*AuthzExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &AuthzExpr{op: "AND", args: []*AuthzExpr{left, right}}
+	}
+	return left, nil
+}
+
+// helper function to parse NOT expression, parentheses and terms
+func (p *authzExprParser) parseUnary() (*AuthzExpr, error) {
+	tok := p.peek()
+	p.pos++
+	switch tok {
+	case "":
+		return nil, errors.New("unexpected end of authorization expression")
+	case "NOT":
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &AuthzExpr{op: "NOT", args: []*AuthzExpr{arg}}, nil
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing ')' in authorization expression")
+		}
+		p.pos++
+		return e, nil
+	}
+	kind, value, ok := strings.Cut(tok, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("invalid term '%s' in authorization expression", tok)
+	}
+	kind = strings.ToLower(kind)
+	if kind != "role" && kind != "group" && kind != "site" {
+		return nil, fmt.Errorf("unsupported term '%s' in authorization expression", tok)
+	}
+	if _, err := path.Match(value, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s' in authorization expression", tok)
+	}
+	return &AuthzExpr{kind: kind, value: value}, nil
+}
@@ -0,0 +1,40 @@
+package cmsauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckAuthzExpr function
+func TestCheckAuthzExpr(t *testing.T) {
+	header := make(http.Header)
+	header.Set("cms-authz-operator", "group:dbs site:T10_US_Site")
+	header.Set("cms-authz-admin", "site:T1_US_FNAL")
+	var cmsAuth CMSAuth
+	exprs := map[string]bool{
+		"role:operator":                               true,
+		"role:operator AND group:dbs":                 true,
+		"role:operator AND (group:das OR site:T1_*)":  false,
+		"role:admin AND (group:das OR site:T1_*)":     true,
+		"role:operator AND site:T1":                   false,
+		"site:T10_*":                                  true,
+		"role:operator and not group:dbs":             false,
+		"(role:admin OR role:operator) AND group:dbs": true,
+		"role:admin AND group:dbs":                    false,
+		"NOT role:admin AND NOT role:operator":        false,
+		"role:Data-Manager":                           false,
+		"group:*":                                     true,
+	}
+	for expr, expect := range exprs {
+		ok, err := cmsAuth.CheckAuthzExpr(header, expr)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, ok, expect, expr)
+	}
+
+	for _, expr := range []string{"", "role:", "user:admin", "(role:admin", "role:admin OR", "role:admin role:operator", "site:[T1"} {
+		_, err := cmsAuth.CheckAuthzExpr(header, expr)
+		assert.NotNil(t, err, expr)
+	}
+}
@@ -0,0 +1,64 @@
+package cmsauth
+
+import (
+	"os"
+	"time"
+)
+
+// DefaultCADir defines default location of trusted CA certificates
+var DefaultCADir = "/etc/grid-security/certificates"
+
+// InsecureSkipVerify disables verification of server certificates by HTTP
+// clients built with DefaultConfig. It should only be used by legacy
+// deployments which do not have trusted CA certificates.
+var InsecureSkipVerify bool
+
+// Config holds configuration of cmsauth Client
+type Config struct {
+	Timeout               int           // timeout in seconds for HTTP requests
+	Token                 string        // access token location
+	Verbose               int           // verbosity level
+	TLSCertsRenewInterval time.Duration // interval to re-read TLS certs
+	CADir                 string        // directory with trusted CA certificates, e.g. CERN/IGTF bundles
+	InsecureSkipVerify    bool          // disable verification of server certificates
+	CricRetry             RetryPolicy   // retry policy of CRIC requests
+	CricTimeout           time.Duration // overall timeout of CRIC request, zero means DefaultCricTimeout, negative disables it
+	CricMaxBytes          int64         // maximum size of CRIC response, zero means MaxCricResponseBytes
+	AllowNoCerts          bool          // build HTTP client without X509 certs if they can not be loaded
+}
+
+// DefaultConfig returns configuration based on package global variables. The
+// CA directory is taken from X509_CERT_DIR environment or DefaultCADir.
+func DefaultConfig() Config {
+	caDir := os.Getenv("X509_CERT_DIR")
+	if caDir == "" {
+		caDir = DefaultCADir
+	}
+	return Config{
+		Timeout:               TIMEOUT,
+		Token:                 Token,
+		Verbose:               Verbose,
+		TLSCertsRenewInterval: TLSCertsRenewInterval,
+		CADir:                 caDir,
+		InsecureSkipVerify:    InsecureSkipVerify,
+		CricRetry:             DefaultCricRetryPolicy,
+		CricTimeout:           DefaultCricTimeout,
+	}
+}
+
+// Client provides HTTP and CRIC helpers which use its own configuration
+// instead of package global variables
+type Client struct {
+	Config     Config
+	tlsManager *TLSCertsManager
+}
+
+// New creates new Client with given configuration
+func New(config Config) *Client {
+	return &Client{Config: config, tlsManager: &TLSCertsManager{}}
+}
+
+// helper function to return client based on package global variables
+func defaultClient() *Client {
+	return &Client{Config: DefaultConfig(), tlsManager: &tlsManager}
+}
@@ -0,0 +1,95 @@
+package cmsauth
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClient function
+func TestClient(t *testing.T) {
+	c1 := New(Config{Timeout: 1, Token: "token"})
+	c2 := New(Config{Timeout: 5, Token: "token"})
+	assert.Equal(t, c1.HttpClient().Timeout, time.Second)
+	assert.Equal(t, c2.HttpClient().Timeout, 5*time.Second)
+	assert.Equal(t, c1.Config.Timeout, 1)
+	assert.Equal(t, TIMEOUT, 0)
+
+	server, _ := testCricServer(t)
+	entries, err := c1.GetCricEntries(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 2)
+	records, err := c2.GetCricDataByKey(server.URL, "login", false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["user1"].ID, int64(1))
+}
+
+// TestClientCADir function
+func TestClientCADir(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// server certificate is not trusted
+	c := New(Config{Token: "token", CADir: t.TempDir()})
+	_, err := c.HttpClient().Get(server.URL)
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err = os.WriteFile(filepath.Join(dir, "test-ca.pem"), data, 0600)
+	assert.Nil(t, err)
+	c = New(Config{Token: "token", CADir: dir})
+	resp, err := c.HttpClient().Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	// explicit opt-out of verification
+	c = New(Config{Token: "token", InsecureSkipVerify: true})
+	resp, err = c.HttpClient().Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+}
+
+// TestHttpClientWithOptions function
+func TestHttpClientWithOptions(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	src := func() (string, error) { return "abc", nil }
+	client := HttpClientWithOptions(
+		WithTimeout(2*time.Second),
+		WithTokenSource(src),
+		WithRetry(2, time.Millisecond),
+	)
+	assert.Equal(t, client.Timeout, 2*time.Second)
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, string(data), "Bearer abc")
+	assert.Equal(t, calls, 3)
+
+	// clients with different configuration in one process
+	c1 := HttpClientWithOptions(WithConfig(Config{Timeout: 1, Token: "token"}))
+	c2 := HttpClientWithOptions(WithConfig(Config{Timeout: 5, Token: "token"}))
+	assert.Equal(t, c1.Timeout, time.Second)
+	assert.Equal(t, c2.Timeout, 5*time.Second)
+}
@@ -0,0 +1,243 @@
+// cmsauth is a command line tool to debug CMS authentication, it computes and
+// verifies cms-authn-hmac of given headers, looks up CRIC records and
+// validates bearer tokens.
+//
+// Usage:
+//
+//	cmsauth sign -key hmac.key -header "cms-auth-status: OK" -header "cms-authn-login: user"
+//	cmsauth verify -key hmac.key -headers headers.txt
+//	cmsauth cric -url https://cms-cric.cern.ch/api/accounts/user/query/?json&preset=roles -login user
+//	cmsauth token -jwks https://auth.cern.ch/auth/realms/cern/protocol/openid-connect/certs -token token.txt
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dmwm/cmsauth"
+)
+
+// headerFlags collects HTTP headers provided by repeated -header flags
+type headerFlags struct {
+	header http.Header
+}
+
+// String implements flag.Value interface
+func (h *headerFlags) String() string {
+	if h == nil || h.header == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", h.header)
+}
+
+// Set implements flag.Value interface
+func (h *headerFlags) Set(val string) error {
+	return addHeader(h.header, val)
+}
+
+// helper function to add header given as "key: value" string, keys are kept
+// as provided
+func addHeader(header http.Header, val string) error {
+	key, value, ok := strings.Cut(val, ":")
+	if !ok {
+		return fmt.Errorf("invalid header %q, expect key: value", val)
+	}
+	key = strings.TrimSpace(key)
+	header[key] = append(header[key], strings.TrimSpace(value))
+	return nil
+}
+
+// helper function to read headers from file with "key: value" lines
+func readHeaders(fname string, header http.Header) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := addHeader(header, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <sign|verify|cric|token> [options]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Run with -h after the command to see its options")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "sign":
+		err = sign(os.Args[2:], false)
+	case "verify":
+		err = sign(os.Args[2:], true)
+	case "cric":
+		err = cric(os.Args[2:])
+	case "token":
+		err = token(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+// sign computes or verifies HMAC of given headers
+func sign(args []string, verify bool) error {
+	name := "sign"
+	if verify {
+		name = "verify"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	headers := &headerFlags{header: make(http.Header)}
+	var key, hfile, exclude, scheme, prefix string
+	var verbose bool
+	fs.StringVar(&key, "key", "", "HMAC key file")
+	fs.Var(headers, "header", "header in \"key: value\" form, can be repeated")
+	fs.StringVar(&hfile, "headers", "", "file with headers, one \"key: value\" per line")
+	fs.StringVar(&exclude, "exclude", "", "comma separated list of headers excluded from HMAC")
+	fs.StringVar(&scheme, "scheme", string(cmsauth.HmacSHA1), "HMAC scheme, sha1 or sha256")
+	fs.StringVar(&prefix, "prefix", cmsauth.DefaultHeaderPrefix, "header prefix, e.g. cms")
+	fs.BoolVar(&verbose, "verbose", false, "print HMAC input string")
+	fs.Parse(args)
+	if key == "" {
+		return errors.New("HMAC key file is not provided")
+	}
+	if hfile != "" {
+		if err := readHeaders(hfile, headers.header); err != nil {
+			return err
+		}
+	}
+	auth := cmsauth.CMSAuth{HeaderPrefix: prefix}
+	if err := auth.InitError(key); err != nil {
+		return err
+	}
+	if exclude != "" {
+		auth.SetHmacExcludeHeaders(strings.Split(exclude, ",")...)
+	}
+	if err := auth.SetHmacScheme(cmsauth.HmacScheme(scheme)); err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Println("HMAC input:", auth.SignString(headers.header))
+	}
+	if !verify {
+		hmac, err := auth.SignHeaders(headers.header)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hmac)
+		return nil
+	}
+	if err := auth.VerifyHeaders(headers.header); err != nil {
+		if hmac, e := auth.SignHeaders(headers.header); e == nil {
+			fmt.Println("expected HMAC:", hmac)
+		}
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// cric dumps CRIC records of given login or DN
+func cric(args []string) error {
+	fs := flag.NewFlagSet("cric", flag.ExitOnError)
+	var rurl, fname, login, dn string
+	var verbose bool
+	fs.StringVar(&rurl, "url", "", "CRIC URL")
+	fs.StringVar(&fname, "file", "", "CRIC file")
+	fs.StringVar(&login, "login", "", "user login to lookup")
+	fs.StringVar(&dn, "dn", "", "user DN to lookup")
+	fs.BoolVar(&verbose, "verbose", false, "verbose mode")
+	fs.Parse(args)
+	var source cmsauth.CricSource
+	switch {
+	case rurl != "":
+		source = &cmsauth.HTTPCricSource{URL: rurl, Verbose: verbose}
+	case fname != "":
+		source = &cmsauth.FileCricSource{Path: fname}
+	default:
+		return errors.New("either CRIC URL or file should be provided")
+	}
+	rmap, err := cmsauth.GetCricDataFromSource(context.Background(), source, verbose)
+	if err != nil {
+		return err
+	}
+	records := cmsauth.CricRecords(rmap)
+	var entries []cmsauth.CricEntry
+	switch {
+	case login != "":
+		if rec, ok := records[login]; ok {
+			entries = append(entries, rec)
+		}
+	case dn != "":
+		if rec, ok := records.LookupByDN(dn); ok {
+			entries = append(entries, rec)
+		}
+	default:
+		return errors.New("either login or DN should be provided")
+	}
+	if len(entries) == 0 {
+		return errors.New("no CRIC record found")
+	}
+	return printJSON(entries)
+}
+
+// token validates bearer token and prints its claims
+func token(args []string) error {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	var jwks, issuer, audience, tkn string
+	fs.StringVar(&jwks, "jwks", "", "JWKS URL")
+	fs.StringVar(&issuer, "issuer", "", "expected token issuer")
+	fs.StringVar(&audience, "audience", "", "expected token audience")
+	fs.StringVar(&tkn, "token", "", "token or file with token")
+	fs.Parse(args)
+	if jwks == "" || tkn == "" || issuer == "" || audience == "" {
+		return errors.New("JWKS URL, issuer, audience and token should be provided")
+	}
+	tkn, err := cmsauth.LoadToken(tkn)
+	if err != nil {
+		return err
+	}
+	manager := cmsauth.NewTokenManager(jwks, issuer, audience)
+	claims, err := manager.Validate(strings.TrimSpace(tkn))
+	if err != nil {
+		return err
+	}
+	return printJSON(claims)
+}
+
+// helper function to print indented JSON
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package cmsauthchi provides CMS authentication middleware for chi router.
+// The chi middleware has standard net/http signature, therefore the adapters
+// do not depend on chi and are mounted with Use or With methods of chi router,
+// e.g.
+//
+//	r := chi.NewRouter()
+//	r.Use(cmsauthchi.Middleware(auth))
+//	r.With(cmsauthchi.RequireRole(auth, "admin", "group:dbs")).Get("/admin", handler)
+package cmsauthchi
+
+import (
+	"net/http"
+
+	"github.com/dmwm/cmsauth"
+)
+
+// Middleware returns chi middleware which verifies CMS headers of requests
+// using given CMSAuth, see cmsauth.CMSAuth Middleware. Requests which fail
+// authentication are rejected with 401 and requests which fail authorization
+// with 403 status code.
+func Middleware(auth *cmsauth.CMSAuth) func(http.Handler) http.Handler {
+	return auth.Middleware
+}
+
+// RequireRole returns chi middleware which allows only requests of users with
+// given role and, if provided, any of given groups or sites, see
+// cmsauth.RequireRole. The authz headers are read in namespace configured by
+// given CMSAuth. It should be mounted after Middleware.
+func RequireRole(auth *cmsauth.CMSAuth, role string, groups ...string) func(http.Handler) http.Handler {
+	return auth.RequireRole(role, groups...)
+}
+
+// RequireExpr returns chi middleware which allows only requests whose roles
+// satisfy given authorization expression, e.g. "role:admin AND group:dbs",
+// see cmsauth.AuthzExpr. It should be mounted after Middleware.
+func RequireExpr(auth *cmsauth.CMSAuth, expr string) (func(http.Handler) http.Handler, error) {
+	e, err := cmsauth.ParseAuthzExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return auth.RequireExpr(e), nil
+}
+
+// UserInfo returns UserInfo of authenticated user stored in request context
+// by Middleware
+func UserInfo(r *http.Request) (*cmsauth.UserInfo, bool) {
+	return cmsauth.UserInfoFromContext(r.Context())
+}
+
+// User returns User stored in request context by Middleware
+func User(r *http.Request) (cmsauth.User, bool) {
+	return cmsauth.UserFromContext(r.Context())
+}
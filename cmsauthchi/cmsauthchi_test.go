@@ -0,0 +1,85 @@
+package cmsauthchi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmwm/cmsauth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMiddleware function
+func TestMiddleware(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "hmac.key")
+	err := os.WriteFile(fname, []byte("secret"), 0600)
+	assert.Nil(t, err)
+	auth := &cmsauth.CMSAuth{}
+	assert.Nil(t, auth.InitError(fname))
+
+	var login, email string
+	final := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := UserInfo(r)
+		assert.Equal(t, ok, true)
+		user, ok := User(r)
+		assert.Equal(t, ok, true)
+		login, email = user.Login, info.Email
+	})
+	expr, err := RequireExpr(auth, "role:admin AND group:dbs")
+	assert.Nil(t, err)
+	_, err = RequireExpr(auth, "role:admin AND")
+	assert.NotNil(t, err)
+
+	// chain middleware in the same way as chi router does
+	handler := Middleware(auth)(RequireRole(auth, "admin")(expr(final)))
+	request := func(group string) *http.Request {
+		r := httptest.NewRequest("GET", "/path", nil)
+		r.Header.Set("cms-auth-status", "ok")
+		r.Header.Set("cms-authn-login", "user")
+		r.Header.Set("cms-email", "user@cern.ch")
+		r.Header.Set("cms-authz-admin", group)
+		r.Header.Set("cms-request-uri", "/path")
+		hmac, _ := auth.GetHmac(r, false)
+		r.Header.Set("cms-authn-hmac", hmac)
+		return r
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, request("group:dbs"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, login, "user")
+	assert.Equal(t, email, "user@cern.ch")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request("group:das"))
+	assert.Equal(t, rec.Code, http.StatusForbidden)
+
+	r := request("group:dbs")
+	r.Header.Set("cms-authn-hmac", "invalid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+
+	r = httptest.NewRequest("GET", "/path", nil)
+	_, ok := UserInfo(r)
+	assert.Equal(t, ok, false)
+
+	// guards honor custom header prefix
+	atlas := &cmsauth.CMSAuth{HeaderPrefix: "atlas"}
+	assert.Nil(t, atlas.InitError(fname))
+	expr, err = RequireExpr(atlas, "role:admin AND group:dbs")
+	assert.Nil(t, err)
+	handler = Middleware(atlas)(RequireRole(atlas, "admin")(expr(final)))
+	r = httptest.NewRequest("GET", "/path", nil)
+	r.Header.Set("atlas-auth-status", "ok")
+	r.Header.Set("atlas-authn-login", "user")
+	r.Header.Set("atlas-authz-admin", "group:dbs")
+	r.Header.Set("atlas-request-uri", "/path")
+	hmac, _ := atlas.GetHmac(r, false)
+	r.Header.Set("atlas-authn-hmac", hmac)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, rec.Code, http.StatusOK)
+}
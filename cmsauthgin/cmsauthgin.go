@@ -0,0 +1,74 @@
+// Package cmsauthgin provides CMS authentication middleware for gin router.
+// It is a separate module, i.e. gin is not a dependency of cmsauth itself.
+// The adapters wrap cmsauth net/http middleware into gin handlers, e.g.
+//
+//	r := gin.New()
+//	r.Use(cmsauthgin.Middleware(auth))
+//	r.GET("/admin", cmsauthgin.RequireRole(auth, "admin", "group:dbs"), handler)
+//
+// Requests rejected by the middleware are aborted, i.e. the rest of handlers
+// chain is not called.
+package cmsauthgin
+
+import (
+	"net/http"
+
+	"github.com/dmwm/cmsauth"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware returns gin handler which verifies CMS headers of requests
+// using given CMSAuth, see cmsauth.CMSAuth Middleware. Requests which fail
+// authentication are rejected with 401 and requests which fail authorization
+// with 403 status code.
+func Middleware(auth *cmsauth.CMSAuth) gin.HandlerFunc {
+	return Wrap(auth.Middleware)
+}
+
+// RequireRole returns gin handler which allows only requests of users with
+// given role and, if provided, any of given groups or sites, see
+// cmsauth.RequireRole. The authz headers are read in namespace configured by
+// given CMSAuth. It should be mounted after Middleware.
+func RequireRole(auth *cmsauth.CMSAuth, role string, groups ...string) gin.HandlerFunc {
+	return Wrap(auth.RequireRole(role, groups...))
+}
+
+// RequireExpr returns gin handler which allows only requests whose roles
+// satisfy given authorization expression, e.g. "role:admin AND group:dbs",
+// see cmsauth.AuthzExpr. It should be mounted after Middleware.
+func RequireExpr(auth *cmsauth.CMSAuth, expr string) (gin.HandlerFunc, error) {
+	e, err := cmsauth.ParseAuthzExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(auth.RequireExpr(e)), nil
+}
+
+// Wrap converts net/http middleware into gin handler. The request passed by
+// middleware to the next handler, e.g. with UserInfo stored in its context,
+// replaces request of gin context and gin handlers chain continues, otherwise
+// the chain is aborted.
+func Wrap(mw func(http.Handler) http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		next := false
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next = true
+			c.Request = r
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+		if !next {
+			c.Abort()
+		}
+	}
+}
+
+// UserInfo returns UserInfo of authenticated user stored in request context
+// by Middleware
+func UserInfo(c *gin.Context) (*cmsauth.UserInfo, bool) {
+	return cmsauth.UserInfoFromContext(c.Request.Context())
+}
+
+// User returns User stored in request context by Middleware
+func User(c *gin.Context) (cmsauth.User, bool) {
+	return cmsauth.UserFromContext(c.Request.Context())
+}
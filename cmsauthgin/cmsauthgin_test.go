@@ -0,0 +1,71 @@
+package cmsauthgin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dmwm/cmsauth"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMiddleware function
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fname := filepath.Join(t.TempDir(), "hmac.key")
+	err := os.WriteFile(fname, []byte("secret"), 0600)
+	assert.Nil(t, err)
+	auth := &cmsauth.CMSAuth{}
+	assert.Nil(t, auth.InitError(fname))
+
+	var login, email string
+	final := func(c *gin.Context) {
+		info, ok := UserInfo(c)
+		assert.Equal(t, ok, true)
+		user, ok := User(c)
+		assert.Equal(t, ok, true)
+		login, email = user.Login, info.Email
+		c.Status(http.StatusOK)
+	}
+	expr, err := RequireExpr(auth, "role:admin AND group:dbs")
+	assert.Nil(t, err)
+	_, err = RequireExpr(auth, "role:admin AND")
+	assert.NotNil(t, err)
+
+	router := gin.New()
+	router.Use(Middleware(auth))
+	router.GET("/path", RequireRole(auth, "admin"), expr, final)
+	request := func(group string) *http.Request {
+		r := httptest.NewRequest("GET", "/path", nil)
+		r.Header.Set("cms-auth-status", "ok")
+		r.Header.Set("cms-authn-login", "user")
+		r.Header.Set("cms-email", "user@cern.ch")
+		r.Header.Set("cms-authz-admin", group)
+		r.Header.Set("cms-request-uri", "/path")
+		hmac, _ := auth.GetHmac(r, false)
+		r.Header.Set("cms-authn-hmac", hmac)
+		return r
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request("group:dbs"))
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, login, "user")
+	assert.Equal(t, email, "user@cern.ch")
+
+	login = ""
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, request("group:das"))
+	assert.Equal(t, rec.Code, http.StatusForbidden)
+	assert.Equal(t, login, "")
+
+	r := request("group:dbs")
+	r.Header.Set("cms-authn-hmac", "invalid")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+	assert.Equal(t, login, "")
+}
@@ -1,24 +1,152 @@
 package cmsauth
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // CricRecords defines type for CRIC records
 type CricRecords map[string]CricEntry
 
-// mutex keeps lock for cricRecords updates
-var mutex sync.RWMutex
+// SafeCricRecords provides concurrency-safe access to CRIC records which
+// can be queried by HTTP handlers while records are being refreshed
+type SafeCricRecords struct {
+	mutex   sync.RWMutex
+	records CricRecords
+	dnIndex map[string]string // sorted DN to record key index over all DNs
+}
+
+// NewSafeCricRecords creates SafeCricRecords from copy of given records
+func NewSafeCricRecords(records CricRecords) *SafeCricRecords {
+	s := &SafeCricRecords{}
+	s.Update(records)
+	return s
+}
+
+// Lookup returns CRIC entry for given key
+func (s *SafeCricRecords) Lookup(key string) (CricEntry, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	rec, ok := s.records[key]
+	return rec, ok
+}
+
+// Update replaces CRIC records with copy of given records
+func (s *SafeCricRecords) Update(records CricRecords) {
+	rmap := make(CricRecords, len(records))
+	for key, rec := range records {
+		rmap[key] = rec
+	}
+	dnIndex := buildCricDNIndex(rmap)
+	s.mutex.Lock()
+	s.records = rmap
+	s.dnIndex = dnIndex
+	s.mutex.Unlock()
+}
+
+// LookupByDN returns CRIC entry for given primary or secondary DN. The DN can
+// be provided either in slash or in RFC 2253 comma form.
+func (s *SafeCricRecords) LookupByDN(dn string) (CricEntry, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key, ok := s.dnIndex[normalizeDN(dn)]
+	if !ok {
+		return CricEntry{}, false
+	}
+	rec, ok := s.records[key]
+	return rec, ok
+}
+
+// LookupByDN returns CRIC entry for given primary or secondary DN. The DN can
+// be provided either in slash or in RFC 2253 comma form. Use SafeCricRecords
+// or CricManager for indexed lookups.
+func (r CricRecords) LookupByDN(dn string) (CricEntry, bool) {
+	return findCricEntryByDN(r, normalizeDN(dn))
+}
+
+// helper function to build index of sorted primary and secondary DNs of CRIC
+// records into record keys
+func buildCricDNIndex(records CricRecords) map[string]string {
+	dnIndex := make(map[string]string, len(records))
+	for key, rec := range records {
+		for _, dn := range append([]string{rec.DN}, rec.DNs...) {
+			if dn != "" {
+				dnIndex[GetSortedDN(dn)] = key
+			}
+		}
+	}
+	return dnIndex
+}
+
+// helper function to normalize DN into sorted slash form
+func normalizeDN(dn string) string {
+	return GetSortedDN(strings.TrimSpace(dn))
+}
+
+// Size returns number of CRIC records
+func (s *SafeCricRecords) Size() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.records)
+}
+
+// Keys returns sorted list of CRIC record keys
+func (s *SafeCricRecords) Keys() []string {
+	s.mutex.RLock()
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	s.mutex.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// MaxCricResponseBytes defines maximum size of CRIC response body
+var MaxCricResponseBytes int64 = 512 * 1024 * 1024
+
+// MaxCricEntries defines maximum number of CRIC entries in CRIC data
+var MaxCricEntries = 1000000
+
+// cricPostProcessor holds function applied to every CRIC entry during records ingestion
+var cricPostProcessor func(*CricEntry)
+
+// cricPostProcessorLock keeps lock for cricPostProcessor updates
+var cricPostProcessorLock sync.RWMutex
+
+// SetCricPostProcessor sets function which is applied to every CRIC entry
+// while CRIC records are built, e.g. to enrich or map CRIC entries. Use nil
+// to reset it.
+func SetCricPostProcessor(fn func(*CricEntry)) {
+	cricPostProcessorLock.Lock()
+	defer cricPostProcessorLock.Unlock()
+	cricPostProcessor = fn
+}
+
+// helper function to apply CRIC post-processor to given entry
+func postProcessCricEntry(rec *CricEntry) {
+	cricPostProcessorLock.RLock()
+	fn := cricPostProcessor
+	cricPostProcessorLock.RUnlock()
+	if fn != nil {
+		fn(rec)
+	}
+}
 
 // CricEntry represents structure in CRIC entry (used by CMS headers)
 type CricEntry struct {
@@ -29,6 +157,80 @@ type CricEntry struct {
 	Login    string              `json:"LOGIN"`    // CRIC Login name
 	Name     string              `json:"NAME"`     // CRIC user name
 	Roles    map[string][]string `json:"ROLES"`    // CRIC user roles
+
+	// optional affiliation attributes provided by CRIC
+	Institute string   `json:"INSTITUTE,omitempty"` // user institute
+	Country   string   `json:"COUNTRY,omitempty"`   // country of user institute
+	VOs       []string `json:"VO,omitempty"`        // VO membership
+	Status    string   `json:"STATUS,omitempty"`    // CRIC account status
+}
+
+// UnmarshalJSON decodes CricEntry from JSON and normalizes null or missing
+// ROLES into empty map
+func (c *CricEntry) UnmarshalJSON(data []byte) error {
+	type cricEntry CricEntry // alias type to avoid recursion
+	var rec cricEntry
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+	*c = CricEntry(rec)
+	if c.Roles == nil {
+		c.Roles = make(map[string][]string)
+	}
+	return nil
+}
+
+// helper function to return copy of CricEntry with sorted DNs and role values
+func (c CricEntry) canonical() CricEntry {
+	rec := c
+	rec.DNs = append([]string{}, c.DNs...)
+	sort.Strings(rec.DNs)
+	rec.VOs = append([]string{}, c.VOs...)
+	sort.Strings(rec.VOs)
+	rec.Roles = make(map[string][]string)
+	for r, vals := range c.Roles {
+		v := append([]string{}, vals...)
+		sort.Strings(v)
+		rec.Roles[r] = v
+	}
+	return rec
+}
+
+// Equal compares CricEntry with other one, the order of DNs and role values
+// is not taken into account
+func (c CricEntry) Equal(other CricEntry) bool {
+	c1 := c.canonical()
+	c2 := other.canonical()
+	if c1.DN != c2.DN || c1.SortedDN != c2.SortedDN || c1.ID != c2.ID ||
+		c1.Login != c2.Login || c1.Name != c2.Name || c1.Institute != c2.Institute ||
+		c1.Country != c2.Country || c1.Status != c2.Status {
+		return false
+	}
+	if len(c1.DNs) != len(c2.DNs) || len(c1.Roles) != len(c2.Roles) || len(c1.VOs) != len(c2.VOs) {
+		return false
+	}
+	for i := range c1.DNs {
+		if c1.DNs[i] != c2.DNs[i] {
+			return false
+		}
+	}
+	for i := range c1.VOs {
+		if c1.VOs[i] != c2.VOs[i] {
+			return false
+		}
+	}
+	for r, vals := range c1.Roles {
+		ovals, ok := c2.Roles[r]
+		if !ok || len(vals) != len(ovals) {
+			return false
+		}
+		for i := range vals {
+			if vals[i] != ovals[i] {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // String returns string representation of CricEntry
@@ -43,104 +245,500 @@ func (c *CricEntry) String() string {
 	return r
 }
 
+// CricKey defines type of key used to build CRIC records map
+type CricKey int
+
+// CRIC keys which can be used to build CRIC records map
+const (
+	CricKeyLogin CricKey = iota // CRIC login name
+	CricKeyID                   // CRIC ID
+	CricKeyName                 // CRIC user name
+	CricKeyDN                   // CRIC DN
+)
+
+// String returns string representation of CricKey
+func (k CricKey) String() string {
+	switch k {
+	case CricKeyLogin:
+		return "login"
+	case CricKeyID:
+		return "id"
+	case CricKeyName:
+		return "name"
+	case CricKeyDN:
+		return "dn"
+	}
+	return fmt.Sprintf("CricKey(%d)", int(k))
+}
+
+// ParseCricKey converts given string key into CricKey
+func ParseCricKey(key string) (CricKey, error) {
+	switch strings.ToLower(key) {
+	case "login":
+		return CricKeyLogin, nil
+	case "id":
+		return CricKeyID, nil
+	case "name":
+		return CricKeyName, nil
+	case "dn":
+		return CricKeyDN, nil
+	}
+	msg := fmt.Sprintf("provided key=%s is not supported", key)
+	return CricKeyLogin, errors.New(msg)
+}
+
+// CricFingerprint returns stable SHA256 fingerprint of given CRIC records.
+// The fingerprint does not depend on map iteration order or on the order of
+// DNs and role values within CRIC entries.
+func CricFingerprint(records CricRecords) string {
+	var keys []string
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		rec := records[k].canonical()
+		// json encoding of maps uses sorted keys which keeps it deterministic
+		data, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		h.Write([]byte(k))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // GetCricDataByKey downloads CRIC data
 func GetCricDataByKey(rurl, key string, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
-	entries, err := GetCricEntries(rurl, verbose)
+	return defaultClient().GetCricDataByKey(rurl, key, verbose)
+}
+
+// GetCricDataByKey downloads CRIC data using client configuration
+func (c *Client) GetCricDataByKey(rurl, key string, verbose bool) (map[string]CricEntry, error) {
+	ckey, err := ParseCricKey(key)
 	if err != nil {
-		return cricRecords, err
+		return make(map[string]CricEntry), err
 	}
-	cricRecords, err = getCricRecordsByKey(entries, key, verbose)
-	return cricRecords, nil
+	return c.GetCricDataByCricKey(rurl, ckey, verbose)
+}
+
+// GetCricDataByCricKey downloads CRIC data and uses given CRIC key for records map
+func GetCricDataByCricKey(rurl string, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	return defaultClient().GetCricDataByCricKey(rurl, key, verbose)
+}
+
+// GetCricDataByCricKey downloads CRIC data using client configuration and uses
+// given CRIC key for records map
+func (c *Client) GetCricDataByCricKey(rurl string, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	src := &HTTPCricSource{URL: rurl, Client: c, Verbose: verbose}
+	return GetCricDataFromSourceByKey(context.Background(), src, key, verbose)
 }
 
 // GetCricData downloads CRIC data
 func GetCricData(rurl string, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
-	entries, err := GetCricEntries(rurl, verbose)
-	if err != nil {
-		return cricRecords, err
-	}
-	cricRecords, err = getCricRecords(entries, verbose)
-	return cricRecords, err
+	return defaultClient().GetCricData(rurl, verbose)
+}
+
+// GetCricData downloads CRIC data using client configuration
+func (c *Client) GetCricData(rurl string, verbose bool) (map[string]CricEntry, error) {
+	src := &HTTPCricSource{URL: rurl, Client: c, Verbose: verbose}
+	return GetCricDataFromSource(context.Background(), src, verbose)
 }
 
 // GetCricEntries downloads CRIC data
 func GetCricEntries(rurl string, verbose bool) ([]CricEntry, error) {
+	return defaultClient().GetCricEntries(rurl, verbose)
+}
+
+// GetCricEntries downloads CRIC data using client configuration
+func (c *Client) GetCricEntries(rurl string, verbose bool) ([]CricEntry, error) {
+	entries, _, _, err := c.GetCricEntriesConditional(rurl, CricCacheMeta{}, verbose)
+	return entries, err
+}
+
+// GetCricEntriesCtx downloads CRIC data, the request is cancelled when given
+// context is done
+func GetCricEntriesCtx(ctx context.Context, rurl string, verbose bool) ([]CricEntry, error) {
+	return defaultClient().GetCricEntriesCtx(ctx, rurl, verbose)
+}
+
+// GetCricEntriesCtx downloads CRIC data using client configuration, the
+// request is cancelled when given context is done
+func (c *Client) GetCricEntriesCtx(ctx context.Context, rurl string, verbose bool) ([]CricEntry, error) {
+	entries, _, _, _, err := c.fetchCricEntries(ctx, rurl, CricCacheMeta{}, verbose)
+	return entries, err
+}
+
+// GetCricDataCtx downloads CRIC data and uses given CRIC key for records map,
+// the request is cancelled when given context is done
+func GetCricDataCtx(ctx context.Context, rurl, key string, verbose bool) (map[string]CricEntry, error) {
+	return defaultClient().GetCricDataCtx(ctx, rurl, key, verbose)
+}
+
+// GetCricDataCtx downloads CRIC data using client configuration and uses
+// given CRIC key for records map, the request is cancelled when given context
+// is done
+func (c *Client) GetCricDataCtx(ctx context.Context, rurl, key string, verbose bool) (map[string]CricEntry, error) {
+	ckey, err := ParseCricKey(key)
+	if err != nil {
+		return make(map[string]CricEntry), err
+	}
+	src := &HTTPCricSource{URL: rurl, Client: c, Verbose: verbose}
+	return GetCricDataFromSourceByKey(ctx, src, ckey, verbose)
+}
+
+// CricCacheMeta holds CRIC response validators used in conditional requests
+type CricCacheMeta struct {
+	ETag         string `json:"ETag"`         // ETag of CRIC response
+	LastModified string `json:"LastModified"` // Last-Modified of CRIC response
+}
+
+// GetCricEntriesConditional downloads CRIC data using conditional request
+// based on validators of previous response. If CRIC data is not modified it
+// returns no entries, previous validators and true not modified flag.
+func GetCricEntriesConditional(rurl string, prev CricCacheMeta, verbose bool) ([]CricEntry, CricCacheMeta, bool, error) {
+	return defaultClient().GetCricEntriesConditional(rurl, prev, verbose)
+}
+
+// GetCricEntriesConditional downloads CRIC data using client configuration
+// and conditional request based on validators of previous response
+func (c *Client) GetCricEntriesConditional(rurl string, prev CricCacheMeta, verbose bool) ([]CricEntry, CricCacheMeta, bool, error) {
+	entries, meta, notModified, _, err := c.fetchCricEntries(context.Background(), rurl, prev, verbose)
+	return entries, meta, notModified, err
+}
+
+// RetryPolicy defines retries of CRIC requests which fail due to network or
+// server errors. The wait time between retries grows exponentially from
+// Backoff with random jitter.
+type RetryPolicy struct {
+	Retries        int           // number of retries, zero disables retries
+	Backoff        time.Duration // initial wait time between retries
+	AttemptTimeout time.Duration // timeout of single request, zero means no timeout
+	Deadline       time.Duration // overall time limit for all attempts, zero means no limit
+}
+
+// DefaultCricRetryPolicy defines retry policy of CRIC requests used by
+// DefaultConfig
+var DefaultCricRetryPolicy = RetryPolicy{}
+
+// helper function to return jittered wait time before given retry attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff << attempt
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// FetchStats holds statistics of CRIC data fetch
+type FetchStats struct {
+	Duration   time.Duration `json:"duration"`   // time spent to obtain CRIC response
+	Bytes      int           `json:"bytes"`      // size of decompressed CRIC response body
+	Entries    int           `json:"entries"`    // number of decoded CRIC entries
+	StatusCode int           `json:"status"`     // HTTP status code of CRIC response
+	Compressed bool          `json:"compressed"` // CRIC response was gzip compressed
+}
+
+// GetCricEntriesStats downloads CRIC data and returns fetch statistics
+func GetCricEntriesStats(rurl string, verbose bool) ([]CricEntry, FetchStats, error) {
+	return defaultClient().GetCricEntriesStats(rurl, verbose)
+}
+
+// GetCricEntriesStats downloads CRIC data using client configuration and
+// returns fetch statistics
+func (c *Client) GetCricEntriesStats(rurl string, verbose bool) ([]CricEntry, FetchStats, error) {
+	entries, _, _, stats, err := c.fetchCricEntries(context.Background(), rurl, CricCacheMeta{}, verbose)
+	return entries, stats, err
+}
+
+// DefaultCricTimeout defines default overall timeout of CRIC request
+// including retries, see Config.CricTimeout
+var DefaultCricTimeout = 10 * time.Minute
+
+// helper function to return overall timeout of CRIC request
+func (c *Client) cricTimeout() time.Duration {
+	if c.Config.CricTimeout == 0 {
+		return DefaultCricTimeout
+	}
+	return c.Config.CricTimeout
+}
+
+// helper function to return maximum size of CRIC response
+func (c *Client) cricMaxBytes() int64 {
+	if c.Config.CricMaxBytes > 0 {
+		return c.Config.CricMaxBytes
+	}
+	return MaxCricResponseBytes
+}
+
+// helper function to download CRIC data and collect fetch statistics
+func (c *Client) fetchCricEntries(ctx context.Context, rurl string, prev CricCacheMeta, verbose bool) ([]CricEntry, CricCacheMeta, bool, FetchStats, error) {
 	var entries []CricEntry
-	client := HttpClient()
-	req, err := http.NewRequest("GET", rurl, nil)
+	meta, notModified, stats, err := c.fetchCric(ctx, rurl, prev, verbose, func(r io.Reader, limit int64) (int, error) {
+		entries = nil
+		err := streamCricEntriesLimit(r, limit, func(rec CricEntry) error {
+			entries = append(entries, rec)
+			return nil
+		})
+		return len(entries), err
+	})
+	if err != nil {
+		return nil, meta, notModified, stats, err
+	}
+	return entries, meta, notModified, stats, nil
+}
+
+// helper function to download CRIC data and build CRIC records with given
+// builder directly from CRIC response stream, i.e. without keeping whole
+// CRIC response and list of entries in memory
+func (c *Client) fetchCricRecords(ctx context.Context, rurl string, b *cricRecordsBuilder, verbose bool) error {
+	_, _, _, err := c.fetchCric(ctx, rurl, CricCacheMeta{}, verbose, func(r io.Reader, limit int64) (int, error) {
+		b.reset()
+		b.limit = limit
+		err := b.build(r)
+		return b.entries, err
+	})
+	return err
+}
+
+// cricConsumer consumes CRIC response stream decoding no more than given
+// limit of data and returns number of decoded CRIC entries. It is called
+// for every attempt of CRIC request and should drop data of previous one.
+type cricConsumer func(r io.Reader, limit int64) (int, error)
+
+// helper function to download CRIC data with retries, CRIC response is
+// passed to given consumer
+func (c *Client) fetchCric(ctx context.Context, rurl string, prev CricCacheMeta, verbose bool, consume cricConsumer) (CricCacheMeta, bool, FetchStats, error) {
+	if timeout := c.cricTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	policy := c.Config.CricRetry
+	var deadline time.Time
+	if policy.Deadline > 0 {
+		deadline = time.Now().Add(policy.Deadline)
+	}
+	for attempt := 0; ; attempt++ {
+		meta, notModified, stats, retry, err := c.fetchCricOnce(ctx, rurl, prev, policy.AttemptTimeout, verbose, consume)
+		if err == nil || !retry || attempt >= policy.Retries || ctx.Err() != nil {
+			return meta, notModified, stats, err
+		}
+		wait := policy.backoff(attempt)
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return meta, notModified, stats, err
+		}
+		if verbose {
+			GetLogger().Warnf("CRIC request failed, error %v, retry in %v", err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return meta, notModified, stats, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// helper function to perform single CRIC request with given timeout, it
+// returns retry flag if request failed due to network or server error
+func (c *Client) fetchCricOnce(ctx context.Context, rurl string, prev CricCacheMeta, timeout time.Duration, verbose bool, consume cricConsumer) (CricCacheMeta, bool, FetchStats, bool, error) {
+	var stats FetchStats
+	rurl, err := cricURL(rurl)
+	if err != nil {
+		return prev, false, stats, false, err
+	}
+	client, err := c.NewHttpClient()
 	if err != nil {
-		return entries, err
+		return prev, false, stats, false, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+	if err != nil {
+		return prev, false, stats, false, err
 	}
 	req.Header.Set("Accept", "application/json")
+	// gzip response is decompressed below, it considerably reduces transfer
+	// time of large CRIC dumps
+	req.Header.Set("Accept-Encoding", "gzip")
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	time0 := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Unable to place client request, %v", req)
-		return entries, err
+		GetLogger().Errorf("Unable to place client request, %v", req)
+		return prev, false, stats, true, err
 	}
 	defer resp.Body.Close()
+	stats.StatusCode = resp.StatusCode
 	if verbose {
 		dump, err := httputil.DumpRequestOut(req, true)
-		log.Printf("http request: headers %v, request %v, response %s, error %v", req.Header, req, string(dump), err)
+		GetLogger().Infof("http request: headers %v, request %v, response %s, error %v", req.Header, req, string(dump), err)
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Unable to read response, %v", resp)
-		return entries, err
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return prev, false, stats, true, fmt.Errorf("CRIC server error, status %s", resp.Status)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		stats.Duration = time.Since(time0)
+		if verbose {
+			GetLogger().Infof("CRIC data is not modified")
+		}
+		return prev, true, stats, false, nil
+	}
+	meta := CricCacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return prev, false, stats, true, fmt.Errorf("unable to decompress CRIC response: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+		stats.Compressed = true
+	}
+	// the limit applies to decompressed data
+	maxBytes := c.cricMaxBytes()
+	cr := &countingReader{r: reader}
+	count, err := consume(cr, maxBytes)
+	stats.Duration = time.Since(time0)
+	stats.Bytes = int(cr.n)
+	if cr.n > maxBytes {
+		return prev, false, stats, false, fmt.Errorf("CRIC response too large, exceeds %d bytes", maxBytes)
 	}
-	err = json.Unmarshal(body, &entries)
 	if err != nil {
-		return entries, err
+		if cr.err != nil && cr.err != io.EOF {
+			// failed to read response, e.g. connection is reset
+			GetLogger().Errorf("Unable to read response, %v", resp)
+			return prev, false, stats, true, err
+		}
+		return prev, false, stats, false, err
 	}
+	stats.Entries = count
 	if verbose {
-		log.Printf("obtained %d records", len(entries))
+		GetLogger().Infof("obtained %d records, size %d bytes, time %v", count, stats.Bytes, stats.Duration)
+	}
+	return meta, false, stats, false, nil
+}
+
+// countingReader counts bytes read from underlying reader and keeps its
+// read error
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+// Read implements io.Reader interface
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// helper function to decode list of CRIC entries from JSON data, it stops
+// decoding when number of entries exceeds MaxCricEntries
+func decodeCricEntries(data []byte) ([]CricEntry, error) {
+	var entries []CricEntry
+	err := streamCricEntries(bytes.NewReader(data), func(rec CricEntry) error {
+		entries = append(entries, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return entries, nil
 }
 
+// helper function to validate and normalize CRIC URL
+func cricURL(rurl string) (string, error) {
+	rurl = strings.TrimSpace(rurl)
+	if rurl == "" {
+		return rurl, errors.New("invalid CRIC URL '': empty URL")
+	}
+	u, err := url.Parse(rurl)
+	if err != nil {
+		return rurl, fmt.Errorf("invalid CRIC URL '%s': %v", rurl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rurl, fmt.Errorf("invalid CRIC URL '%s': unsupported scheme '%s'", rurl, u.Scheme)
+	}
+	if u.Host == "" {
+		return rurl, fmt.Errorf("invalid CRIC URL '%s': missing host", rurl)
+	}
+	return rurl, nil
+}
+
 // helper function to get cric records from list of cric entries using key
 func getCricRecordsByKey(entries []CricEntry, key string, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
+	ckey, err := ParseCricKey(key)
+	if err != nil {
+		return make(map[string]CricEntry), err
+	}
+	return getCricRecordsByCricKey(entries, ckey, verbose)
+}
+
+// helper function to get cric records from list of cric entries using CRIC key
+func getCricRecordsByCricKey(entries []CricEntry, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	b := newCricRecordsBuilder(key, verbose)
 	// convert list of entries into a map based on provided key
 	for _, rec := range entries {
-		var k string
-		if strings.ToLower(key) == "login" {
-			k = rec.Login
-		} else if strings.ToLower(key) == "id" {
-			k = fmt.Sprintf("%d", rec.ID)
-		} else if strings.ToLower(key) == "name" {
-			k = rec.Name
-		} else if strings.ToLower(key) == "dn" {
-			k = rec.DN
-		} else {
-			msg := fmt.Sprintf("provided key=%s is not supported", key)
-			return cricRecords, errors.New(msg)
-		}
-		recDNs := rec.DNs
-		mutex.RLock()
-		r, ok := cricRecords[k]
-		mutex.RUnlock()
-		if ok {
-			recDNs = r.DNs
-			recDNs = append(recDNs, rec.DN)
-			rec.DNs = recDNs
-			if verbose {
-				fmt.Printf("\nFound duplicate CRIC record\n%s\n%s\n", rec.String(), r.String())
-			}
-		} else {
-			recDNs = append(recDNs, rec.DN)
-			rec.DNs = recDNs
+		if err := b.add(rec); err != nil {
+			return b.records, err
 		}
-		mutex.Lock()
-		cricRecords[k] = rec
-		mutex.Unlock()
 	}
-	return cricRecords, nil
+	return b.records, nil
 }
 
-// GetSortedDN function translates given dn to sorted string
+// GetSortedDN function translates given dn to sorted string. The slash form
+// DN is split on every slash and its parts are sorted, e.g. host DN
+// /DC=ch/CN=host/vocms.cern.ch becomes /CN=host/DC=ch/vocms.cern.ch, which
+// keeps sorted DNs used as CRIC record keys and in cms-authn-sorted-dn header
+// unchanged. The DN in RFC 2253 comma form is converted into slash form
+// first. Only slash form DN with escaped slashes, e.g. /O=A\/B/CN=user, is
+// sorted by its parsed components whose values are kept escaped, e.g.
+// /CN=user/O=A\/B.
 func GetSortedDN(dn string) string {
+	if !strings.HasPrefix(dn, "/") {
+		if d := DNFromRFC2253(dn); d != "" {
+			dn = d
+		}
+		return splitSortedDN(dn)
+	}
+	if !strings.Contains(dn, "\\") {
+		return splitSortedDN(dn)
+	}
+	comps, err := ParseDN(dn)
+	if err != nil {
+		return splitSortedDN(dn)
+	}
+	dnParts := make([]string, 0, len(comps))
+	for _, c := range comps {
+		part := c.Type + "=" + escapeSlashDN(c.Value)
+		if !contains(dnParts, part) {
+			dnParts = append(dnParts, part)
+		}
+	}
+	sort.Strings(dnParts)
+	return "/" + strings.Join(dnParts, "/")
+}
+
+// helper function to sort slash delimited parts of DN, i.e. legacy sorted DN
+func splitSortedDN(dn string) string {
 	dnParts := []string{}
 	parts := strings.Split(dn, "/")
 	sort.Strings(parts)
@@ -153,6 +751,64 @@ func GetSortedDN(dn string) string {
 	return sortedDN
 }
 
+// AllDNs returns sorted list of unique DNs of all CRIC records
+func AllDNs(records CricRecords) []string {
+	udns := make(map[string]bool)
+	for _, rec := range records {
+		if rec.DN != "" {
+			udns[rec.DN] = true
+		}
+		for _, dn := range rec.DNs {
+			if dn != "" {
+				udns[dn] = true
+			}
+		}
+	}
+	dns := []string{}
+	for dn := range udns {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+	return dns
+}
+
+// DNCollision represents set of distinct CRIC entries which share the same sorted DN
+type DNCollision struct {
+	SortedDN string      `json:"SortedDN"` // Sorted DN string
+	Entries  []CricEntry `json:"Entries"`  // List of colliding CRIC entries
+}
+
+// DetectDNCollisions reports CRIC entries of distinct users (different logins
+// or IDs) whose DNs normalize to the same sorted DN
+func DetectDNCollisions(entries []CricEntry) []DNCollision {
+	groups := make(map[string][]CricEntry)
+	var keys []string
+	for _, rec := range entries {
+		sortedDN := GetSortedDN(rec.DN)
+		if _, ok := groups[sortedDN]; !ok {
+			keys = append(keys, sortedDN)
+		}
+		unique := true
+		for _, r := range groups[sortedDN] {
+			if r.Login == rec.Login && r.ID == rec.ID {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			groups[sortedDN] = append(groups[sortedDN], rec)
+		}
+	}
+	sort.Strings(keys)
+	var collisions []DNCollision
+	for _, k := range keys {
+		if len(groups[k]) > 1 {
+			collisions = append(collisions, DNCollision{SortedDN: k, Entries: groups[k]})
+		}
+	}
+	return collisions
+}
+
 // contains checks if a slice contains a specific value
 func contains(list []string, value string) bool {
 	for _, v := range list {
@@ -163,90 +819,52 @@ func contains(list []string, value string) bool {
 	return false
 }
 
-// helper function to get cric records from list of cric entries
+// helper function to get cric records from list of cric entries, the
+// records map is keyed by sorted DN
 func getCricRecords(entries []CricEntry, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
-	// convert list of entries into a map
-	for _, rec := range entries {
-		recDNs := rec.DNs
-		// the cricRecords map will contain sorted DN
-		sortedDN := GetSortedDN(rec.DN)
-		mutex.RLock()
-		r, ok := cricRecords[sortedDN]
-		mutex.RUnlock()
-		if ok {
-			recDNs = r.DNs
-			recDNs = append(recDNs, rec.DN)
-			rec.DNs = recDNs
-			if verbose {
-				fmt.Printf("\nFound duplicate CRIC record\n%s\n%s\n", rec.String(), r.String())
-			}
-		} else {
-			recDNs = append(recDNs, rec.DN)
-			rec.DNs = recDNs
-		}
-		rec.SortedDN = sortedDN
-		mutex.Lock()
-		cricRecords[sortedDN] = rec
-		mutex.Unlock()
-	}
-	return cricRecords, nil
+	return getCricRecordsByCricKey(entries, cricKeySortedDN, verbose)
 }
 
 // ParseCric allows to parse CRIC file and use cric Login as a key for cric entry map
 func ParseCric(fname string, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
-	var entries []CricEntry
-	if _, err := os.Stat(fname); err == nil {
-		jsonFile, err := os.Open(fname)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		defer jsonFile.Close()
-		byteValue, err := io.ReadAll(jsonFile)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		json.Unmarshal(byteValue, &entries)
-		cmap, err := getCricRecords(entries, verbose)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		mutex.Lock()
-		cricRecords = cmap
-		mutex.Unlock()
+	if _, err := os.Stat(fname); err != nil {
+		return make(map[string]CricEntry), nil
 	}
-	return cricRecords, nil
+	cricRecords, err := parseCricFile(fname, cricKeySortedDN, verbose)
+	if err != nil {
+		GetLogger().Errorf("%v", err)
+	}
+	return cricRecords, err
 }
 
 // ParseCricByKey allows to parse CRIC file use use provided key as a cric entry map
 func ParseCricByKey(fname, key string, verbose bool) (map[string]CricEntry, error) {
-	cricRecords := make(map[string]CricEntry)
-	var entries []CricEntry
-	if _, err := os.Stat(fname); err == nil {
-		jsonFile, err := os.Open(fname)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		defer jsonFile.Close()
-		byteValue, err := io.ReadAll(jsonFile)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		json.Unmarshal(byteValue, &entries)
-		cmap, err := getCricRecordsByKey(entries, key, verbose)
-		if err != nil {
-			log.Println(err)
-			return cricRecords, err
-		}
-		mutex.Lock()
-		cricRecords = cmap
-		mutex.Unlock()
+	ckey, err := ParseCricKey(key)
+	if err != nil {
+		GetLogger().Errorf("%v", err)
+		return make(map[string]CricEntry), err
+	}
+	return ParseCricByCricKey(fname, ckey, verbose)
+}
+
+// ParseCricByCricKey allows to parse CRIC file and use provided CRIC key as a cric entry map
+func ParseCricByCricKey(fname string, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	if _, err := os.Stat(fname); err != nil {
+		return make(map[string]CricEntry), nil
+	}
+	cricRecords, err := parseCricFile(fname, key, verbose)
+	if err != nil {
+		GetLogger().Errorf("%v", err)
+	}
+	return cricRecords, err
+}
+
+// helper function to stream CRIC file into records map keyed by given key
+func parseCricFile(fname string, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return make(map[string]CricEntry), err
 	}
-	return cricRecords, nil
+	defer file.Close()
+	return ParseCricStream(file, key, verbose)
 }
@@ -1,7 +1,15 @@
 package cmsauth
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,4 +20,538 @@ func TestGetSortedDN(t *testing.T) {
 	expect := "/CN=123/CN=First Last/CN=user/DC=cern/DC=ch/OU=Organic Units/OU=Users"
 	sortedDN := GetSortedDN(dn)
 	assert.Equal(t, sortedDN, expect)
+	assert.Equal(t, GetSortedDN(DNToRFC2253(dn)), expect)
+
+	// sorted DN of host certificate keeps legacy form
+	dn = "/DC=ch/DC=cern/OU=computers/CN=host/vocms.cern.ch"
+	expect = "/CN=host/DC=cern/DC=ch/OU=computers/vocms.cern.ch"
+	assert.Equal(t, GetSortedDN(dn), expect)
+	assert.Equal(t, GetSortedDN(DNToRFC2253(dn)), expect)
+	assert.Equal(t, GetSortedDN("/DC=ch/CN= user "), "/CN= user /DC=ch")
+
+	// escaped slashes within attribute values do not produce new DN parts
+	assert.Equal(t, GetSortedDN("/DC=ch/O=A\\/B/CN=user"), "/CN=user/DC=ch/O=A\\/B")
+	assert.Equal(t, GetSortedDN(""), "")
+}
+
+// helper function to return list of test CRIC entries
+func testCricEntries() []CricEntry {
+	return []CricEntry{
+		{
+			DN:    "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user1/CN=1/CN=First1 Last1",
+			ID:    1,
+			Login: "user1",
+			Name:  "First1 Last1",
+			Roles: map[string][]string{"operator": {"group:dbs"}},
+		},
+		{
+			DN:    "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user2/CN=2/CN=First2 Last2",
+			ID:    2,
+			Login: "user2",
+			Name:  "First2 Last2",
+			Roles: map[string][]string{"admin": {"group:das", "site:T1_US_FNAL"}},
+		},
+	}
+}
+
+// TestCricKey function
+func TestCricKey(t *testing.T) {
+	keys := map[string]CricKey{
+		"login": CricKeyLogin,
+		"ID":    CricKeyID,
+		"name":  CricKeyName,
+		"dn":    CricKeyDN,
+	}
+	for skey, ckey := range keys {
+		k, err := ParseCricKey(skey)
+		assert.Nil(t, err)
+		assert.Equal(t, k, ckey)
+		assert.Equal(t, k.String(), strings.ToLower(skey))
+
+		srecords, err := getCricRecordsByKey(testCricEntries(), skey, false)
+		assert.Nil(t, err)
+		crecords, err := getCricRecordsByCricKey(testCricEntries(), ckey, false)
+		assert.Nil(t, err)
+		assert.Equal(t, srecords, crecords)
+	}
+	records, err := getCricRecordsByCricKey(testCricEntries(), CricKeyID, false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["2"].Login, "user2")
+
+	_, err = ParseCricKey("email")
+	assert.NotNil(t, err)
+	_, err = getCricRecordsByKey(testCricEntries(), "email", false)
+	assert.NotNil(t, err)
+}
+
+// TestCricFingerprint function
+func TestCricFingerprint(t *testing.T) {
+	entries := testCricEntries()
+	records1, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	reversed := []CricEntry{entries[1], entries[0]}
+	records2, err := getCricRecords(reversed, false)
+	assert.Nil(t, err)
+	assert.Equal(t, CricFingerprint(records1), CricFingerprint(records2))
+
+	entries = testCricEntries()
+	entries[1].Roles["admin"] = []string{"group:das"}
+	records3, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	assert.NotEqual(t, CricFingerprint(records1), CricFingerprint(records3))
+}
+
+// TestDetectDNCollisions function
+func TestDetectDNCollisions(t *testing.T) {
+	entries := testCricEntries()
+	assert.Equal(t, len(DetectDNCollisions(entries)), 0)
+
+	// same user with duplicate record is not a collision
+	entries = append(entries, entries[0])
+	assert.Equal(t, len(DetectDNCollisions(entries)), 0)
+
+	entries = append(entries,
+		CricEntry{DN: "/DC=ch/CN=alpha/CN=beta", ID: 3, Login: "user3"},
+		CricEntry{DN: "/DC=ch/CN=beta/CN=alpha", ID: 4, Login: "user4"},
+	)
+	collisions := DetectDNCollisions(entries)
+	assert.Equal(t, len(collisions), 1)
+	assert.Equal(t, collisions[0].SortedDN, "/CN=alpha/CN=beta/DC=ch")
+	assert.Equal(t, len(collisions[0].Entries), 2)
+	assert.Equal(t, collisions[0].Entries[0].Login, "user3")
+	assert.Equal(t, collisions[0].Entries[1].Login, "user4")
+}
+
+// TestCricURL function
+func TestCricURL(t *testing.T) {
+	rurl := "https://cms-cric.cern.ch/api/accounts/user/query/?json&preset=roles"
+	u, err := cricURL(rurl)
+	assert.Nil(t, err)
+	assert.Equal(t, u, rurl)
+
+	u, err = cricURL("  " + rurl + "\n")
+	assert.Nil(t, err)
+	assert.Equal(t, u, rurl)
+
+	_, err = cricURL("htps://cms-cric.cern.ch/api")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid CRIC URL 'htps://cms-cric.cern.ch/api'")
+
+	_, err = cricURL(" ")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid CRIC URL")
+
+	_, err = GetCricEntries("htps://cms-cric.cern.ch/api", false)
+	assert.Contains(t, err.Error(), "invalid CRIC URL")
+}
+
+// TestAllDNs function
+func TestAllDNs(t *testing.T) {
+	records := CricRecords{
+		"a": CricEntry{DN: "/CN=b", DNs: []string{"/CN=b", "/CN=a"}},
+		"b": CricEntry{DN: "/CN=c", DNs: []string{"/CN=a", "/CN=c"}},
+		"c": CricEntry{DN: "/CN=d"},
+	}
+	assert.Equal(t, AllDNs(records), []string{"/CN=a", "/CN=b", "/CN=c", "/CN=d"})
+	assert.Equal(t, AllDNs(CricRecords{}), []string{})
+}
+
+// TestMaxCricResponseBytes function
+func TestMaxCricResponseBytes(t *testing.T) {
+	server, _ := testCricServer(t)
+	entries, err := GetCricEntries(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 2)
+
+	limit := MaxCricResponseBytes
+	defer func() { MaxCricResponseBytes = limit }()
+	MaxCricResponseBytes = 10
+	_, err = GetCricEntries(server.URL, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "response too large")
+}
+
+// TestGetCricEntriesConditional function
+func TestGetCricEntriesConditional(t *testing.T) {
+	etag := `"v1"`
+	lastModified := "Mon, 02 Jan 2023 15:04:05 GMT"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		data, _ := json.Marshal(testCricEntries())
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	entries, meta, notModified, err := GetCricEntriesConditional(server.URL, CricCacheMeta{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, notModified, false)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, meta, CricCacheMeta{ETag: etag, LastModified: lastModified})
+
+	entries, meta2, notModified, err := GetCricEntriesConditional(server.URL, meta, false)
+	assert.Nil(t, err)
+	assert.Equal(t, notModified, true)
+	assert.Equal(t, len(entries), 0)
+	assert.Equal(t, meta2, meta)
+
+	_, _, notModified, err = GetCricEntriesConditional(server.URL, CricCacheMeta{LastModified: lastModified}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, notModified, true)
+}
+
+// TestCricPostProcessor function
+func TestCricPostProcessor(t *testing.T) {
+	server, _ := testCricServer(t)
+	SetCricPostProcessor(func(rec *CricEntry) {
+		rec.Name = strings.ToUpper(rec.Login)
+	})
+	defer SetCricPostProcessor(nil)
+	records, err := GetCricData(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), 2)
+	for _, rec := range records {
+		assert.Equal(t, rec.Name, strings.ToUpper(rec.Login))
+	}
+	records, err = GetCricDataByCricKey(server.URL, CricKeyName, false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["USER1"].Login, "user1")
+}
+
+// TestMaxCricEntries function
+func TestMaxCricEntries(t *testing.T) {
+	data, _ := json.Marshal(testCricEntries())
+	entries, err := decodeCricEntries(data)
+	assert.Nil(t, err)
+	assert.Equal(t, entries, testCricEntries())
+
+	limit := MaxCricEntries
+	defer func() { MaxCricEntries = limit }()
+	MaxCricEntries = 1
+	_, err = decodeCricEntries(data)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "exceeds maximum of 1")
+
+	MaxCricEntries = 2
+	entries, err = decodeCricEntries(data)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 2)
+
+	entries, err = decodeCricEntries([]byte("null"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 0)
+	_, err = decodeCricEntries([]byte(`{"DN": "/CN=user"}`))
+	assert.NotNil(t, err)
+}
+
+// TestGetCricEntriesStats function
+func TestGetCricEntriesStats(t *testing.T) {
+	server, _ := testCricServer(t)
+	data, _ := json.Marshal(testCricEntries())
+	entries, stats, err := GetCricEntriesStats(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, stats.Bytes, len(data))
+	assert.Equal(t, stats.Entries, 2)
+	assert.Equal(t, stats.StatusCode, http.StatusOK)
+	assert.Greater(t, int64(stats.Duration), int64(0))
+}
+
+// TestCricEntryNullRoles function
+func TestCricEntryNullRoles(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	data := []byte(`[{"DN": "` + dn + `", "ID": 1, "LOGIN": "user", "ROLES": null}, {"DN": "/CN=other", "ID": 2}]`)
+	entries, err := decodeCricEntries(data)
+	assert.Nil(t, err)
+	for _, rec := range entries {
+		assert.NotNil(t, rec.Roles)
+		assert.Equal(t, len(rec.Roles), 0)
+	}
+	records, err := getCricRecords([]CricEntry{{DN: dn}}, false)
+	assert.Nil(t, err)
+	assert.NotNil(t, records[GetSortedDN(dn)].Roles)
+
+	var cmsAuth CMSAuth
+	records, err = getCricRecords(entries, false)
+	assert.Nil(t, err)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, map[string]interface{}{"dn": dn}, records, false)
+	for key := range r.Header {
+		assert.Equal(t, strings.HasPrefix(strings.ToLower(key), "cms-authz"), false)
+	}
+	rec := records[GetSortedDN(dn)]
+	assert.Contains(t, rec.String(), "Login: user")
+}
+
+// TestCricEntryEqual function
+func TestCricEntryEqual(t *testing.T) {
+	rec1 := CricEntry{
+		DN:    "/CN=a",
+		DNs:   []string{"/CN=a", "/CN=b"},
+		ID:    1,
+		Login: "user",
+		Roles: map[string][]string{"operator": {"group:dbs", "group:das"}},
+	}
+	rec2 := rec1
+	rec2.DNs = []string{"/CN=b", "/CN=a"}
+	rec2.Roles = map[string][]string{"operator": {"group:das", "group:dbs"}}
+	assert.Equal(t, rec1.Equal(rec2), true)
+
+	rec3 := rec1
+	rec3.Roles = map[string][]string{"operator": {"group:dbs"}}
+	assert.Equal(t, rec1.Equal(rec3), false)
+	rec3.Roles = map[string][]string{"admin": {"group:dbs", "group:das"}}
+	assert.Equal(t, rec1.Equal(rec3), false)
+
+	rec4 := rec1
+	rec4.Login = "other"
+	assert.Equal(t, rec1.Equal(rec4), false)
+
+	// nil and empty roles are equal
+	assert.Equal(t, CricEntry{DN: "/CN=a"}.Equal(CricEntry{DN: "/CN=a", Roles: map[string][]string{}}), true)
+}
+
+// TestSafeCricRecords function
+func TestSafeCricRecords(t *testing.T) {
+	records, err := getCricRecordsByCricKey(testCricEntries(), CricKeyLogin, false)
+	assert.Nil(t, err)
+	safe := NewSafeCricRecords(records)
+	assert.Equal(t, safe.Size(), 2)
+	assert.Equal(t, safe.Keys(), []string{"user1", "user2"})
+
+	// records are copied and modification of original map has no effect
+	delete(records, "user1")
+	rec, ok := safe.Lookup("user1")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.ID, int64(1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			safe.Lookup("user2")
+			safe.Keys()
+		}()
+	}
+	safe.Update(records)
+	wg.Wait()
+	_, ok = safe.Lookup("user1")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, safe.Size(), 1)
+}
+
+// TestLookupByDN function
+func TestLookupByDN(t *testing.T) {
+	entries := testCricEntries()
+	secondary := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user1/CN=1/CN=Robot"
+	entries = append(entries, CricEntry{DN: secondary, ID: 1, Login: "user1", Name: "First1 Last1"})
+	rmap, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	records := CricRecords(rmap)
+	safe := NewSafeCricRecords(records)
+	for _, dn := range []string{
+		entries[0].DN,
+		secondary,
+		"CN=Robot,CN=1,CN=user1,OU=Users,OU=Organic Units,DC=cern,DC=ch",
+	} {
+		rec, ok := safe.LookupByDN(dn)
+		assert.Equal(t, ok, true, dn)
+		assert.Equal(t, rec.Login, "user1", dn)
+		rec, ok = records.LookupByDN(dn)
+		assert.Equal(t, ok, true, dn)
+		assert.Equal(t, rec.Login, "user1", dn)
+	}
+	_, ok := safe.LookupByDN("/DC=ch/CN=unknown")
+	assert.Equal(t, ok, false)
+
+	// records keyed by login are looked up by DN as well
+	byLogin, err := getCricRecordsByKey(entries, "login", false)
+	assert.Nil(t, err)
+	logins := CricRecords(byLogin)
+	_, ok = logins.LookupByDN("/DC=ch/CN=unknown")
+	assert.Equal(t, ok, false)
+	rec, ok := logins.LookupByDN(secondary)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "user1")
+	logins["robot"] = CricEntry{Login: "robot", DN: "/DC=ch/CN=robot"}
+	rec, ok = logins.LookupByDN("/DC=ch/CN=robot")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "robot")
+
+	// CricManager DN index is rebuilt when records are swapped
+	mgr := &CricManager{}
+	mgr.update(logins, time.Now())
+	rec, ok = mgr.LookupByDN(secondary)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "user1")
+	updated := CricRecords{"robot": CricEntry{Login: "robot", DN: "/DC=ch/CN=other"}}
+	mgr.update(updated, time.Now())
+	_, ok = mgr.LookupByDN("/DC=ch/CN=robot")
+	assert.Equal(t, ok, false)
+	rec, ok = mgr.LookupByDN("CN=other,DC=ch")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "robot")
+}
+
+// TestCricRetry function
+func TestCricRetry(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		data, _ := json.Marshal(testCricEntries())
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	// no retries by default
+	_, err := GetCricEntries(server.URL, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "CRIC server error")
+
+	calls = 0
+	policy := RetryPolicy{Retries: 3, Backoff: time.Millisecond, AttemptTimeout: time.Second}
+	client := New(Config{Token: "token", CricRetry: policy})
+	entries, err := client.GetCricEntries(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, calls, 3)
+
+	// overall deadline stops retries
+	calls = -10
+	policy = RetryPolicy{Retries: 10, Backoff: 50 * time.Millisecond, Deadline: 60 * time.Millisecond}
+	client = New(Config{Token: "token", CricRetry: policy})
+	_, err = client.GetCricEntries(server.URL, false)
+	assert.NotNil(t, err)
+	assert.Equal(t, calls < 0, true)
+}
+
+// TestGetCricDataCtx function
+func TestGetCricDataCtx(t *testing.T) {
+	server, _ := testCricServer(t)
+	records, err := GetCricDataCtx(context.Background(), server.URL, "login", false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["user1"].ID, int64(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = GetCricEntriesCtx(ctx, server.URL, false)
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestCricAffiliation function
+func TestCricAffiliation(t *testing.T) {
+	data := `[{"DN": "/DC=ch/CN=user", "ID": 1, "LOGIN": "user", "NAME": "First Last",
+		"ROLES": {"operator": ["group:dbs"]},
+		"INSTITUTE": "FNAL", "COUNTRY": "USA", "VO": ["cms", "dteam"], "STATUS": "active"}]`
+	entries, err := decodeCricEntries([]byte(data))
+	assert.Nil(t, err)
+	rec := entries[0]
+	assert.Equal(t, rec.Institute, "FNAL")
+	assert.Equal(t, rec.VOs, []string{"cms", "dteam"})
+	other := rec
+	other.VOs = []string{"dteam", "cms"}
+	assert.Equal(t, rec.Equal(other), true)
+	other.Country = "CH"
+	assert.Equal(t, rec.Equal(other), false)
+
+	records, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	userData := map[string]interface{}{"cern_upn": "user", "dn": "/DC=ch/CN=user"}
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, userData, records, false)
+	assert.Equal(t, r.Header.Get("cms-authn-institute"), "")
+
+	cmsAuth.AffiliationHeaders = true
+	cmsAuth.SetCMSHeaders(r, userData, records, false)
+	assert.Equal(t, r.Header.Get("cms-authn-institute"), "FNAL")
+	assert.Equal(t, r.Header.Get("cms-authn-country"), "USA")
+	assert.Equal(t, r.Header.Get("cms-authn-vo"), "cms dteam")
+	assert.Equal(t, r.Header.Get("cms-authn-status"), "active")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(lowerHeaders(r.Header)), true)
+}
+
+// TestCricRFC2253DN function
+func TestCricRFC2253DN(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Users/CN=user/CN=First Last"
+	rdn := DNToRFC2253(dn)
+	// CRIC entries in RFC 2253 form are normalized into slash form
+	rmap, err := getCricRecords([]CricEntry{{DN: rdn, ID: 1, Login: "user", Name: "First Last",
+		Roles: map[string][]string{"user": {"group:dbs"}}}}, false)
+	assert.Nil(t, err)
+	rec, ok := rmap[GetSortedDN(dn)]
+	assert.True(t, ok)
+	assert.Equal(t, rec.DN, dn)
+	assert.Equal(t, rec.DNs, []string{dn})
+
+	// DNs of provided entries are not modified
+	entries := []CricEntry{{DN: dn, DNs: make([]string, 1, 2), ID: 1, Login: "user"}}
+	entries[0].DNs[0] = rdn
+	lmap, err := getCricRecordsByCricKey(entries, CricKeyLogin, false)
+	assert.Nil(t, err)
+	assert.Equal(t, lmap["user"].DNs, []string{dn, dn})
+	assert.Equal(t, entries[0].DNs, []string{rdn})
+	assert.Equal(t, entries[0].DNs[:2], []string{rdn, ""})
+
+	// DN provided in RFC 2253 form matches CRIC records in slash form
+	cmsAuth := initCMSAuth(t)
+	userData := map[string]interface{}{"name": "First Last", "dn": rdn}
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, userData, rmap, false)
+	assert.Equal(t, r.Header.Get("cms-authn-dn"), dn)
+	assert.Equal(t, r.Header.Get("cms-authz-user"), "group:dbs")
+}
+
+// TestCricTransport function
+func TestCricTransport(t *testing.T) {
+	data, _ := json.Marshal(testCricEntries())
+	var delay time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(data)
+		zw.Close()
+	}))
+	defer server.Close()
+
+	// gzip response is decompressed transparently
+	client := New(Config{Token: "token"})
+	entries, stats, err := client.GetCricEntriesStats(server.URL, false)
+	assert.Nil(t, err)
+	assert.Equal(t, entries, testCricEntries())
+	assert.Equal(t, stats.Compressed, true)
+	assert.Equal(t, stats.Bytes, len(data))
+
+	// size limit applies to decompressed data
+	client = New(Config{Token: "token", CricMaxBytes: int64(len(data) - 1)})
+	_, err = client.GetCricEntries(server.URL, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "response too large")
+	client = New(Config{Token: "token", CricMaxBytes: int64(len(data))})
+	_, err = client.GetCricEntries(server.URL, false)
+	assert.Nil(t, err)
+
+	// overall timeout of CRIC request
+	delay = 200 * time.Millisecond
+	client = New(Config{Token: "token", CricTimeout: 50 * time.Millisecond})
+	_, err = client.GetCricEntries(server.URL, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	client = New(Config{Token: "token", CricTimeout: -1})
+	_, err = client.GetCricEntries(server.URL, false)
+	assert.Nil(t, err)
 }
@@ -0,0 +1,107 @@
+package cmsauth
+
+import (
+	"sort"
+)
+
+// CricChange represents change of CRIC entry between two snapshots
+type CricChange struct {
+	Key string    // record key, e.g. user login
+	Old CricEntry // entry of old snapshot
+	New CricEntry // entry of new snapshot
+}
+
+// CricDelta represents difference between two CRIC snapshots, all lists are
+// sorted by record key
+type CricDelta struct {
+	Added        []CricEntry  // entries present only in new snapshot
+	Removed      []CricEntry  // entries present only in old snapshot
+	DNChanges    []CricChange // entries with changed DN or list of DNs
+	RoleChanges  []CricChange // entries with changed roles
+	OtherChanges []CricChange // entries with other changed attributes, e.g. name
+}
+
+// Empty checks if delta does not contain any changes
+func (d CricDelta) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.DNChanges) == 0 &&
+		len(d.RoleChanges) == 0 && len(d.OtherChanges) == 0
+}
+
+// DiffCricRecords compares previous and current CRIC snapshots keyed by the
+// same CRIC key and reports added and removed users along with DN and role
+// changes. An entry with both DN and role changes is reported in both lists.
+func DiffCricRecords(prev, cur CricRecords) CricDelta {
+	var delta CricDelta
+	for _, key := range sortedCricKeys(cur) {
+		rec := cur[key]
+		orec, ok := prev[key]
+		if !ok {
+			delta.Added = append(delta.Added, rec)
+			continue
+		}
+		if rec.Equal(orec) {
+			continue
+		}
+		change := CricChange{Key: key, Old: orec, New: rec}
+		c1, c2 := orec.canonical(), rec.canonical()
+		dnChanged := !sameDNs(c1, c2)
+		rolesChanged := !sameRoles(c1, c2)
+		if dnChanged {
+			delta.DNChanges = append(delta.DNChanges, change)
+		}
+		if rolesChanged {
+			delta.RoleChanges = append(delta.RoleChanges, change)
+		}
+		if !dnChanged && !rolesChanged {
+			delta.OtherChanges = append(delta.OtherChanges, change)
+		}
+	}
+	for _, key := range sortedCricKeys(prev) {
+		if _, ok := cur[key]; !ok {
+			delta.Removed = append(delta.Removed, prev[key])
+		}
+	}
+	return delta
+}
+
+// helper function to return sorted keys of CRIC records
+func sortedCricKeys(records CricRecords) []string {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// helper function to compare DNs of canonical CRIC entries
+func sameDNs(c1, c2 CricEntry) bool {
+	if c1.DN != c2.DN || len(c1.DNs) != len(c2.DNs) {
+		return false
+	}
+	for i := range c1.DNs {
+		if c1.DNs[i] != c2.DNs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to compare roles of canonical CRIC entries
+func sameRoles(c1, c2 CricEntry) bool {
+	if len(c1.Roles) != len(c2.Roles) {
+		return false
+	}
+	for r, vals := range c1.Roles {
+		ovals, ok := c2.Roles[r]
+		if !ok || len(vals) != len(ovals) {
+			return false
+		}
+		for i := range vals {
+			if vals[i] != ovals[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
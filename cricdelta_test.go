@@ -0,0 +1,72 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDiffCricRecords function
+func TestDiffCricRecords(t *testing.T) {
+	entries := testCricEntries()
+	old := CricRecords{"user1": entries[0], "user2": entries[1]}
+	assert.Equal(t, DiffCricRecords(old, old).Empty(), true)
+
+	user1 := entries[0]
+	user1.Roles = map[string][]string{"operator": {"group:dbs", "group:das"}}
+	user1.DNs = []string{user1.DN, "/DC=ch/CN=user1"}
+	user3 := CricEntry{DN: "/DC=ch/CN=user3", ID: 3, Login: "user3", Name: "First3 Last3"}
+	cur := CricRecords{"user1": user1, "user3": user3}
+	delta := DiffCricRecords(old, cur)
+	assert.Equal(t, delta.Empty(), false)
+	assert.Equal(t, len(delta.Added), 1)
+	assert.Equal(t, delta.Added[0].Login, "user3")
+	assert.Equal(t, len(delta.Removed), 1)
+	assert.Equal(t, delta.Removed[0].Login, "user2")
+	assert.Equal(t, len(delta.DNChanges), 1)
+	assert.Equal(t, len(delta.RoleChanges), 1)
+	assert.Equal(t, delta.RoleChanges[0].Key, "user1")
+	assert.Equal(t, delta.RoleChanges[0].Old.Roles["operator"], []string{"group:dbs"})
+	assert.Equal(t, len(delta.OtherChanges), 0)
+
+	// order of role values is not a change
+	user2 := entries[1]
+	user2.Roles = map[string][]string{"admin": {"site:T1_US_FNAL", "group:das"}}
+	assert.Equal(t, DiffCricRecords(old, CricRecords{"user1": entries[0], "user2": user2}).Empty(), true)
+	user2.Name = "Other Name"
+	delta = DiffCricRecords(old, CricRecords{"user1": entries[0], "user2": user2})
+	assert.Equal(t, len(delta.OtherChanges), 1)
+}
+
+// TestCricManagerSubscribe function
+func TestCricManagerSubscribe(t *testing.T) {
+	var mutex sync.Mutex
+	entries := testCricEntries()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		data, _ := json.Marshal(entries)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	mgr := NewCricManager(server.URL, time.Hour, false)
+	var deltas []CricDelta
+	mgr.Subscribe(func(d CricDelta) { deltas = append(deltas, d) })
+	assert.Nil(t, mgr.Refresh())
+	assert.Nil(t, mgr.Refresh())
+	assert.Equal(t, len(deltas), 0)
+
+	mutex.Lock()
+	entries[1].Roles = map[string][]string{"admin": {"group:das"}}
+	mutex.Unlock()
+	assert.Nil(t, mgr.Refresh())
+	assert.Equal(t, len(deltas), 1)
+	assert.Equal(t, len(deltas[0].RoleChanges), 1)
+	assert.Equal(t, deltas[0].RoleChanges[0].New.Login, "user2")
+}
@@ -0,0 +1,332 @@
+package cmsauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultCricInterval defines default interval to refresh CRIC records
+var DefaultCricInterval = time.Hour
+
+// DefaultCricWatchRetry defines delay before CricManager re-subscribes to
+// updates of shared CRIC cache after failure
+var DefaultCricWatchRetry = 10 * time.Second
+
+// SharedCricCache defines CRIC snapshot shared by a fleet of CricManager
+// instances, e.g. RedisCricCache. The instance which acquires refresh lock
+// fetches CRIC records and saves them into the cache, other instances load
+// records from the cache and keep them locally.
+type SharedCricCache interface {
+	// Lock acquires refresh lock for given period, it returns false if the
+	// lock is held by another instance
+	Lock(ttl time.Duration) (bool, error)
+	// Unlock releases refresh lock held by this instance, e.g. when CRIC
+	// records can not be fetched
+	Unlock() error
+	// Save stores CRIC records with time of their update and notifies
+	// watching instances
+	Save(records CricRecords, updated time.Time) error
+	// Updated returns update time of stored records, zero if cache is empty
+	Updated() (time.Time, error)
+	// Load returns stored records and time of their update
+	Load() (CricRecords, time.Time, error)
+	// Watch calls notify on every update of stored records until given
+	// context is done or watching fails
+	Watch(ctx context.Context, notify func()) error
+}
+
+// StaleCricError is returned by CricManager when its CRIC snapshot is older
+// than allowed MaxStaleness or when CRIC records were never loaded
+type StaleCricError struct {
+	Age          time.Duration // age of CRIC snapshot, zero if never loaded
+	MaxStaleness time.Duration // allowed staleness of CRIC snapshot
+}
+
+// Error implements error interface
+func (e *StaleCricError) Error() string {
+	if e.Age == 0 {
+		return "CRIC records are not available"
+	}
+	return fmt.Sprintf("CRIC records are stale, age %v exceeds %v", e.Age.Round(time.Second), e.MaxStaleness)
+}
+
+// CricManager periodically re-downloads CRIC records keyed by user login.
+// If CRIC data can not be fetched it keeps the last good snapshot and serves
+// it up to MaxStaleness, after that Check and Lookup return StaleCricError.
+type CricManager struct {
+	URL      string        // CRIC URL
+	Interval time.Duration // refresh interval
+	Verbose  bool          // verbose mode
+
+	// MaxStaleness defines how long the last good snapshot is served when
+	// CRIC is not available, zero means stale records are served forever
+	MaxStaleness time.Duration
+
+	// Shared defines optional CRIC cache shared with other instances, when
+	// it is set only one instance per Interval fetches records from CRIC
+	Shared SharedCricCache
+
+	mutex   sync.RWMutex
+	records CricRecords
+	dnIndex map[string]string // sorted DN to record key index of records
+	updated time.Time
+	hooks   []func()
+	subs    []func(CricDelta)
+}
+
+// NewCricManager creates new instance of CricManager
+func NewCricManager(rurl string, interval time.Duration, verbose bool) *CricManager {
+	if interval <= 0 {
+		interval = DefaultCricInterval
+	}
+	return &CricManager{URL: rurl, Interval: interval, Verbose: verbose}
+}
+
+// Refresh fetches CRIC records and swaps them with current ones. On error
+// the current records are kept. With Shared cache the records are fetched
+// only if refresh lock is acquired, otherwise they are loaded from the cache.
+func (m *CricManager) Refresh() error {
+	if m.Shared != nil {
+		return m.refreshShared()
+	}
+	records, err := m.fetch()
+	if err != nil {
+		return err
+	}
+	m.update(records, time.Now())
+	return nil
+}
+
+// helper function to fetch CRIC records
+func (m *CricManager) fetch() (CricRecords, error) {
+	time0 := time.Now()
+	records, err := GetCricDataByCricKey(m.URL, CricKeyLogin, m.Verbose)
+	if err != nil {
+		return nil, err
+	}
+	recordCricRefresh(time.Since(time0), len(records))
+	return records, nil
+}
+
+// helper function to refresh CRIC records using shared cache
+func (m *CricManager) refreshShared() error {
+	locked, err := m.Shared.Lock(m.Interval)
+	if err != nil {
+		GetLogger().Warnf("unable to acquire CRIC refresh lock, error %v", err)
+	}
+	if locked {
+		records, err := m.fetch()
+		if err == nil {
+			updated := time.Now()
+			if err := m.Shared.Save(records, updated); err != nil {
+				GetLogger().Warnf("unable to save CRIC records into shared cache, error %v", err)
+			}
+			m.update(records, updated)
+			return nil
+		}
+		GetLogger().Warnf("unable to fetch CRIC records from %s, error %v", m.URL, err)
+		// let other instances try to fetch CRIC records
+		if err := m.Shared.Unlock(); err != nil {
+			GetLogger().Warnf("unable to release CRIC refresh lock, error %v", err)
+		}
+	}
+	return m.loadShared()
+}
+
+// helper function to load CRIC records from shared cache if they are newer
+// than local ones
+func (m *CricManager) loadShared() error {
+	updated, err := m.Shared.Updated()
+	if err != nil {
+		return err
+	}
+	if updated.IsZero() {
+		return errors.New("CRIC records are not available in shared cache")
+	}
+	if !updated.After(m.Updated()) {
+		return nil
+	}
+	records, updated, err := m.Shared.Load()
+	if err != nil {
+		return err
+	}
+	m.update(records, updated)
+	return nil
+}
+
+// helper function to swap current records with given ones and notify
+// registered hooks and subscribers
+func (m *CricManager) update(records CricRecords, updated time.Time) {
+	dnIndex := buildCricDNIndex(records)
+	m.mutex.Lock()
+	old := m.records
+	m.records = records
+	m.dnIndex = dnIndex
+	m.updated = updated
+	hooks := m.hooks
+	subs := m.subs
+	m.mutex.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	if old != nil && len(subs) > 0 {
+		if delta := DiffCricRecords(old, records); !delta.Empty() {
+			for _, sub := range subs {
+				sub(delta)
+			}
+		}
+	}
+}
+
+// OnRefresh registers function called after every successful refresh of
+// CRIC records, e.g. DecisionCache.Invalidate
+func (m *CricManager) OnRefresh(hook func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// Subscribe registers function called with changes of CRIC records after
+// refresh, e.g. to invalidate sessions of users whose roles were changed. It
+// is not called for initial load or when records are not changed.
+func (m *CricManager) Subscribe(sub func(CricDelta)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subs = append(m.subs, sub)
+}
+
+// Run refreshes CRIC records every Interval until given context is done. It
+// performs initial refresh immediately and should be run in a goroutine. With
+// Shared cache it also reloads records on updates made by other instances.
+func (m *CricManager) Run(ctx context.Context) {
+	if m.Shared != nil {
+		go m.watch(ctx)
+	}
+	m.refresh()
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.refresh()
+		}
+	}
+}
+
+// helper function to refresh CRIC records and log errors
+func (m *CricManager) refresh() {
+	if err := m.Refresh(); err != nil {
+		GetLogger().Warnf("unable to refresh CRIC records from %s, error %v", m.URL, err)
+	}
+}
+
+// helper function to reload CRIC records on updates of shared cache until
+// given context is done
+func (m *CricManager) watch(ctx context.Context) {
+	for {
+		err := m.Shared.Watch(ctx, func() {
+			if err := m.loadShared(); err != nil {
+				GetLogger().Warnf("unable to load CRIC records from shared cache, error %v", err)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		GetLogger().Warnf("unable to watch shared CRIC cache, error %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DefaultCricWatchRetry):
+		}
+	}
+}
+
+// Get returns CRIC entry for given user login
+func (m *CricManager) Get(login string) (CricEntry, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	rec, ok := m.records[login]
+	return rec, ok
+}
+
+// LookupByDN returns CRIC entry for given primary or secondary DN using DN
+// index of current records. The DN can be provided either in slash or in
+// RFC 2253 comma form.
+func (m *CricManager) LookupByDN(dn string) (CricEntry, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key, ok := m.dnIndex[normalizeDN(dn)]
+	if !ok {
+		return CricEntry{}, false
+	}
+	rec, ok := m.records[key]
+	return rec, ok
+}
+
+// Records returns current CRIC records, the returned map should not be modified
+func (m *CricManager) Records() CricRecords {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.records
+}
+
+// Updated returns time of the last successful refresh
+func (m *CricManager) Updated() time.Time {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.updated
+}
+
+// Age returns age of current CRIC snapshot, zero if records were never loaded
+func (m *CricManager) Age() time.Duration {
+	updated := m.Updated()
+	if updated.IsZero() {
+		return 0
+	}
+	return time.Since(updated)
+}
+
+// Check returns StaleCricError if CRIC records were never loaded or if they
+// are older than MaxStaleness, it can be used in service health checks
+func (m *CricManager) Check() error {
+	updated := m.Updated()
+	if updated.IsZero() {
+		return &StaleCricError{MaxStaleness: m.MaxStaleness}
+	}
+	age := time.Since(updated)
+	if m.MaxStaleness > 0 && age > m.MaxStaleness {
+		return &StaleCricError{Age: age, MaxStaleness: m.MaxStaleness}
+	}
+	return nil
+}
+
+// Lookup returns CRIC entry for given user login similar to Get but respects
+// staleness policy, i.e. it returns StaleCricError if records are too old
+func (m *CricManager) Lookup(login string) (CricEntry, bool, error) {
+	if err := m.Check(); err != nil {
+		return CricEntry{}, false, err
+	}
+	rec, ok := m.Get(login)
+	return rec, ok, nil
+}
+
+// Handler returns HTTP handler which rejects requests with 503 status code
+// when CRIC records are not available or too stale, see Check
+func (m *CricManager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Check(); err != nil {
+			if m.Verbose {
+				GetLogger().Warnf("%v", err)
+			}
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package cmsauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCricManager function
+func TestCricManager(t *testing.T) {
+	server, available := testCricServer(t)
+	mgr := NewCricManager(server.URL, 10*time.Millisecond, false)
+	_, ok := mgr.Get("user1")
+	assert.Equal(t, ok, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mgr.Run(ctx)
+		close(done)
+	}()
+	for i := 0; i < 100 && mgr.Updated().IsZero(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec, ok := mgr.Get("user1")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.ID, int64(1))
+	assert.Equal(t, len(mgr.Records()), 2)
+	cancel()
+	<-done
+
+	// CRIC outage keeps last good snapshot
+	*available = false
+	assert.NotNil(t, mgr.Refresh())
+	_, ok = mgr.Get("user2")
+	assert.Equal(t, ok, true)
+}
+
+// TestCricManagerStaleness function
+func TestCricManagerStaleness(t *testing.T) {
+	server, available := testCricServer(t)
+	mgr := NewCricManager(server.URL, time.Hour, false)
+	mgr.MaxStaleness = time.Hour
+	var serr *StaleCricError
+	assert.Equal(t, errors.As(mgr.Check(), &serr), true)
+	assert.Equal(t, mgr.Age(), time.Duration(0))
+
+	handler := mgr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Code, http.StatusServiceUnavailable)
+
+	assert.Nil(t, mgr.Refresh())
+	assert.Nil(t, mgr.Check())
+	entry, ok, err := mgr.Lookup("user1")
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, entry.ID, int64(1))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Code, http.StatusOK)
+
+	// CRIC outage beyond allowed staleness
+	*available = false
+	assert.NotNil(t, mgr.Refresh())
+	mgr.mutex.Lock()
+	mgr.updated = time.Now().Add(-2 * time.Hour)
+	mgr.mutex.Unlock()
+	assert.Greater(t, mgr.Age(), time.Hour)
+	_, _, err = mgr.Lookup("user1")
+	assert.Equal(t, errors.As(err, &serr), true)
+	assert.Equal(t, serr.MaxStaleness, time.Hour)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, rec.Code, http.StatusServiceUnavailable)
+}
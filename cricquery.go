@@ -0,0 +1,79 @@
+package cmsauth
+
+import (
+	"sort"
+	"strings"
+)
+
+// Filter returns CRIC records which satisfy given predicate, the records keep
+// their keys
+func (r CricRecords) Filter(fn func(CricEntry) bool) CricRecords {
+	out := make(CricRecords)
+	for k, rec := range r {
+		if fn(rec) {
+			out[k] = rec
+		}
+	}
+	return out
+}
+
+// FilterByRole returns CRIC records of users with given role, role names are
+// matched in normalized form, e.g. "Data Manager" matches data-manager
+func (r CricRecords) FilterByRole(role string) CricRecords {
+	role = normalizeRole(role)
+	return r.Filter(func(rec CricEntry) bool {
+		for name := range rec.Roles {
+			if normalizeRole(name) == role {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// FilterByGroup returns CRIC records of users with any role in given group or
+// site, e.g. group:dbs or site:T1_*, trailing * matches group prefix
+func (r CricRecords) FilterByGroup(group string) CricRecords {
+	return r.Filter(func(rec CricEntry) bool {
+		for _, vals := range rec.Roles {
+			for _, val := range vals {
+				for _, v := range strings.Fields(val) {
+					if matchGroup(group, v) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	})
+}
+
+// Logins returns sorted list of unique logins of CRIC records
+func (r CricRecords) Logins() []string {
+	ulogins := make(map[string]bool)
+	for _, rec := range r {
+		if rec.Login != "" {
+			ulogins[rec.Login] = true
+		}
+	}
+	logins := []string{}
+	for login := range ulogins {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	return logins
+}
+
+// DNs returns sorted list of unique DNs of CRIC records, see AllDNs
+func (r CricRecords) DNs() []string {
+	return AllDNs(r)
+}
+
+// Entries returns list of CRIC entries sorted by their record keys
+func (r CricRecords) Entries() []CricEntry {
+	entries := make([]CricEntry, 0, len(r))
+	for _, k := range sortedCricKeys(r) {
+		entries = append(entries, r[k])
+	}
+	return entries
+}
@@ -0,0 +1,38 @@
+package cmsauth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCricRecordsQuery function
+func TestCricRecordsQuery(t *testing.T) {
+	entries := append(testCricEntries(), CricEntry{
+		DN:    "/DC=ch/DC=cern/OU=Users/CN=user3",
+		ID:    3,
+		Login: "user3",
+		Roles: map[string][]string{"Data Manager": {"site:T2_CH_CERN"}, "operator": {"group:das"}},
+	})
+	rmap, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	records := CricRecords(rmap)
+
+	operators := records.FilterByRole("operator")
+	assert.Equal(t, operators.Logins(), []string{"user1", "user3"})
+	assert.Equal(t, records.FilterByRole("data-manager").Logins(), []string{"user3"})
+	assert.Equal(t, len(records.FilterByRole("unknown")), 0)
+
+	assert.Equal(t, records.FilterByGroup("group:das").Logins(), []string{"user2", "user3"})
+	assert.Equal(t, records.FilterByGroup("site:T1_*").Logins(), []string{"user2"})
+	assert.Equal(t, records.FilterByGroup("SITE:t2_ch_cern").Logins(), []string{"user3"})
+	assert.Equal(t, records.FilterByRole("operator").FilterByGroup("group:dbs").Logins(), []string{"user1"})
+
+	assert.Equal(t, records.Logins(), []string{"user1", "user2", "user3"})
+	assert.Equal(t, records.DNs(), AllDNs(records))
+	assert.Equal(t, len(records.Entries()), 3)
+	for i, k := range sortedCricKeys(records) {
+		assert.Equal(t, records.Entries()[i].Login, records[k].Login)
+	}
+	assert.Equal(t, CricRecords{}.Logins(), []string{})
+}
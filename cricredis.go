@@ -0,0 +1,299 @@
+package cmsauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRedisCricKey defines default Redis key of shared CRIC snapshot
+var DefaultRedisCricKey = "cmsauth:cric"
+
+// DefaultRedisTimeout defines default timeout of Redis operations
+var DefaultRedisTimeout = 5 * time.Second
+
+// RedisCricCache implements SharedCricCache on top of Redis. The CRIC records
+// are stored as CRIC snapshot, see WriteCricSnapshot, under Key, the update
+// time under Key:updated and refresh lock under Key:lock, and updates are
+// announced on Key:updates channel. It uses plain Redis protocol and does
+// not require Redis client library.
+type RedisCricCache struct {
+	Addr     string        // Redis address, e.g. localhost:6379
+	Password string        // optional Redis password
+	DB       int           // Redis database number
+	Key      string        // Redis key of CRIC snapshot, default is DefaultRedisCricKey
+	Timeout  time.Duration // timeout of Redis operations, default is DefaultRedisTimeout
+
+	owner string
+}
+
+// NewRedisCricCache creates RedisCricCache for given Redis address
+func NewRedisCricCache(addr string) *RedisCricCache {
+	return &RedisCricCache{Addr: addr}
+}
+
+// helper function to return Redis key with given suffix
+func (c *RedisCricCache) key(suffix string) string {
+	key := c.Key
+	if key == "" {
+		key = DefaultRedisCricKey
+	}
+	if suffix == "" {
+		return key
+	}
+	return key + ":" + suffix
+}
+
+// helper function to return timeout of Redis operations
+func (c *RedisCricCache) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultRedisTimeout
+}
+
+// Lock implements SharedCricCache interface
+func (c *RedisCricCache) Lock(ttl time.Duration) (bool, error) {
+	if c.owner == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return false, err
+		}
+		c.owner = hex.EncodeToString(buf)
+	}
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	reply, err := c.do("SET", c.key("lock"), c.owner, "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// redisUnlockScript deletes lock key only if it is held by given owner
+const redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// Unlock implements SharedCricCache interface
+func (c *RedisCricCache) Unlock() error {
+	if c.owner == "" {
+		return nil
+	}
+	_, err := c.do("EVAL", redisUnlockScript, "1", c.key("lock"), c.owner)
+	return err
+}
+
+// Save implements SharedCricCache interface
+func (c *RedisCricCache) Save(records CricRecords, updated time.Time) error {
+	var buf bytes.Buffer
+	if err := WriteCricSnapshot(&buf, records); err != nil {
+		return err
+	}
+	stamp := strconv.FormatInt(updated.UnixNano(), 10)
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cmds := [][]string{
+		{"MULTI"},
+		{"SET", c.key(""), buf.String()},
+		{"SET", c.key("updated"), stamp},
+		{"EXEC"},
+		{"PUBLISH", c.key("updates"), stamp},
+	}
+	for _, cmd := range cmds {
+		if _, err := conn.do(cmd...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Updated implements SharedCricCache interface
+func (c *RedisCricCache) Updated() (time.Time, error) {
+	reply, err := c.do("GET", c.key("updated"))
+	if err != nil || reply == nil {
+		return time.Time{}, err
+	}
+	return parseRedisTime(reply)
+}
+
+// Load implements SharedCricCache interface
+func (c *RedisCricCache) Load() (CricRecords, time.Time, error) {
+	reply, err := c.do("MGET", c.key("updated"), c.key(""))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	vals, ok := reply.([]interface{})
+	if !ok || len(vals) != 2 || vals[0] == nil || vals[1] == nil {
+		return nil, time.Time{}, errors.New("CRIC records are not available in Redis")
+	}
+	updated, err := parseRedisTime(vals[0])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, _ := vals[1].(string)
+	records, err := ReadCricSnapshot(strings.NewReader(data))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return records, updated, nil
+}
+
+// Watch implements SharedCricCache interface
+func (c *RedisCricCache) Watch(ctx context.Context, notify func()) error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.do("SUBSCRIBE", c.key("updates")); err != nil {
+		return err
+	}
+	// close connection to interrupt blocking read when context is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	conn.conn.SetDeadline(time.Time{})
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if msg, ok := reply.([]interface{}); ok && len(msg) == 3 && msg[0] == "message" {
+			notify()
+		}
+	}
+}
+
+// helper function to parse update time stored in Redis
+func parseRedisTime(reply interface{}) (time.Time, error) {
+	val, _ := reply.(string)
+	nsec, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid CRIC update time '%s' in Redis", val)
+	}
+	return time.Unix(0, nsec), nil
+}
+
+// helper function to perform single Redis command
+func (c *RedisCricCache) do(args ...string) (interface{}, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.do(args...)
+}
+
+// redisConn represents connection to Redis server
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// helper function to connect to Redis server and select database
+func (c *RedisCricCache) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.Addr, c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: c.timeout()}
+	if c.Password != "" {
+		if _, err := rc.do("AUTH", c.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.DB > 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// Close closes Redis connection
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// helper function to send Redis command and read its reply
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// helper function to read Redis reply, nil bulk strings and arrays are
+// returned as nil, Redis errors are returned as errors
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("invalid Redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		vals := make([]interface{}, size)
+		for i := range vals {
+			if vals[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("invalid Redis reply '%s'", line)
+}
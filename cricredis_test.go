@@ -0,0 +1,233 @@
+package cmsauth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis implements subset of Redis commands used by RedisCricCache
+type fakeRedis struct {
+	mutex  sync.Mutex
+	data   map[string]string
+	expire map[string]time.Time
+	subs   map[string][]net.Conn
+}
+
+// helper function to start fake Redis server and return its address
+func testRedisServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	srv := &fakeRedis{data: make(map[string]string), expire: make(map[string]time.Time), subs: make(map[string][]net.Conn)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// helper function to encode Redis reply, string values are bulk strings
+// except OK and QUEUED status replies
+func redisReply(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "$-1\r\n"
+	case int:
+		return fmt.Sprintf(":%d\r\n", t)
+	case string:
+		if t == "OK" || t == "QUEUED" {
+			return "+" + t + "\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(t), t)
+	case []interface{}:
+		out := fmt.Sprintf("*%d\r\n", len(t))
+		for _, e := range t {
+			out += redisReply(e)
+		}
+		return out
+	}
+	return "-ERR unsupported reply\r\n"
+}
+
+// helper function to serve Redis connection
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	var multi []interface{}
+	inMulti := false
+	for {
+		reply, err := rc.read()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range reply.([]interface{}) {
+			args = append(args, a.(string))
+		}
+		f.mutex.Lock()
+		var out interface{}
+		switch strings.ToUpper(args[0]) {
+		case "MULTI":
+			inMulti, multi, out = true, nil, "OK"
+		case "EXEC":
+			inMulti, out = false, multi
+		case "SUBSCRIBE":
+			f.subs[args[1]] = append(f.subs[args[1]], conn)
+			out = []interface{}{"subscribe", args[1], 1}
+		default:
+			out = f.exec(args)
+		}
+		if inMulti && args[0] != "MULTI" {
+			multi = append(multi, out)
+			out = "QUEUED"
+		}
+		conn.Write([]byte(redisReply(out)))
+		f.mutex.Unlock()
+	}
+}
+
+// helper function to execute data command
+func (f *fakeRedis) exec(args []string) interface{} {
+	get := func(key string) interface{} {
+		if exp, ok := f.expire[key]; ok && time.Now().After(exp) {
+			delete(f.data, key)
+			delete(f.expire, key)
+		}
+		if val, ok := f.data[key]; ok {
+			return val
+		}
+		return nil
+	}
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT":
+		return "OK"
+	case "GET":
+		return get(args[1])
+	case "MGET":
+		var vals []interface{}
+		for _, key := range args[1:] {
+			vals = append(vals, get(key))
+		}
+		return vals
+	case "SET":
+		if len(args) > 3 && args[3] == "NX" && get(args[1]) != nil {
+			return nil
+		}
+		f.data[args[1]] = args[2]
+		if len(args) > 5 && args[4] == "PX" {
+			ms, _ := strconv.Atoi(args[5])
+			f.expire[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "OK"
+	case "EVAL":
+		// unlock script, see redisUnlockScript
+		if get(args[3]) == args[4] {
+			delete(f.data, args[3])
+			return 1
+		}
+		return 0
+	case "PUBLISH":
+		msg := redisReply([]interface{}{"message", args[1], args[2]})
+		for _, sub := range f.subs[args[1]] {
+			sub.Write([]byte(msg))
+		}
+		return len(f.subs[args[1]])
+	}
+	return nil
+}
+
+// TestRedisCricCache function
+func TestRedisCricCache(t *testing.T) {
+	addr := testRedisServer(t)
+	cache := NewRedisCricCache(addr)
+	updated, err := cache.Updated()
+	assert.Nil(t, err)
+	assert.Equal(t, updated.IsZero(), true)
+	_, _, err = cache.Load()
+	assert.NotNil(t, err)
+
+	ok, err := cache.Lock(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+	ok, err = NewRedisCricCache(addr).Lock(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, ok, false)
+
+	records, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	now := time.Now()
+	assert.Nil(t, cache.Save(records, now))
+	updated, err = cache.Updated()
+	assert.Nil(t, err)
+	assert.Equal(t, updated.UnixNano(), now.UnixNano())
+	loaded, updated, err := cache.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, updated.UnixNano(), now.UnixNano())
+	assert.Equal(t, loaded, CricRecords(records))
+
+	// released lock can be acquired by other instance
+	assert.Nil(t, NewRedisCricCache(addr).Unlock())
+	assert.Nil(t, cache.Unlock())
+	ok, err = NewRedisCricCache(addr).Lock(time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, ok, true)
+
+	_, err = NewRedisCricCache("127.0.0.1:1").Updated()
+	assert.NotNil(t, err)
+}
+
+// TestCricManagerShared function
+func TestCricManagerShared(t *testing.T) {
+	server, _ := testCricServer(t)
+	addr := testRedisServer(t)
+	leader := NewCricManager(server.URL, time.Hour, false)
+	leader.Shared = NewRedisCricCache(addr)
+	// follower can not reach CRIC and relies on shared cache
+	follower := NewCricManager("http://127.0.0.1:1", time.Hour, false)
+	follower.Shared = NewRedisCricCache(addr)
+
+	assert.NotNil(t, follower.Refresh())
+	assert.Nil(t, leader.Refresh())
+	assert.Equal(t, len(leader.Records()), 2)
+	assert.Nil(t, follower.Refresh())
+	assert.Equal(t, len(follower.Records()), 2)
+	assert.Equal(t, follower.Updated().UnixNano(), leader.Updated().UnixNano())
+
+	// follower reloads records on updates published by other instance
+	var deltas int32
+	follower.Subscribe(func(delta CricDelta) { atomic.AddInt32(&deltas, 1) })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		follower.Run(ctx)
+		close(done)
+	}()
+	records := CricRecords{"user1": leader.Records()["user1"]}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Shared.Save(records, time.Now()))
+		time.Sleep(10 * time.Millisecond)
+		if len(follower.Records()) == 1 {
+			break
+		}
+	}
+	_, ok := follower.Get("user2")
+	assert.Equal(t, ok, false)
+	cancel()
+	<-done
+	assert.Equal(t, atomic.LoadInt32(&deltas) > 0, true)
+}
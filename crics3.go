@@ -0,0 +1,240 @@
+package cmsauth
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3DefaultRegion defines region used to sign S3 requests if it is not
+// provided by S3CricSource or AWS_REGION environment
+var S3DefaultRegion = "us-east-1"
+
+// S3CricSource provides CRIC data from JSON snapshot stored in S3 compatible
+// object storage, e.g. CERN S3 or MinIO. The object is addressed in path
+// style, i.e. Endpoint/Bucket/Key, and requests are signed with AWS
+// Signature Version 4. If credentials are not provided they are taken from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment,
+// without them anonymous request is made, e.g. for public buckets.
+type S3CricSource struct {
+	Endpoint     string  // S3 endpoint, e.g. https://s3.cern.ch
+	Region       string  // S3 region, default AWS_REGION or S3DefaultRegion
+	Bucket       string  // bucket name
+	Key          string  // object key of CRIC snapshot
+	AccessKey    string  // access key ID
+	SecretKey    string  // secret access key
+	SessionToken string  // optional session token of temporary credentials
+	Client       *Client // client to use, if not set package configuration is used
+}
+
+// CricEntries implements CricSource interface
+func (s *S3CricSource) CricEntries(ctx context.Context) ([]CricEntry, error) {
+	var entries []CricEntry
+	err := s.read(ctx, func(r io.Reader, limit int64) error {
+		return streamCricEntriesLimit(r, limit, func(rec CricEntry) error {
+			entries = append(entries, rec)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// helper function to build CRIC records from CRIC snapshot stream
+func (s *S3CricSource) buildCricRecords(ctx context.Context, b *cricRecordsBuilder) error {
+	return s.read(ctx, func(r io.Reader, limit int64) error {
+		b.limit = limit
+		return b.build(r)
+	})
+}
+
+// helper function to get CRIC snapshot and pass its stream to given function
+// along with limit of CRIC data size
+func (s *S3CricSource) read(ctx context.Context, fn func(io.Reader, int64) error) error {
+	client := s.Client
+	if client == nil {
+		client = defaultClient()
+	}
+	// S3 requests are authenticated by signature, i.e. X509 certs are not used
+	hc, err := client.newHttpClient(false)
+	if err != nil {
+		return err
+	}
+	req, err := s.request(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get s3://%s/%s, status %s", s.Bucket, s.Key, resp.Status)
+	}
+	var reader io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unable to decompress CRIC snapshot: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	return fn(reader, client.cricMaxBytes())
+}
+
+// helper function to create signed S3 GET object request
+func (s *S3CricSource) request(ctx context.Context) (*http.Request, error) {
+	if s.Endpoint == "" || s.Bucket == "" || s.Key == "" {
+		return nil, fmt.Errorf("S3 endpoint, bucket and key should be provided")
+	}
+	rurl := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + strings.TrimPrefix(s.Key, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", rurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	accessKey, secretKey, token := s.AccessKey, s.SecretKey, s.SessionToken
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKey == "" {
+		return req, nil
+	}
+	region := s.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = S3DefaultRegion
+	}
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signS3Request(req, accessKey, secretKey, region, time.Now())
+	return req, nil
+}
+
+// helper function to sign S3 request without body with AWS Signature
+// Version 4, the host and all request headers are signed
+func signS3Request(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	const service = "s3"
+	// hash of empty payload
+	payloadHash := hex.EncodeToString(sha256Sum(nil))
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		var vals []string
+		for _, v := range values {
+			vals = append(vals, strings.Join(strings.Fields(v), " "))
+		}
+		headers[strings.ToLower(key)] = strings.Join(vals, ",")
+	}
+	var keys []string
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalHeaders strings.Builder
+	for _, key := range keys {
+		canonicalHeaders.WriteString(key + ":" + headers[key] + "\n")
+	}
+	signedHeaders := strings.Join(keys, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.EscapedPath()),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest)))
+
+	key := []byte("AWS4" + secretKey)
+	for _, v := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// helper function to return canonical S3 path, i.e. path segments URI
+// encoded according to RFC 3986
+func s3EscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if v, err := url.PathUnescape(p); err == nil {
+			p = v
+		}
+		parts[i] = s3Escape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// helper function to return canonical S3 query string
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, v := range values {
+			params = append(params, s3Escape(key)+"="+s3Escape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// helper function to URI encode given string, only RFC 3986 unreserved
+// characters are kept
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// helper function to calculate SHA256 of given data
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// helper function to calculate HMAC-SHA256 of given value
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,97 @@
+package cmsauth
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cricSnapshotVersion defines version of CRIC snapshot format, it should be
+// incremented on incompatible changes of CricEntry
+const cricSnapshotVersion = 1
+
+// cricSnapshot represents content of CRIC snapshot file
+type cricSnapshot struct {
+	Version int         // snapshot format version
+	Created int64       // snapshot creation time as unix time
+	Records CricRecords // processed CRIC records
+}
+
+// WriteCricSnapshot writes processed CRIC records into given writer in
+// compact gzip compressed gob format
+func WriteCricSnapshot(w io.Writer, records CricRecords) error {
+	zw := gzip.NewWriter(w)
+	snapshot := cricSnapshot{Version: cricSnapshotVersion, Created: time.Now().Unix(), Records: records}
+	if err := gob.NewEncoder(zw).Encode(snapshot); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadCricSnapshot reads CRIC records written by WriteCricSnapshot
+func ReadCricSnapshot(r io.Reader) (CricRecords, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRIC snapshot: %v", err)
+	}
+	defer zr.Close()
+	var snapshot cricSnapshot
+	if err := gob.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("invalid CRIC snapshot: %v", err)
+	}
+	if snapshot.Version != cricSnapshotVersion {
+		return nil, fmt.Errorf("unsupported CRIC snapshot version %d", snapshot.Version)
+	}
+	records := snapshot.Records
+	if records == nil {
+		records = make(CricRecords)
+	}
+	// gob does not transmit empty maps, restore them as JSON decoding does
+	for k, rec := range records {
+		if rec.Roles == nil {
+			rec.Roles = make(map[string][]string)
+			records[k] = rec
+		}
+	}
+	return records, nil
+}
+
+// SaveCricSnapshot atomically saves processed CRIC records into given file.
+// The snapshot keeps records along with their keys and sorted DNs, therefore
+// LoadCricSnapshot is much faster than parsing CRIC data on service start.
+func SaveCricSnapshot(fname string, records CricRecords) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), "cric-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	bw := bufio.NewWriter(tmp)
+	if err := WriteCricSnapshot(bw, records); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+// LoadCricSnapshot loads CRIC records from file created by SaveCricSnapshot
+func LoadCricSnapshot(fname string) (CricRecords, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCricSnapshot(bufio.NewReader(file))
+}
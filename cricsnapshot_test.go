@@ -0,0 +1,59 @@
+package cmsauth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCricSnapshot function
+func TestCricSnapshot(t *testing.T) {
+	entries := append(testCricEntries(), CricEntry{DN: "/CN=user3", ID: 3, Login: "user3"})
+	records, err := getCricRecords(entries, false)
+	assert.Nil(t, err)
+	fname := filepath.Join(t.TempDir(), "cric.snapshot")
+	err = SaveCricSnapshot(fname, records)
+	assert.Nil(t, err)
+	loaded, err := LoadCricSnapshot(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, CricFingerprint(loaded), CricFingerprint(records))
+	for k, rec := range records {
+		assert.True(t, rec.Equal(loaded[k]), k)
+	}
+	// empty roles are restored as empty map
+	rec, ok := CricRecords(loaded).LookupByDN("/CN=user3")
+	assert.True(t, ok)
+	assert.NotNil(t, rec.Roles)
+
+	// empty records
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCricSnapshot(&buf, nil))
+	loaded, err = ReadCricSnapshot(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(loaded), 0)
+
+	// corrupted and missing snapshots
+	os.WriteFile(fname, []byte("[]"), 0644)
+	_, err = LoadCricSnapshot(fname)
+	assert.NotNil(t, err)
+	_, err = LoadCricSnapshot(filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err)
+}
+
+// BenchmarkLoadCricSnapshot benchmarks loading of CRIC snapshot
+func BenchmarkLoadCricSnapshot(b *testing.B) {
+	records, _ := getCricRecords(testCricStreamEntries(10000), false)
+	fname := filepath.Join(b.TempDir(), "cric.snapshot")
+	if err := SaveCricSnapshot(fname, records); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadCricSnapshot(fname); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
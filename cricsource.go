@@ -0,0 +1,118 @@
+package cmsauth
+
+import (
+	"context"
+	"os"
+)
+
+// CricSource defines interface of CRIC data source, e.g. CRIC HTTP endpoint,
+// CRIC snapshot file or in-memory list of entries
+type CricSource interface {
+	CricEntries(ctx context.Context) ([]CricEntry, error)
+}
+
+// cricRecordsSource is implemented by CRIC sources which build CRIC records
+// directly from CRIC data stream, i.e. without decoding whole list of
+// entries first
+type cricRecordsSource interface {
+	buildCricRecords(ctx context.Context, b *cricRecordsBuilder) error
+}
+
+// HTTPCricSource provides CRIC data from HTTP(S) endpoint, e.g. CRIC server
+// or pre-signed URL of object storage (S3) snapshot
+type HTTPCricSource struct {
+	URL     string  // CRIC URL
+	Client  *Client // client to use, if not set package configuration is used
+	Verbose bool    // verbose mode
+}
+
+// CricEntries implements CricSource interface
+func (s *HTTPCricSource) CricEntries(ctx context.Context) ([]CricEntry, error) {
+	client := s.Client
+	if client == nil {
+		client = defaultClient()
+	}
+	return client.GetCricEntriesCtx(ctx, s.URL, s.Verbose)
+}
+
+// helper function to build CRIC records from CRIC response stream
+func (s *HTTPCricSource) buildCricRecords(ctx context.Context, b *cricRecordsBuilder) error {
+	client := s.Client
+	if client == nil {
+		client = defaultClient()
+	}
+	return client.fetchCricRecords(ctx, s.URL, b, s.Verbose)
+}
+
+// FileCricSource provides CRIC data from JSON file, e.g. CRIC snapshot
+// mounted from Kubernetes ConfigMap
+type FileCricSource struct {
+	Path string // CRIC file name
+}
+
+// CricEntries implements CricSource interface
+func (s *FileCricSource) CricEntries(ctx context.Context) ([]CricEntry, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []CricEntry
+	err = streamCricEntries(file, func(rec CricEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries = append(entries, rec)
+		return nil
+	})
+	return entries, err
+}
+
+// helper function to build CRIC records from CRIC file stream
+func (s *FileCricSource) buildCricRecords(ctx context.Context, b *cricRecordsBuilder) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return b.build(file)
+}
+
+// MemoryCricSource provides CRIC data from in-memory list of entries, e.g.
+// in tests
+type MemoryCricSource struct {
+	Entries []CricEntry // CRIC entries
+}
+
+// CricEntries implements CricSource interface
+func (s *MemoryCricSource) CricEntries(ctx context.Context) ([]CricEntry, error) {
+	entries := make([]CricEntry, len(s.Entries))
+	copy(entries, s.Entries)
+	return entries, nil
+}
+
+// GetCricDataFromSource obtains CRIC data from given source and uses sorted DN
+// as a key for CRIC records map
+func GetCricDataFromSource(ctx context.Context, src CricSource, verbose bool) (map[string]CricEntry, error) {
+	return GetCricDataFromSourceByKey(ctx, src, cricKeySortedDN, verbose)
+}
+
+// GetCricDataFromSourceByKey obtains CRIC data from given source and uses
+// given CRIC key for records map
+func GetCricDataFromSourceByKey(ctx context.Context, src CricSource, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	if rs, ok := src.(cricRecordsSource); ok {
+		b := newCricRecordsBuilder(key, verbose)
+		if err := rs.buildCricRecords(ctx, b); err != nil {
+			return make(map[string]CricEntry), err
+		}
+		return b.records, nil
+	}
+	entries, err := src.CricEntries(ctx)
+	if err != nil {
+		return make(map[string]CricEntry), err
+	}
+	return getCricRecordsByCricKey(entries, key, verbose)
+}
@@ -0,0 +1,84 @@
+package cmsauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCricSource function
+func TestCricSource(t *testing.T) {
+	ctx := context.Background()
+	server, _ := testCricServer(t)
+	fname := filepath.Join(t.TempDir(), "cric.json")
+	data, _ := json.Marshal(testCricEntries())
+	err := os.WriteFile(fname, data, 0600)
+	assert.Nil(t, err)
+
+	sources := []CricSource{
+		&HTTPCricSource{URL: server.URL},
+		&FileCricSource{Path: fname},
+		&MemoryCricSource{Entries: testCricEntries()},
+	}
+	for _, src := range sources {
+		records, err := GetCricDataFromSourceByKey(ctx, src, CricKeyLogin, false)
+		assert.Nil(t, err)
+		assert.Equal(t, len(records), 2)
+		assert.Equal(t, records["user2"].ID, int64(2))
+		records, err = GetCricDataFromSource(ctx, src, false)
+		assert.Nil(t, err)
+		assert.Equal(t, len(records), 2)
+	}
+
+	records, err := ParseCricByKey(fname, "login", false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["user1"].ID, int64(1))
+	_, err = GetCricDataFromSource(ctx, &FileCricSource{Path: fname + ".missing"}, false)
+	assert.NotNil(t, err)
+	records, err = ParseCric(fname+".missing", false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), 0)
+}
+
+// TestCricSourceStream function
+func TestCricSourceStream(t *testing.T) {
+	ctx := context.Background()
+	data, _ := json.Marshal(testCricEntries())
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// truncated response is retried and records are built again
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+			w.Write(data[:len(data)/2])
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	var src CricSource = &HTTPCricSource{URL: server.URL, Client: New(Config{
+		Token:     "token",
+		CricRetry: RetryPolicy{Retries: 1},
+	})}
+	_, ok := src.(cricRecordsSource)
+	assert.Equal(t, ok, true)
+	records, err := GetCricDataFromSourceByKey(ctx, src, CricKeyLogin, false)
+	assert.Nil(t, err)
+	assert.Equal(t, attempts, 2)
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, records["user1"].DNs, []string{records["user1"].DN})
+
+	// size limit applies to streamed CRIC data
+	src = &HTTPCricSource{URL: server.URL, Client: New(Config{Token: "token", CricMaxBytes: 10})}
+	_, err = GetCricDataFromSource(ctx, src, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "too large")
+}
@@ -0,0 +1,158 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CricStore provides read-through CRIC cache backed by the filesystem. Each
+// successful fetch of CRIC data is persisted to local snapshot which is used
+// when CRIC endpoint is not reachable. The ETag and Last-Modified values of
+// CRIC response are persisted along with snapshot and used in conditional
+// requests, i.e. CRIC data is not downloaded again if it is not changed.
+type CricStore struct {
+	URL     string // CRIC URL
+	Dir     string // directory to keep CRIC snapshot
+	Verbose bool   // verbose mode
+
+	mutex   sync.RWMutex
+	records CricRecords
+	meta    CricCacheMeta
+}
+
+// GetCricDataCached downloads CRIC data using snapshot kept in given
+// directory to avoid downloading CRIC data if it is not changed
+func GetCricDataCached(rurl, dir string, verbose bool) (map[string]CricEntry, error) {
+	records, _, err := NewCricStore(rurl, dir, verbose).Fetch()
+	return records, err
+}
+
+// NewCricStore creates new instance of CricStore
+func NewCricStore(rurl, dir string, verbose bool) *CricStore {
+	return &CricStore{URL: rurl, Dir: dir, Verbose: verbose}
+}
+
+// SnapshotFile returns location of CRIC snapshot file
+func (s *CricStore) SnapshotFile() string {
+	return filepath.Join(s.Dir, "cric.json")
+}
+
+// Fetch fetches CRIC records from CRIC URL and persists them on disk. If CRIC
+// endpoint is not available it returns records from the last saved snapshot
+// and sets stale flag to true.
+func (s *CricStore) Fetch() (CricRecords, bool, error) {
+	s.mutex.RLock()
+	cached, prev := s.records, s.meta
+	s.mutex.RUnlock()
+	if cached == nil {
+		if records, err := s.load(); err == nil {
+			cached, prev = records, s.loadMeta()
+		}
+	}
+	entries, meta, notModified, err := GetCricEntriesConditional(s.URL, prev, s.Verbose)
+	if err == nil && notModified && cached != nil {
+		s.update(cached, meta)
+		return cached, false, nil
+	}
+	if err == nil && notModified {
+		// we have no snapshot to use, fetch CRIC data unconditionally
+		entries, meta, _, err = GetCricEntriesConditional(s.URL, CricCacheMeta{}, s.Verbose)
+	}
+	var records CricRecords
+	if err == nil {
+		records, err = getCricRecords(entries, s.Verbose)
+	}
+	if err == nil {
+		s.update(records, meta)
+		if e := s.save(records, meta); e != nil {
+			GetLogger().Warnf("unable to save CRIC snapshot to %s, error %v", s.Dir, e)
+		}
+		return records, false, nil
+	}
+	if s.Verbose {
+		GetLogger().Warnf("unable to fetch CRIC data from %s, error %v, fall back to snapshot", s.URL, err)
+	}
+	if cached != nil {
+		s.update(cached, prev)
+		return cached, true, nil
+	}
+	return make(CricRecords), false, err
+}
+
+// helper function to update in-memory CRIC records and cache meta-data
+func (s *CricStore) update(records CricRecords, meta CricCacheMeta) {
+	s.mutex.Lock()
+	s.records = records
+	s.meta = meta
+	s.mutex.Unlock()
+}
+
+// helper function to return location of CRIC cache meta-data file
+func (s *CricStore) metaFile() string {
+	return filepath.Join(s.Dir, "cric.meta.json")
+}
+
+// helper function to load CRIC cache meta-data, empty meta-data is returned
+// on error which leads to unconditional CRIC request
+func (s *CricStore) loadMeta() CricCacheMeta {
+	var meta CricCacheMeta
+	data, err := os.ReadFile(s.metaFile())
+	if err != nil {
+		return meta
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return CricCacheMeta{}
+	}
+	return meta
+}
+
+// helper function to atomically save CRIC records and cache meta-data
+func (s *CricStore) save(records CricRecords, meta CricCacheMeta) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	// remove stale meta-data first, so it never refers to older snapshot
+	os.Remove(s.metaFile())
+	if err := s.writeFile(s.SnapshotFile(), data); err != nil {
+		return err
+	}
+	data, err = json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(s.metaFile(), data)
+}
+
+// helper function to atomically write data to given file
+func (s *CricStore) writeFile(fname string, data []byte) error {
+	tmp, err := os.CreateTemp(s.Dir, "cric-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+// helper function to load CRIC records from snapshot file
+func (s *CricStore) load() (CricRecords, error) {
+	data, err := os.ReadFile(s.SnapshotFile())
+	if err != nil {
+		return nil, err
+	}
+	var records CricRecords
+	err = json.Unmarshal(data, &records)
+	return records, err
+}
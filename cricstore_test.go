@@ -0,0 +1,91 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to start test CRIC server, the returned flag controls
+// whether server is available
+func testCricServer(t *testing.T) (*httptest.Server, *bool) {
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("service unavailable"))
+			return
+		}
+		data, _ := json.Marshal(testCricEntries())
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server, &available
+}
+
+// TestCricStore function
+func TestCricStore(t *testing.T) {
+	server, available := testCricServer(t)
+	dir := t.TempDir()
+	store := NewCricStore(server.URL, dir, false)
+	records, stale, err := store.Fetch()
+	assert.Nil(t, err)
+	assert.Equal(t, stale, false)
+	assert.Equal(t, len(records), 2)
+
+	// simulate outage with new store instance, i.e. after service restart
+	*available = false
+	store = NewCricStore(server.URL, dir, false)
+	records2, stale, err := store.Fetch()
+	assert.Nil(t, err)
+	assert.Equal(t, stale, true)
+	assert.Equal(t, records2, records)
+
+	// no snapshot available
+	store = NewCricStore(server.URL, t.TempDir(), false)
+	_, stale, err = store.Fetch()
+	assert.NotNil(t, err)
+	assert.Equal(t, stale, false)
+}
+
+// TestCricStoreConditional function
+func TestCricStoreConditional(t *testing.T) {
+	etag := `"v1"`
+	var downloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", etag)
+		data, _ := json.Marshal(testCricEntries())
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	records, err := GetCricDataCached(server.URL, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, downloads, 1)
+
+	// new store, i.e. after service restart, uses snapshot and its ETag
+	records2, err := GetCricDataCached(server.URL, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, records2, records)
+	assert.Equal(t, downloads, 1)
+
+	// snapshot is removed, data should be downloaded again
+	err = os.Remove(filepath.Join(dir, "cric.json"))
+	assert.Nil(t, err)
+	records2, err = GetCricDataCached(server.URL, dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, records2, records)
+	assert.Equal(t, downloads, 2)
+}
@@ -0,0 +1,270 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CricParseWorkers defines number of workers which post-process CRIC entries
+// while CRIC records are built from a stream, zero or one means sequential
+// processing. The records do not depend on number of workers, but CRIC
+// post-processor set by SetCricPostProcessor should be safe for concurrent use.
+var CricParseWorkers int
+
+// cricKeySortedDN is internal CRIC key used to build records map keyed by
+// sorted DN, see ParseCric
+const cricKeySortedDN CricKey = -1
+
+// cricLimitReader fails reading of CRIC data exceeding given limit, by default
+// MaxCricResponseBytes
+type cricLimitReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+// Read implements io.Reader interface
+func (l *cricLimitReader) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, fmt.Errorf("CRIC data too large, exceeds %d bytes", l.limit)
+	}
+	if remain := l.limit + 1 - l.read; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, fmt.Errorf("CRIC data too large, exceeds %d bytes", l.limit)
+	}
+	return n, err
+}
+
+// helper function to decode JSON list of CRIC entries from given reader one
+// by one without reading whole CRIC data into memory
+func streamCricEntries(r io.Reader, fn func(CricEntry) error) error {
+	return streamCricEntriesLimit(r, MaxCricResponseBytes, fn)
+}
+
+// helper function to decode JSON list of CRIC entries from given reader with
+// given limit of CRIC data size
+func streamCricEntriesLimit(r io.Reader, limit int64, fn func(CricEntry) error) error {
+	lr := &cricLimitReader{r: r, limit: limit}
+	dec := json.NewDecoder(lr)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil { // JSON null
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("unexpected CRIC data, expect list of entries, got %v", tok)
+	}
+	var count int
+	for dec.More() {
+		if count >= MaxCricEntries {
+			return fmt.Errorf("number of CRIC entries exceeds maximum of %d", MaxCricEntries)
+		}
+		var rec CricEntry
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		count++
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	// decoder may complete with data read beyond the limit
+	if lr.read > lr.limit {
+		return fmt.Errorf("CRIC data too large, exceeds %d bytes", lr.limit)
+	}
+	return nil
+}
+
+// cricRecordsBuilder incrementally builds CRIC records map keyed by CRIC key
+type cricRecordsBuilder struct {
+	key     CricKey
+	verbose bool
+	limit   int64 // limit of CRIC data size
+	entries int   // number of merged CRIC entries
+	records map[string]CricEntry
+}
+
+// helper function to create new CRIC records builder
+func newCricRecordsBuilder(key CricKey, verbose bool) *cricRecordsBuilder {
+	return &cricRecordsBuilder{key: key, verbose: verbose, limit: MaxCricResponseBytes, records: make(map[string]CricEntry)}
+}
+
+// helper function to drop records built so far, e.g. before retry of CRIC
+// request
+func (b *cricRecordsBuilder) reset() {
+	b.entries = 0
+	b.records = make(map[string]CricEntry)
+}
+
+// helper function to post-process CRIC entry and return its records key, it
+// does not modify builder state and therefore can be run concurrently
+func (b *cricRecordsBuilder) prepare(rec *CricEntry) (string, error) {
+	if rec.Roles == nil {
+		rec.Roles = make(map[string][]string)
+	}
+	rec.DN = NormalizeDN(rec.DN)
+	// copy DNs to not modify entries provided by caller
+	dns := make([]string, 0, len(rec.DNs)+1)
+	for _, dn := range rec.DNs {
+		dns = append(dns, NormalizeDN(dn))
+	}
+	rec.DNs = dns
+	postProcessCricEntry(rec)
+	switch b.key {
+	case cricKeySortedDN:
+		rec.SortedDN = GetSortedDN(rec.DN)
+		return rec.SortedDN, nil
+	case CricKeyLogin:
+		return rec.Login, nil
+	case CricKeyID:
+		return fmt.Sprintf("%d", rec.ID), nil
+	case CricKeyName:
+		return rec.Name, nil
+	case CricKeyDN:
+		return rec.DN, nil
+	}
+	msg := fmt.Sprintf("provided key=%s is not supported", b.key)
+	return "", errors.New(msg)
+}
+
+// helper function to add prepared CRIC entry to records, DNs of duplicate
+// entries are merged
+func (b *cricRecordsBuilder) merge(k string, rec CricEntry) {
+	recDNs := rec.DNs
+	if r, ok := b.records[k]; ok {
+		recDNs = r.DNs
+		recDNs = append(recDNs, rec.DN)
+		rec.DNs = recDNs
+		if b.verbose {
+			GetLogger().Infof("Found duplicate CRIC record\n%s\n%s", rec.String(), r.String())
+		}
+	} else {
+		recDNs = append(recDNs, rec.DN)
+		rec.DNs = recDNs
+	}
+	b.records[k] = rec
+	b.entries++
+}
+
+// helper function to add CRIC entry to records
+func (b *cricRecordsBuilder) add(rec CricEntry) error {
+	k, err := b.prepare(&rec)
+	if err != nil {
+		return err
+	}
+	b.merge(k, rec)
+	return nil
+}
+
+// helper function to build CRIC records from CRIC data stream
+func (b *cricRecordsBuilder) build(r io.Reader) error {
+	if CricParseWorkers <= 1 {
+		return streamCricEntriesLimit(r, b.limit, b.add)
+	}
+	return b.buildParallel(r, CricParseWorkers)
+}
+
+// preparedCricEntry represents post-processed CRIC entry and its position in
+// CRIC data stream
+type preparedCricEntry struct {
+	index int
+	key   string
+	rec   CricEntry
+	err   error
+}
+
+// helper function to build CRIC records using pool of workers which
+// post-process CRIC entries, entries are merged in stream order
+func (b *cricRecordsBuilder) buildParallel(r io.Reader, workers int) error {
+	type job struct {
+		index int
+		rec   CricEntry
+	}
+	jobs := make(chan job, workers)
+	results := make(chan preparedCricEntry, workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				k, err := b.prepare(&j.rec)
+				select {
+				case results <- preparedCricEntry{index: j.index, key: k, rec: j.rec, err: err}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	var streamErr error
+	go func() {
+		defer close(jobs)
+		var index int
+		streamErr = streamCricEntriesLimit(r, b.limit, func(rec CricEntry) error {
+			select {
+			case jobs <- job{index: index, rec: rec}:
+			case <-done:
+				return errors.New("CRIC records build is aborted")
+			}
+			index++
+			return nil
+		})
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	// merge entries in stream order
+	pending := make(map[int]preparedCricEntry)
+	var next int
+	var err error
+	for res := range results {
+		if err != nil {
+			continue
+		}
+		pending[res.index] = res
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if p.err != nil {
+				err = p.err
+				close(done)
+				break
+			}
+			b.merge(p.key, p.rec)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// results channel is closed after stream is finished
+	return streamErr
+}
+
+// ParseCricStream builds CRIC records map keyed by given CRIC key from JSON
+// list of CRIC entries provided by given reader. The entries are decoded one
+// by one, i.e. whole CRIC data is not kept in memory, and post-processed by
+// CricParseWorkers workers.
+func ParseCricStream(r io.Reader, key CricKey, verbose bool) (map[string]CricEntry, error) {
+	b := newCricRecordsBuilder(key, verbose)
+	err := b.build(r)
+	return b.records, err
+}
@@ -0,0 +1,90 @@
+package cmsauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to create CRIC entries with duplicate logins
+func testCricStreamEntries(n int) []CricEntry {
+	var entries []CricEntry
+	for i := 0; i < n; i++ {
+		login := fmt.Sprintf("user%d", i%(n/2+1))
+		entries = append(entries, CricEntry{
+			DN:    fmt.Sprintf("/DC=ch/DC=cern/OU=Users/CN=%s/CN=%d", login, i),
+			ID:    int64(i),
+			Login: login,
+			Name:  strings.ToUpper(login),
+			Roles: map[string][]string{"operator": {"group:dbs"}},
+		})
+	}
+	return entries
+}
+
+// TestParseCricStream function
+func TestParseCricStream(t *testing.T) {
+	entries := testCricStreamEntries(500)
+	data, _ := json.Marshal(entries)
+	expect, err := getCricRecordsByCricKey(entries, CricKeyLogin, false)
+	assert.Nil(t, err)
+
+	workers := CricParseWorkers
+	defer func() { CricParseWorkers = workers }()
+	for _, w := range []int{0, 1, 4} {
+		CricParseWorkers = w
+		records, err := ParseCricStream(bytes.NewReader(data), CricKeyLogin, false)
+		assert.Nil(t, err)
+		assert.Equal(t, CricFingerprint(records), CricFingerprint(expect))
+		// duplicate entries are merged in stream order
+		assert.Equal(t, records["user0"].ID, int64(251))
+		assert.Equal(t, len(records["user0"].DNs), 2)
+	}
+
+	// errors are reported by parallel build too
+	CricParseWorkers = 4
+	_, err = ParseCricStream(bytes.NewReader(data), CricKey(100), false)
+	assert.NotNil(t, err)
+	_, err = ParseCricStream(strings.NewReader(`[{"DN": "/CN=a"}, {"DN": 1}]`), CricKeyLogin, false)
+	assert.NotNil(t, err)
+
+	limit := MaxCricResponseBytes
+	defer func() { MaxCricResponseBytes = limit }()
+	MaxCricResponseBytes = int64(len(data))
+	_, err = ParseCricStream(bytes.NewReader(data), CricKeyLogin, false)
+	assert.Nil(t, err)
+	MaxCricResponseBytes = int64(len(data)) - 1
+	_, err = ParseCricStream(bytes.NewReader(data), CricKeyLogin, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "too large")
+}
+
+// TestParseCricFile function
+func TestParseCricFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cric.json")
+	data, _ := json.Marshal(testCricEntries())
+	err := os.WriteFile(fname, data, 0600)
+	assert.Nil(t, err)
+	records, err := ParseCric(fname, false)
+	assert.Nil(t, err)
+	expect, _ := getCricRecords(testCricEntries(), false)
+	assert.Equal(t, CricFingerprint(records), CricFingerprint(expect))
+	records, err = ParseCricByKey(fname, "login", false)
+	assert.Nil(t, err)
+	assert.Equal(t, records["user2"].ID, int64(2))
+}
+
+// BenchmarkParseCricStream function
+func BenchmarkParseCricStream(b *testing.B) {
+	data, _ := json.Marshal(testCricStreamEntries(10000))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseCricStream(bytes.NewReader(data), CricKeyLogin, false)
+	}
+}
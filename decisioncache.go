@@ -0,0 +1,111 @@
+package cmsauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDecisionTTL defines default time authorization decision is cached
+var DefaultDecisionTTL = 30 * time.Second
+
+// decisionCacheEntry represents cached authorization decision
+type decisionCacheEntry struct {
+	key    [sha256.Size]byte
+	status bool
+	expire time.Time
+}
+
+// DecisionCache provides bounded LRU cache of authorization decisions keyed by
+// user login, request path, method and set of user roles. It allows hot
+// endpoints to skip role matching and policy evaluation, see
+// CMSAuth.SetDecisionCache.
+type DecisionCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List
+}
+
+// NewDecisionCache creates new DecisionCache of given size and TTL, if TTL
+// is not positive the DefaultDecisionTTL is used
+func NewDecisionCache(size int, ttl time.Duration) *DecisionCache {
+	if ttl <= 0 {
+		ttl = DefaultDecisionTTL
+	}
+	return &DecisionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Invalidate removes all cached decisions, e.g. after CRIC records refresh,
+// see CricManager.OnRefresh
+func (c *DecisionCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[[sha256.Size]byte]*list.Element)
+	c.order.Init()
+}
+
+// Len returns number of cached decisions
+func (c *DecisionCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+// helper function to return cached decision for given key
+func (c *DecisionCache) get(key [sha256.Size]byte) (bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	entry := elem.Value.(*decisionCacheEntry)
+	if time.Now().After(entry.expire) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return false, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.status, true
+}
+
+// helper function to add decision to the cache, the least recently used
+// entry is evicted if cache is full
+func (c *DecisionCache) add(key [sha256.Size]byte, status bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	entry := &decisionCacheEntry{key: key, status: status, expire: time.Now().Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*decisionCacheEntry).key)
+	}
+}
+
+// helper function to build decision cache key from user login, request path,
+// method and user roles
+func decisionKey(user User, path, method string) [sha256.Size]byte {
+	var roles []string
+	for role, values := range user.Roles {
+		vals := append([]string{}, values...)
+		sort.Strings(vals)
+		roles = append(roles, role+"="+strings.Join(vals, " "))
+	}
+	sort.Strings(roles)
+	val := strings.Join([]string{user.Login, path, strings.ToUpper(method), strings.Join(roles, ";")}, "\n")
+	return sha256.Sum256([]byte(val))
+}
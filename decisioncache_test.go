@@ -0,0 +1,65 @@
+package cmsauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDecisionCache function
+func TestDecisionCache(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	authorizer := &mockAuthorizer{grant: true}
+	cmsAuth.SetAuthorizer(authorizer)
+	cache := NewDecisionCache(2, time.Minute)
+	cmsAuth.SetDecisionCache(cache)
+
+	// helper function to create signed request
+	request := func(login, path, roles string) *http.Request {
+		r, _ := http.NewRequest("GET", path, nil)
+		r.Header.Set("cms-auth-status", "ok")
+		r.Header.Set("cms-authn-login", login)
+		r.Header.Set("cms-authz-user", roles)
+		r.Header.Set("cms-request-uri", path)
+		hmac, _ := cmsAuth.GetHmac(r, false)
+		r.Header.Set("cms-authn-hmac", hmac)
+		return r
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(request("user", "/path", "group:dbs")), true)
+	}
+	assert.Equal(t, authorizer.calls, 1)
+	assert.Equal(t, cache.Len(), 1)
+
+	// different roles or path are separate decisions
+	cmsAuth.CheckAuthnAuthzRequest(request("user", "/path", "group:dbs site:T1"))
+	cmsAuth.CheckAuthnAuthzRequest(request("user", "/other", "group:dbs"))
+	assert.Equal(t, authorizer.calls, 3)
+	assert.Equal(t, cache.Len(), 2)
+
+	// cached decisions are not used for requests failing authentication
+	r := request("user", "/other", "group:dbs")
+	r.Header.Set("cms-authn-hmac", "invalid")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(r), false)
+
+	// CRIC refresh invalidates cached decisions
+	server, _ := testCricServer(t)
+	mgr := NewCricManager(server.URL, time.Hour, false)
+	mgr.OnRefresh(cache.Invalidate)
+	assert.Nil(t, mgr.Refresh())
+	assert.Equal(t, cache.Len(), 0)
+	authorizer.grant = false
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzRequest(request("user", "/other", "group:dbs")), false)
+	assert.Equal(t, authorizer.calls, 4)
+
+	// expired decisions are evaluated again
+	cache = NewDecisionCache(10, time.Nanosecond)
+	cmsAuth.SetDecisionCache(cache)
+	cmsAuth.CheckAuthnAuthzRequest(request("user", "/path", "group:dbs"))
+	time.Sleep(time.Millisecond)
+	cmsAuth.CheckAuthnAuthzRequest(request("user", "/path", "group:dbs"))
+	assert.Equal(t, authorizer.calls, 6)
+}
@@ -0,0 +1,291 @@
+package cmsauth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DNComponent represents single attribute of the DN, e.g. CN=First Last
+type DNComponent struct {
+	Type  string `json:"Type"`  // attribute type, e.g. CN, OU, DC
+	Value string `json:"Value"` // attribute value
+}
+
+// String returns string representation of DNComponent
+func (c DNComponent) String() string {
+	return fmt.Sprintf("%s=%s", c.Type, c.Value)
+}
+
+// ParseDN parses given slash (/DC=ch/CN=user) or comma (CN=user,DC=ch)
+// delimited DN into ordered list of its components
+func ParseDN(dn string) ([]DNComponent, error) {
+	dn = strings.TrimSpace(dn)
+	if dn == "" {
+		return nil, errors.New("empty DN")
+	}
+	var parts []string
+	if strings.HasPrefix(dn, "/") {
+		parts = splitSlashDN(dn)
+	} else {
+		parts = splitCommaDN(dn)
+	}
+	var comps []DNComponent
+	for _, p := range parts {
+		idx := strings.Index(p, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid DN component '%s' in DN %s", p, dn)
+		}
+		comps = append(comps, DNComponent{
+			Type:  strings.TrimSpace(p[:idx]),
+			Value: strings.TrimSpace(p[idx+1:]),
+		})
+	}
+	return comps, nil
+}
+
+// helper function to split slash delimited DN into its parts, the parts
+// without attribute type are joined to previous one, e.g. CN=host/name.cern.ch,
+// and escaped slashes, e.g. CN=a\/b, are unescaped and kept within the value
+func splitSlashDN(dn string) []string {
+	var parts []string
+	var part []byte
+	var hasType bool
+	flush := func() {
+		if len(part) == 0 {
+			return
+		}
+		if !hasType && len(parts) > 0 {
+			parts[len(parts)-1] += "/" + string(part)
+		} else {
+			parts = append(parts, string(part))
+		}
+		part = nil
+		hasType = false
+	}
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\' && i+1 < len(dn):
+			part = append(part, dn[i+1])
+			i++
+		case c == '/':
+			flush()
+		default:
+			if c == '=' {
+				hasType = true
+			}
+			part = append(part, c)
+		}
+	}
+	flush()
+	return parts
+}
+
+// helper function to split comma delimited DN into its parts, escaped
+// characters, e.g. \, or \2C, are unescaped and kept within the value
+func splitCommaDN(dn string) []string {
+	var parts []string
+	var part []byte
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\' && i+2 < len(dn) && isHex(dn[i+1]) && isHex(dn[i+2]):
+			b, _ := strconv.ParseUint(dn[i+1:i+3], 16, 8)
+			part = append(part, byte(b))
+			i += 2
+		case c == '\\' && i+1 < len(dn):
+			part = append(part, dn[i+1])
+			i++
+		case c == ',':
+			parts = append(parts, string(part))
+			part = nil
+		default:
+			part = append(part, c)
+		}
+	}
+	parts = append(parts, string(part))
+	return parts
+}
+
+// helper function to check if given character is hex digit
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// helper function to escape DN attribute value according to RFC 2253
+func escapeRFC2253(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case strings.IndexByte(",+\"\\<>;", c) >= 0:
+			b.WriteByte('\\')
+		case c == '#' && i == 0:
+			b.WriteByte('\\')
+		case c == ' ' && (i == 0 || i == len(v)-1):
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// DNToRFC2253 converts DN from OpenSSL slash form, e.g. /DC=ch/CN=user, into
+// RFC 2253 comma form, e.g. CN=user,DC=ch. It returns empty string if DN can
+// not be parsed.
+func DNToRFC2253(dn string) string {
+	comps, err := ParseDN(dn)
+	if err != nil {
+		return ""
+	}
+	var parts []string
+	for i := len(comps) - 1; i >= 0; i-- {
+		parts = append(parts, fmt.Sprintf("%s=%s", comps[i].Type, escapeRFC2253(comps[i].Value)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// DNFromRFC2253 converts DN from RFC 2253 comma form, e.g. CN=user,DC=ch, into
+// OpenSSL slash form, e.g. /DC=ch/CN=user. It returns empty string if DN can
+// not be parsed.
+func DNFromRFC2253(dn string) string {
+	comps, err := ParseDN(dn)
+	if err != nil {
+		return ""
+	}
+	var out string
+	for i := len(comps) - 1; i >= 0; i-- {
+		out = fmt.Sprintf("%s/%s", out, comps[i])
+	}
+	return out
+}
+
+// ParseDNAttributes parses given DN in slash or RFC 2253 comma form and
+// returns values of its attributes, e.g. CN, OU, DC or O, in the order of
+// slash form
+func ParseDNAttributes(dn string) (map[string][]string, error) {
+	comps, err := slashOrderDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]string)
+	for _, c := range comps {
+		attrs[c.Type] = append(attrs[c.Type], c.Value)
+	}
+	return attrs, nil
+}
+
+// helper function to parse DN and return its components in the order of
+// slash form, i.e. RFC 2253 components are reversed
+func slashOrderDN(dn string) ([]DNComponent, error) {
+	comps, err := ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(strings.TrimSpace(dn), "/") {
+		for i, j := 0, len(comps)-1; i < j; i, j = i+1, j-1 {
+			comps[i], comps[j] = comps[j], comps[i]
+		}
+	}
+	return comps, nil
+}
+
+// EqualDN checks if given DNs, each either in slash or RFC 2253 comma form,
+// have the same components in the same order. Attribute types are compared
+// case-insensitively.
+func EqualDN(dn1, dn2 string) bool {
+	comps1, err := slashOrderDN(dn1)
+	if err != nil {
+		return false
+	}
+	comps2, err := slashOrderDN(dn2)
+	if err != nil || len(comps1) != len(comps2) {
+		return false
+	}
+	for i := range comps1 {
+		if !strings.EqualFold(comps1[i].Type, comps2[i].Type) || comps1[i].Value != comps2[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to escape slashes of DN attribute value in slash form
+func escapeSlashDN(v string) string {
+	if strings.IndexAny(v, "/\\") < 0 {
+		return v
+	}
+	return strings.NewReplacer("\\", "\\\\", "/", "\\/").Replace(v)
+}
+
+// NormalizeDN returns given DN in OpenSSL slash form used by CRIC records and
+// cms-authn-dn header, i.e. DN in RFC 2253 comma form, e.g. provided by modern
+// TLS stacks, is converted into slash form. DN which can not be parsed is
+// returned as is.
+func NormalizeDN(dn string) string {
+	dn = strings.TrimSpace(dn)
+	if dn == "" || strings.HasPrefix(dn, "/") {
+		return dn
+	}
+	if d := DNFromRFC2253(dn); d != "" {
+		return d
+	}
+	return dn
+}
+
+// DNAttributes returns all values of given attribute type in the DN
+func DNAttributes(dn, attr string) []string {
+	var vals []string
+	comps, err := ParseDN(dn)
+	if err != nil {
+		return vals
+	}
+	for _, c := range comps {
+		if strings.EqualFold(c.Type, attr) {
+			vals = append(vals, c.Value)
+		}
+	}
+	return vals
+}
+
+// CN returns common name of the DN, i.e. value of its last CN attribute
+func CN(dn string) string {
+	vals := DNAttributes(dn, "CN")
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[len(vals)-1]
+}
+
+// dnAttributeTypes maps OIDs of DN attributes to their short names
+var dnAttributeTypes = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "STREET",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.17":                   "postalCode",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// CertificateDN returns subject DN of given certificate in OpenSSL slash form,
+// e.g. /DC=ch/DC=cern/OU=Users/CN=user
+func CertificateDN(cert *x509.Certificate) string {
+	var out string
+	for _, atv := range cert.Subject.Names {
+		attr, ok := dnAttributeTypes[atv.Type.String()]
+		if !ok {
+			attr = atv.Type.String()
+		}
+		out = fmt.Sprintf("%s/%s=%v", out, attr, atv.Value)
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package cmsauth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseDN function
+func TestParseDN(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	comps, err := ParseDN(dn)
+	assert.Nil(t, err)
+	expect := []DNComponent{
+		{Type: "DC", Value: "ch"},
+		{Type: "DC", Value: "cern"},
+		{Type: "OU", Value: "Organic Units"},
+		{Type: "OU", Value: "Users"},
+		{Type: "CN", Value: "user"},
+		{Type: "CN", Value: "123"},
+		{Type: "CN", Value: "First Last"},
+	}
+	assert.Equal(t, comps, expect)
+	assert.Equal(t, CN(dn), "First Last")
+	assert.Equal(t, DNAttributes(dn, "ou"), []string{"Organic Units", "Users"})
+
+	comps, err = ParseDN("CN=First Last,OU=Users,O=Org\\, Inc,DC=ch")
+	assert.Nil(t, err)
+	assert.Equal(t, len(comps), 4)
+	assert.Equal(t, comps[2], DNComponent{Type: "O", Value: "Org, Inc"})
+
+	comps, err = ParseDN("/DC=ch/DC=cern/OU=computers/CN=host/vocms.cern.ch")
+	assert.Nil(t, err)
+	assert.Equal(t, comps[3].Value, "host/vocms.cern.ch")
+
+	_, err = ParseDN("")
+	assert.NotNil(t, err)
+	_, err = ParseDN("/DC=ch/=value")
+	assert.NotNil(t, err)
+	assert.Equal(t, CN("bad"), "")
+}
+
+// TestDNRFC2253 function
+func TestDNRFC2253(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	rdn := "CN=First Last,CN=123,CN=user,OU=Users,OU=Organic Units,DC=cern,DC=ch"
+	assert.Equal(t, DNToRFC2253(dn), rdn)
+	assert.Equal(t, DNFromRFC2253(rdn), dn)
+	assert.Equal(t, DNFromRFC2253(DNToRFC2253(dn)), dn)
+
+	// values with special characters are escaped
+	dn = "/DC=org/O=Org, Inc/CN=host/vocms.cern.ch"
+	rdn = DNToRFC2253(dn)
+	assert.Equal(t, rdn, "CN=host/vocms.cern.ch,O=Org\\, Inc,DC=org")
+	assert.Equal(t, DNFromRFC2253(rdn), dn)
+	assert.Equal(t, DNFromRFC2253("CN=First Last,O=Org\\2C Inc,DC=org"), "/DC=org/O=Org, Inc/CN=First Last")
+
+	assert.Equal(t, DNToRFC2253(""), "")
+	assert.Equal(t, DNFromRFC2253("bad"), "")
+}
+
+// TestParseDNAttributes function
+func TestParseDNAttributes(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=123/CN=First Last"
+	expect := map[string][]string{
+		"DC": {"ch", "cern"},
+		"OU": {"Organic Units", "Users"},
+		"CN": {"user", "123", "First Last"},
+	}
+	attrs, err := ParseDNAttributes(dn)
+	assert.Nil(t, err)
+	assert.Equal(t, attrs, expect)
+	attrs, err = ParseDNAttributes(DNToRFC2253(dn))
+	assert.Nil(t, err)
+	assert.Equal(t, attrs, expect)
+
+	// escaped slash is kept within the value
+	attrs, err = ParseDNAttributes("/DC=ch/O=A\\/B/CN=user")
+	assert.Nil(t, err)
+	assert.Equal(t, attrs["O"], []string{"A/B"})
+
+	_, err = ParseDNAttributes("")
+	assert.NotNil(t, err)
+}
+
+// TestEqualDN function
+func TestEqualDN(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Users/CN=user/CN=First Last"
+	assert.True(t, EqualDN(dn, dn))
+	assert.True(t, EqualDN(dn, "CN=First Last,CN=user,OU=Users,DC=cern,DC=ch"))
+	assert.True(t, EqualDN(dn, "/dc=ch/dc=cern/ou=Users/cn=user/cn=First Last"))
+	assert.False(t, EqualDN(dn, "/DC=ch/DC=cern/OU=Users/CN=First Last/CN=user"))
+	assert.False(t, EqualDN(dn, "/DC=ch/DC=cern/OU=Users/CN=user"))
+	assert.False(t, EqualDN(dn, ""))
+}
+
+// TestNormalizeDN function
+func TestNormalizeDN(t *testing.T) {
+	dn := "/DC=ch/DC=cern/OU=Users/CN=user/CN=First Last"
+	assert.Equal(t, NormalizeDN(dn), dn)
+	assert.Equal(t, NormalizeDN(" CN=First Last,CN=user,OU=Users,DC=cern,DC=ch "), dn)
+	assert.Equal(t, NormalizeDN("bad"), "bad")
+	assert.Equal(t, NormalizeDN(""), "")
+}
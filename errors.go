@@ -0,0 +1,78 @@
+package cmsauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// AuthError represents authentication or authorization failure. The Reason
+// is the same reason code which is used in metrics and audit log, the Status
+// is HTTP status code which services may return to the client.
+type AuthError struct {
+	Reason  string // reason code, e.g. hmac_mismatch
+	Status  int    // HTTP status code, e.g. 401
+	Message string // user facing message
+}
+
+// Error implements error interface
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// list of authentication and authorization errors, use errors.Is to check
+// for specific failure and ErrorStatus to obtain its HTTP status code
+var (
+	ErrNoAuthHeaders       = &AuthError{"missing_status", http.StatusUnauthorized, "request does not provide CMS authentication headers"}
+	ErrNotAuthenticated    = &AuthError{"not_authenticated", http.StatusUnauthorized, "request is not authenticated"}
+	ErrStrictMode          = &AuthError{"strict_mode", http.StatusUnauthorized, "unauthenticated requests are not allowed"}
+	ErrInjectedHeaders     = &AuthError{"injected_headers", http.StatusUnauthorized, "unauthenticated request provides CMS authentication headers"}
+	ErrRequiredHeaders     = &AuthError{"required_headers", http.StatusUnauthorized, "request does not provide required CMS headers"}
+	ErrInvalidTimestamp    = &AuthError{"timestamp", http.StatusUnauthorized, "CMS headers timestamp is outside of allowed skew"}
+	ErrUnknownRealm        = &AuthError{"unknown_realm", http.StatusUnauthorized, "CMS headers are signed by unknown realm"}
+	ErrHmacMismatch        = &AuthError{"hmac_mismatch", http.StatusUnauthorized, "invalid HMAC signature of CMS headers"}
+	ErrExpiredToken        = &AuthError{"expired", http.StatusUnauthorized, "user authentication is expired"}
+	ErrUserNotInCric       = &AuthError{"user_not_in_cric", http.StatusForbidden, "user is not found in CRIC"}
+	ErrRoleDenied          = &AuthError{"authorization", http.StatusForbidden, "user is not authorized"}
+	ErrIPDenied            = &AuthError{"ip", http.StatusForbidden, "client address is not allowed"}
+	ErrRateLimited         = &AuthError{"rate_limit", http.StatusTooManyRequests, "too many requests"}
+	ErrImpersonationDenied = &AuthError{"impersonation", http.StatusForbidden, "impersonation is not allowed"}
+	ErrTokenRevoked        = &AuthError{"token_revoked", http.StatusUnauthorized, "token is revoked"}
+	errUnknownAuthnFailure = &AuthError{"unknown", http.StatusUnauthorized, "authentication failed"}
+	authErrors             = []*AuthError{
+		ErrNoAuthHeaders, ErrNotAuthenticated, ErrStrictMode, ErrInjectedHeaders,
+		ErrRequiredHeaders, ErrInvalidTimestamp, ErrUnknownRealm, ErrHmacMismatch,
+		ErrExpiredToken, ErrUserNotInCric, ErrRoleDenied, ErrIPDenied, ErrRateLimited,
+		ErrImpersonationDenied, ErrTokenRevoked,
+	}
+)
+
+// ReasonError returns AuthError of given reason code, e.g. reason returned by
+// VerifyHeaders or recorded in audit log
+func ReasonError(reason string) error {
+	for _, err := range authErrors {
+		if err.Reason == reason {
+			return err
+		}
+	}
+	return errUnknownAuthnFailure
+}
+
+// ErrorStatus returns HTTP status code of given error, errors which are not
+// AuthError are reported as internal server error
+func ErrorStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// helper function to add details to given error, the returned error matches
+// it with errors.Is
+func authErrorf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,71 @@
+package cmsauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReasonError function
+func TestReasonError(t *testing.T) {
+	for _, err := range authErrors {
+		assert.Equal(t, ReasonError(err.Reason), error(err))
+	}
+	assert.Equal(t, ReasonError("bad"), error(errUnknownAuthnFailure))
+	assert.Equal(t, ErrorStatus(nil), http.StatusOK)
+	assert.Equal(t, ErrorStatus(ErrRoleDenied), http.StatusForbidden)
+	assert.Equal(t, ErrorStatus(authErrorf(ErrHmacMismatch, "details")), http.StatusUnauthorized)
+	assert.Equal(t, ErrorStatus(fmt.Errorf("wrapped: %w", ErrRateLimited)), http.StatusTooManyRequests)
+	assert.Equal(t, ErrorStatus(errors.New("other")), http.StatusInternalServerError)
+	assert.True(t, errors.Is(authErrorf(ErrHmacMismatch, "details"), ErrHmacMismatch))
+}
+
+// TestVerify function
+func TestVerify(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	dn := "/DC=ch/DC=cern/OU=Users/CN=user"
+	records := CricRecords{GetSortedDN(dn): CricEntry{DN: dn, Login: "user", Roles: map[string][]string{"user": {"group:dbs"}}}}
+	newRequest := func(login string, exp int64) *http.Request {
+		r, _ := http.NewRequest("GET", "/path", nil)
+		userData := map[string]interface{}{"name": "User", "cern_upn": login, "dn": dn, "exp": fmt.Sprintf("%d", exp)}
+		cmsAuth.SetCMSHeaders(r, userData, records, false)
+		return r
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	info, err := cmsAuth.Verify(newRequest("user", exp))
+	assert.Nil(t, err)
+	assert.Equal(t, info.Login, "user")
+	assert.Equal(t, info.Roles["user"], []string{"group:dbs"})
+
+	r := newRequest("user", exp)
+	r.Header.Set("cms-authz-admin", "group:dbs")
+	_, err = cmsAuth.Verify(r)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+	assert.Equal(t, ErrorStatus(err), http.StatusUnauthorized)
+
+	_, err = cmsAuth.Verify(newRequest("user", time.Now().Add(-time.Hour).Unix()))
+	assert.ErrorIs(t, err, ErrExpiredToken)
+	_, err = cmsAuth.Verify(newRequest("", exp))
+	assert.ErrorIs(t, err, ErrUserNotInCric)
+
+	r, _ = http.NewRequest("GET", "/path", nil)
+	_, err = cmsAuth.Verify(r)
+	assert.ErrorIs(t, err, ErrNoAuthHeaders)
+	r.Header.Set("cms-auth-status", "NONE")
+	_, err = cmsAuth.Verify(r)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+
+	cmsAuth.SetAuthorizer(&mockAuthorizer{grant: false})
+	_, err = cmsAuth.Verify(newRequest("user", exp))
+	assert.ErrorIs(t, err, ErrRoleDenied)
+	assert.Equal(t, ErrorStatus(err), http.StatusForbidden)
+
+	_, err = cmsAuth.AuthorizeDN(records, "/DC=ch/CN=unknown", "user", "")
+	assert.ErrorIs(t, err, ErrUserNotInCric)
+	err = cmsAuth.VerifyHeaders(http.Header{})
+	assert.ErrorIs(t, err, ErrNoAuthHeaders)
+}
@@ -0,0 +1,159 @@
+package cmsauth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultHealthTimeout defines default timeout of token issuer health check
+var DefaultHealthTimeout = 5 * time.Second
+
+// HealthChecks defines components of auth subsystem checked by HealthHandler,
+// nil components are not checked
+type HealthChecks struct {
+	Auth   *CMSAuth      // checks presence of HMAC key
+	Cric   *CricManager  // checks age and size of CRIC snapshot
+	Tokens *TokenManager // checks reachability of token issuer JWKS URL
+
+	// Certs returns TLS certificates whose expiration is checked, e.g. TlsCerts
+	Certs func() ([]tls.Certificate, error)
+	// CertMinValidity defines minimum remaining validity of TLS certificates
+	CertMinValidity time.Duration
+	// Timeout defines timeout of token issuer check, default is DefaultHealthTimeout
+	Timeout time.Duration
+}
+
+// HealthCheck represents result of single health check
+type HealthCheck struct {
+	Status  string                 `json:"status"`            // ok or fail
+	Error   string                 `json:"error,omitempty"`   // reason of failure
+	Details map[string]interface{} `json:"details,omitempty"` // check details, e.g. CRIC records count
+}
+
+// HealthStatus represents health of auth subsystem
+type HealthStatus struct {
+	Status string                 `json:"status"` // ok if all checks pass, fail otherwise
+	Checks map[string]HealthCheck `json:"checks"` // results of individual checks
+}
+
+// Check performs all configured health checks
+func (h HealthChecks) Check(ctx context.Context) HealthStatus {
+	status := HealthStatus{Status: "ok", Checks: make(map[string]HealthCheck)}
+	add := func(name string, details map[string]interface{}, err error) {
+		check := HealthCheck{Status: "ok", Details: details}
+		if err != nil {
+			check.Status = "fail"
+			check.Error = err.Error()
+			status.Status = "fail"
+		}
+		status.Checks[name] = check
+	}
+	if h.Auth != nil {
+		details, err := h.checkHmac()
+		add("hmac", details, err)
+	}
+	if h.Cric != nil {
+		details, err := h.checkCric()
+		add("cric", details, err)
+	}
+	if h.Tokens != nil {
+		details, err := h.checkTokens(ctx)
+		add("token_issuer", details, err)
+	}
+	if h.Certs != nil {
+		details, err := h.checkCerts()
+		add("tls_certs", details, err)
+	}
+	return status
+}
+
+// helper function to check presence of HMAC key
+func (h HealthChecks) checkHmac() (map[string]interface{}, error) {
+	details := map[string]interface{}{"keys": len(h.Auth.verificationKeys())}
+	if len(h.Auth.key()) == 0 {
+		return details, errors.New("HMAC key is not loaded")
+	}
+	return details, nil
+}
+
+// helper function to check CRIC snapshot
+func (h HealthChecks) checkCric() (map[string]interface{}, error) {
+	details := map[string]interface{}{
+		"records":     len(h.Cric.Records()),
+		"age_seconds": int64(h.Cric.Age().Seconds()),
+	}
+	if updated := h.Cric.Updated(); !updated.IsZero() {
+		details["updated"] = updated.Unix()
+	}
+	return details, h.Cric.Check()
+}
+
+// helper function to check reachability of token issuer JWKS URL
+func (h HealthChecks) checkTokens(ctx context.Context) (map[string]interface{}, error) {
+	details := map[string]interface{}{"url": h.Tokens.JWKSURL}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	client, err := NewHttpClient()
+	if err != nil {
+		return details, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", h.Tokens.JWKSURL, nil)
+	if err != nil {
+		return details, err
+	}
+	req.Header.Set("Accept", "application/json")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return details, err
+	}
+	resp.Body.Close()
+	details["latency_ms"] = time.Since(start).Milliseconds()
+	if resp.StatusCode != http.StatusOK {
+		return details, fmt.Errorf("token issuer %s returns status %s", h.Tokens.JWKSURL, resp.Status)
+	}
+	return details, nil
+}
+
+// helper function to check expiration of TLS certificates
+func (h HealthChecks) checkCerts() (map[string]interface{}, error) {
+	certs, err := h.Certs()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no TLS certificates found")
+	}
+	expire := CertExpire(certs)
+	if expire.IsZero() {
+		return nil, errors.New("unable to parse TLS certificates")
+	}
+	details := map[string]interface{}{"expire": expire.Unix()}
+	if time.Until(expire) < h.CertMinValidity {
+		return details, fmt.Errorf("TLS certificate expires on %v", expire)
+	}
+	return details, nil
+}
+
+// HealthHandler returns HTTP handler which reports health of auth subsystem
+// as JSON, it can be used as readiness probe. The handler responds with 200
+// status code when all checks pass and 503 otherwise.
+func HealthHandler(h HealthChecks) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := h.Check(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if status.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -0,0 +1,76 @@
+package cmsauth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHealthHandler function
+func TestHealthHandler(t *testing.T) {
+	cricServer, _ := testCricServer(t)
+	mgr := NewCricManager(cricServer.URL, time.Minute, false)
+	jwksAvailable := true
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !jwksAvailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer jwksServer.Close()
+	cert := testCertificate(t, pkix.Name{CommonName: "service"})
+	checks := HealthChecks{
+		Auth:   initCMSAuth(t),
+		Cric:   mgr,
+		Tokens: NewTokenManager(jwksServer.URL, "", ""),
+		Certs: func() ([]tls.Certificate, error) {
+			return []tls.Certificate{{Certificate: [][]byte{cert.Raw}}}, nil
+		},
+	}
+	check := func(h HealthChecks) (int, HealthStatus) {
+		w := httptest.NewRecorder()
+		HealthHandler(h).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		var status HealthStatus
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return w.Code, status
+	}
+
+	// CRIC records are not loaded yet
+	code, status := check(checks)
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, status.Checks["cric"].Status, "fail")
+	assert.Equal(t, status.Checks["hmac"].Status, "ok")
+	assert.Equal(t, status.Checks["token_issuer"].Status, "ok")
+	assert.Equal(t, status.Checks["tls_certs"].Status, "ok")
+
+	assert.Nil(t, mgr.Refresh())
+	code, status = check(checks)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, status.Status, "ok")
+	assert.Equal(t, status.Checks["cric"].Details["records"], float64(2))
+
+	// failures of individual components
+	jwksAvailable = false
+	checks.CertMinValidity = 2 * time.Hour
+	checks.Auth = &CMSAuth{}
+	code, status = check(checks)
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, status.Status, "fail")
+	for _, name := range []string{"hmac", "token_issuer", "tls_certs"} {
+		assert.Equal(t, status.Checks[name].Status, "fail", name)
+		assert.NotEqual(t, status.Checks[name].Error, "", name)
+	}
+
+	// only configured components are checked
+	status = HealthChecks{}.Check(context.Background())
+	assert.Equal(t, status.Status, "ok")
+	assert.Equal(t, len(status.Checks), 0)
+}
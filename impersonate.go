@@ -0,0 +1,161 @@
+package cmsauth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImpersonateHeader defines HTTP header used by operators to act as another
+// user, its value is login of impersonated user
+var ImpersonateHeader = "X-Cms-Impersonate"
+
+// ImpersonationPolicy defines which operators may impersonate which users
+type ImpersonationPolicy struct {
+	Role    string   // role required to impersonate other users, e.g. impersonator
+	Group   string   // optional group of the role, e.g. group:cmsweb, empty matches any
+	Site    string   // optional site of the role, e.g. site:T1_US_FNAL, empty matches any
+	Targets []string // logins which can be impersonated, empty list allows any user
+}
+
+// helper function to check if given login can be impersonated
+func (p ImpersonationPolicy) allowedTarget(login string) bool {
+	return len(p.Targets) == 0 || contains(p.Targets, login)
+}
+
+// helper function to build exact authz expression of policy role, i.e.
+// role:X AND group:Y AND site:Z
+func (p ImpersonationPolicy) authzExpr() *AuthzExpr {
+	expr := &AuthzExpr{kind: "role", value: p.Role}
+	for _, term := range []struct{ kind, value string }{{"group", p.Group}, {"site", p.Site}} {
+		if term.value == "" {
+			continue
+		}
+		value := strings.TrimPrefix(term.value, term.kind+":")
+		expr = &AuthzExpr{op: "AND", args: []*AuthzExpr{expr, {kind: term.kind, value: value}}}
+	}
+	return expr
+}
+
+// Impersonate allows operator to act as another user provided in
+// ImpersonateHeader of given HTTP request. The operator's own cms-authn/cms-authz
+// headers are verified and checked against given policy, then cms-* headers
+// are replaced by headers of impersonated user found in given CRIC records
+// and the operator login is kept in signed cms-authn-impersonator header. The
+// impersonation is recorded in audit log along with both identities. It
+// returns false if request does not ask for impersonation.
+func (a *CMSAuth) Impersonate(r *http.Request, policy ImpersonationPolicy, cricRecords CricRecords) (bool, error) {
+	target := r.Header.Get(ImpersonateHeader)
+	if target == "" {
+		return false, nil
+	}
+	r.Header.Del(ImpersonateHeader)
+	operator := a.UserFromHeader(r.Header)
+	err := a.checkImpersonation(r, policy, operator, target)
+	if err != nil {
+		recordAuthFailure(ErrImpersonationDenied.Reason)
+		a.auditImpersonation(r, operator, target, "", false, err.Error())
+		return true, err
+	}
+	rec, ok := findCricEntryByLogin(cricRecords, target)
+	if !ok {
+		err = authErrorf(ErrUserNotInCric, "impersonated user %s is not found", target)
+		recordAuthFailure(ErrImpersonationDenied.Reason)
+		a.auditImpersonation(r, operator, target, "", false, err.Error())
+		return true, err
+	}
+	expire := time.Now().Add(DefaultSessionTTL).Unix()
+	if exp, err := strconv.ParseInt(HeaderValue(r.Header, a.HeaderKey("auth-expire")), 10, 64); err == nil {
+		expire = exp
+	}
+	s := &Session{
+		Login:  rec.Login,
+		Name:   rec.Name,
+		DN:     rec.DN,
+		Roles:  rec.Roles,
+		Method: "Impersonation",
+		Expire: expire,
+	}
+	a.SetSessionHeaders(r, s, false)
+	r.Header.Set(a.HeaderKey("authn-impersonator"), operator.Login)
+	if hmac, err := a.GetHmac(r, false); err == nil {
+		r.Header.Set(a.HeaderKey("authn-hmac"), hmac)
+	}
+	a.auditImpersonation(r, operator, target, rec.DN, true, "impersonation")
+	return true, nil
+}
+
+// helper function to check operator's authentication and impersonation policy
+func (a *CMSAuth) checkImpersonation(r *http.Request, policy ImpersonationPolicy, operator User, target string) error {
+	if a.afile != "" {
+		// verify a copy since verifySignature adds alias headers of operator
+		if status, reason := a.verifySignature(r.Header.Clone(), r.Method, r.Host); !status {
+			return authErrorf(ReasonError(reason), "operator authentication failed")
+		}
+	}
+	if operator.Login == "" {
+		return authErrorf(ErrNotAuthenticated, "operator is not authenticated")
+	}
+	if operator.Login == target {
+		return authErrorf(ErrImpersonationDenied, "operator can not impersonate itself")
+	}
+	if policy.Role == "" || !a.MatchAuthzExpr(r.Header, policy.authzExpr()) {
+		return authErrorf(ErrImpersonationDenied, "operator %s is not allowed to impersonate users", operator.Login)
+	}
+	if !policy.allowedTarget(target) {
+		return authErrorf(ErrImpersonationDenied, "operator %s is not allowed to impersonate %s", operator.Login, target)
+	}
+	return nil
+}
+
+// helper function to record impersonation decision in audit log
+func (a *CMSAuth) auditImpersonation(r *http.Request, operator User, target, dn string, status bool, reason string) {
+	if a.auditLogger == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:         time.Now(),
+		Login:        target,
+		DN:           dn,
+		Path:         r.URL.Path,
+		Result:       status,
+		Reason:       reason,
+		Impersonator: operator.Login,
+	}
+	if err := a.auditLogger.Log(event); err != nil {
+		GetLogger().Errorf("CMSAuth, unable to write audit event, error %v", err)
+	}
+}
+
+// ImpersonationMiddleware returns middleware which applies Impersonate to
+// requests with ImpersonateHeader using CRIC records provided by given
+// function, e.g. CricManager.Records. The requests which fail impersonation
+// are rejected with status code of the failure, e.g. 403 for policy violations. It should be chained before CMSAuth
+// Middleware which verifies re-signed headers of impersonated user.
+func (a *CMSAuth) ImpersonationMiddleware(policy ImpersonationPolicy, records func() CricRecords) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := a.Impersonate(r, policy, records()); err != nil {
+				code := ErrorStatus(err)
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// helper function to find CRIC entry by user login in records keyed by any
+// CRIC key
+func findCricEntryByLogin(records CricRecords, login string) (CricEntry, bool) {
+	if rec, ok := records[login]; ok && rec.Login == login {
+		return rec, true
+	}
+	for _, rec := range records {
+		if rec.Login == login {
+			return rec, true
+		}
+	}
+	return CricEntry{}, false
+}
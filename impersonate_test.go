@@ -0,0 +1,144 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memAuditLogger keeps audit events in memory
+type memAuditLogger struct {
+	events []AuditEvent
+}
+
+// Log implements AuditLogger interface
+func (l *memAuditLogger) Log(event AuditEvent) error {
+	l.events = append(l.events, event)
+	return nil
+}
+
+// TestImpersonate function
+func TestImpersonate(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	logger := &memAuditLogger{}
+	cmsAuth.SetAuditLogger(logger)
+	rmap, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	records := CricRecords(rmap)
+	operator := CricEntry{
+		DN:    "/DC=ch/DC=cern/OU=Users/CN=operator",
+		Login: "operator",
+		Name:  "Operator",
+		Roles: map[string][]string{"impersonator": {"group:cmsweb"}},
+	}
+	policy := ImpersonationPolicy{Role: "impersonator", Group: "group:cmsweb"}
+	newRequest := func(target string) *http.Request {
+		r, _ := http.NewRequest("GET", "/path", nil)
+		userData := map[string]interface{}{"name": operator.Name, "cern_upn": operator.Login, "dn": operator.DN}
+		cmsAuth.SetCMSHeaders(r, userData, CricRecords{GetSortedDN(operator.DN): operator}, false)
+		if target != "" {
+			r.Header.Set(ImpersonateHeader, target)
+		}
+		return r
+	}
+
+	// request without impersonation header is not changed
+	r := newRequest("")
+	ok, err := cmsAuth.Impersonate(r, policy, records)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "operator")
+
+	r = newRequest("user2")
+	ok, err = cmsAuth.Impersonate(r, policy, records)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Header.Get(ImpersonateHeader), "")
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "user2")
+	assert.Equal(t, r.Header.Get("cms-authn-impersonator"), "operator")
+	assert.Equal(t, r.Header.Get("cms-authz-admin"), "group:das site:T1_US_FNAL")
+	assert.Equal(t, r.Header.Get("cms-authz-impersonator"), "")
+	// alias headers of operator should not be added to request
+	assert.Nil(t, r.Header["login"])
+	assert.Nil(t, r.Header["dn"])
+	assert.Equal(t, len(logger.events), 1)
+	assert.Equal(t, logger.events[0].Login, "user2")
+	assert.Equal(t, logger.events[0].Impersonator, "operator")
+	assert.True(t, logger.events[0].Result)
+	assert.True(t, cmsAuth.CheckAuthnAuthz(r.Header))
+	logger.events = nil
+
+	// policy violations
+	for _, tt := range []struct {
+		policy ImpersonationPolicy
+		target string
+	}{
+		{ImpersonationPolicy{Role: "admin"}, "user2"},
+		{ImpersonationPolicy{Role: "impersonator", Group: "group:dbs"}, "user2"},
+		{ImpersonationPolicy{Role: "impersonator", Group: "cms"}, "user2"},
+		{ImpersonationPolicy{Role: "impersonator", Site: "cmsweb"}, "user2"},
+		{ImpersonationPolicy{Role: "impersonator", Targets: []string{"user1"}}, "user2"},
+		{policy, "operator"},
+		{policy, "unknown"},
+	} {
+		r = newRequest(tt.target)
+		ok, err = cmsAuth.Impersonate(r, tt.policy, records)
+		assert.True(t, ok)
+		assert.NotNil(t, err, tt.target)
+	}
+	assert.Equal(t, len(logger.events), 7)
+	assert.False(t, logger.events[6].Result)
+
+	// site of the role should be matched exactly
+	operator.Roles = map[string][]string{"impersonator": {"site:T1_US_FNAL"}}
+	for _, tt := range []struct {
+		policy ImpersonationPolicy
+		err    bool
+	}{
+		{ImpersonationPolicy{Role: "impersonator", Site: "site:T1_US_FNAL"}, false},
+		{ImpersonationPolicy{Role: "impersonator", Site: "T1_*"}, false},
+		{ImpersonationPolicy{Role: "impersonator", Site: "T1_US"}, true},
+		{ImpersonationPolicy{Role: "impersonator", Group: "T1_US_FNAL"}, true},
+	} {
+		r = newRequest("user2")
+		ok, err = cmsAuth.Impersonate(r, tt.policy, records)
+		assert.True(t, ok)
+		assert.Equal(t, err != nil, tt.err, tt.policy)
+	}
+	operator.Roles = map[string][]string{"impersonator": {"group:cmsweb"}}
+
+	// operator headers should be signed
+	r = newRequest("user2")
+	r.Header.Set("cms-authz-impersonator", "group:cmsweb")
+	r.Header.Set("cms-authz-injected", "group:cmsweb")
+	ok, err = cmsAuth.Impersonate(r, policy, records)
+	assert.True(t, ok)
+	assert.NotNil(t, err)
+}
+
+// TestImpersonationMiddleware function
+func TestImpersonationMiddleware(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	rmap, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	records := func() CricRecords { return rmap }
+	handler := cmsAuth.ImpersonationMiddleware(ImpersonationPolicy{Role: "operator"}, records)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Header.Get("cms-authn-login")))
+		}))
+	r, _ := http.NewRequest("GET", "/path", nil)
+	userData := map[string]interface{}{"name": "First1 Last1", "cern_upn": "user1", "dn": testCricEntries()[0].DN}
+	cmsAuth.SetCMSHeaders(r, userData, rmap, false)
+	r.Header.Set(ImpersonateHeader, "user2")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "user2")
+
+	r.Header.Set(ImpersonateHeader, "user1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+}
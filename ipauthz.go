@@ -0,0 +1,211 @@
+package cmsauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPAuthzWatchInterval defines interval to check IPAuthz file for changes
+var IPAuthzWatchInterval = 10 * time.Second
+
+// IPAuthz authorizes HTTP requests by their network origin using CIDR allow
+// and deny lists. Deny list takes precedence over allow list, and empty allow
+// list allows all addresses which are not denied. It is intended to restrict
+// internal endpoints, e.g. metrics or debug, and can be combined with CMSAuth
+// either by chaining Middleware or via CheckAuthnAuthzIP.
+type IPAuthz struct {
+	fname string
+	mutex sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPAuthz creates IPAuthz from given allow and deny lists of IP addresses
+// or CIDR networks
+func NewIPAuthz(allow, deny []string) (*IPAuthz, error) {
+	allowNets, err := parseIPNets(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseIPNets(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAuthz{allow: allowNets, deny: denyNets}, nil
+}
+
+// LoadIPAuthz creates IPAuthz from given file. Each line of the file contains
+// "allow" or "deny" keyword followed by IP address or CIDR network, e.g.
+//
+//	# internal network
+//	allow 10.0.0.0/8
+//	deny 10.1.2.3
+//
+// Empty lines and lines starting with # are ignored.
+func LoadIPAuthz(fname string) (*IPAuthz, error) {
+	ipAuthz := &IPAuthz{fname: fname}
+	if err := ipAuthz.Reload(); err != nil {
+		return nil, err
+	}
+	return ipAuthz, nil
+}
+
+// Reload re-reads IPAuthz file, the existing lists are kept on error
+func (i *IPAuthz) Reload() error {
+	if i.fname == "" {
+		return errors.New("IPAuthz file is not set")
+	}
+	data, err := os.ReadFile(i.fname)
+	if err != nil {
+		return err
+	}
+	var allow, deny []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid line %d in %s: %s", n, i.fname, line)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allow = append(allow, fields[1])
+		case "deny":
+			deny = append(deny, fields[1])
+		default:
+			return fmt.Errorf("invalid line %d in %s: %s", n, i.fname, line)
+		}
+	}
+	allowNets, err := parseIPNets(allow)
+	if err != nil {
+		return fmt.Errorf("invalid allow list in %s: %v", i.fname, err)
+	}
+	denyNets, err := parseIPNets(deny)
+	if err != nil {
+		return fmt.Errorf("invalid deny list in %s: %v", i.fname, err)
+	}
+	i.mutex.Lock()
+	i.allow = allowNets
+	i.deny = denyNets
+	i.mutex.Unlock()
+	return nil
+}
+
+// Watch polls IPAuthz file every IPAuthzWatchInterval and reloads it when
+// file is changed. It blocks until given context is done, and therefore
+// should be run in a goroutine.
+func (i *IPAuthz) Watch(ctx context.Context) {
+	ticker := time.NewTicker(IPAuthzWatchInterval)
+	defer ticker.Stop()
+	modTime, size := fileStat(i.fname)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mt, sz := fileStat(i.fname)
+			if mt.Equal(modTime) && sz == size {
+				continue
+			}
+			modTime, size = mt, sz
+			if err := i.Reload(); err != nil {
+				GetLogger().Warnf("IPAuthz, unable to reload %s, keep existing lists, error %v", i.fname, err)
+				continue
+			}
+			GetLogger().Infof("IPAuthz, reloaded %s", i.fname)
+		}
+	}
+}
+
+// Allowed checks if given IP address is allowed
+func (i *IPAuthz) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	for _, n := range i.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(i.allow) == 0 {
+		return true
+	}
+	for _, n := range i.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRequest checks if remote address of given HTTP request is allowed.
+// Forwarding headers are not taken into account since they can be set by
+// clients.
+func (i *IPAuthz) CheckRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return i.Allowed(net.ParseIP(host))
+}
+
+// Middleware returns HTTP handler which rejects requests from not allowed
+// addresses with 403 status code
+func (i *IPAuthz) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !i.CheckRequest(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckAuthnAuthzIP performs IP authorization of given HTTP request followed
+// by CMS authentication and authorization, see CheckAuthnAuthzRequest
+func (a *CMSAuth) CheckAuthnAuthzIP(r *http.Request, ipAuthz *IPAuthz) bool {
+	if ipAuthz != nil && !ipAuthz.CheckRequest(r) {
+		recordAuthFailure(ErrIPDenied.Reason)
+		return false
+	}
+	return a.CheckAuthnAuthzRequest(r)
+}
+
+// helper function to parse list of IP addresses or CIDR networks
+func parseIPNets(vals []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range vals {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %s", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,86 @@
+package cmsauth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIPAuthz function
+func TestIPAuthz(t *testing.T) {
+	ipAuthz, err := NewIPAuthz([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.2.3"})
+	assert.Nil(t, err)
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"192.168.1.1", false},
+		{"2001:db8::1", true},
+		{"::1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, ipAuthz.Allowed(net.ParseIP(tt.ip)), tt.allowed, tt.ip)
+	}
+	_, err = NewIPAuthz([]string{"10.0.0.0/33"}, nil)
+	assert.NotNil(t, err)
+
+	// empty allow list allows all addresses except denied ones
+	ipAuthz, err = NewIPAuthz(nil, []string{"192.168.0.0/16"})
+	assert.Nil(t, err)
+	assert.Equal(t, ipAuthz.Allowed(net.ParseIP("10.0.0.1")), true)
+	assert.Equal(t, ipAuthz.Allowed(net.ParseIP("192.168.1.1")), false)
+}
+
+// TestLoadIPAuthz function
+func TestLoadIPAuthz(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "ipauthz")
+	err := os.WriteFile(fname, []byte("# internal network\nallow 127.0.0.0/8\n\ndeny 127.0.0.2\n"), 0600)
+	assert.Nil(t, err)
+	ipAuthz, err := LoadIPAuthz(fname)
+	assert.Nil(t, err)
+
+	handler := ipAuthz.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(addr string) int {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	assert.Equal(t, request("127.0.0.1:1234"), http.StatusOK)
+	assert.Equal(t, request("127.0.0.2:1234"), http.StatusForbidden)
+	assert.Equal(t, request("10.0.0.1:1234"), http.StatusForbidden)
+
+	// invalid file keeps existing lists
+	err = os.WriteFile(fname, []byte("permit 10.0.0.0/8\n"), 0600)
+	assert.Nil(t, err)
+	assert.NotNil(t, ipAuthz.Reload())
+	assert.Equal(t, request("127.0.0.1:1234"), http.StatusOK)
+
+	err = os.WriteFile(fname, []byte("allow 10.0.0.0/8\n"), 0600)
+	assert.Nil(t, err)
+	assert.Nil(t, ipAuthz.Reload())
+	assert.Equal(t, request("127.0.0.1:1234"), http.StatusForbidden)
+	assert.Equal(t, request("10.0.0.1:1234"), http.StatusOK)
+
+	// combined with CMS authentication
+	cmsAuth := initCMSAuth(t)
+	r := httptest.NewRequest("GET", "/path", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-login", "user")
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	r.Header.Set("cms-authn-hmac", hmac)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzIP(r, ipAuthz), false)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, cmsAuth.CheckAuthnAuthzIP(r, ipAuthz), true)
+}
@@ -0,0 +1,93 @@
+package cmsauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyProvider defines interface to fetch HMAC key for given reference
+type KeyProvider interface {
+	Fetch(ref string) ([]byte, error)
+}
+
+// FileKeyProvider reads HMAC key from local file system
+type FileKeyProvider struct{}
+
+// Fetch reads HMAC key from given file, the file:// prefix is optional
+func (p FileKeyProvider) Fetch(ref string) ([]byte, error) {
+	return os.ReadFile(strings.TrimPrefix(ref, "file://"))
+}
+
+// MaxKeySize defines maximum size of HMAC key fetched by HTTPKeyProvider
+var MaxKeySize int64 = 64 * 1024
+
+// HTTPKeyProvider fetches HMAC key from given URL. It is registered only for
+// https scheme since the key would be exposed by plain HTTP.
+type HTTPKeyProvider struct{}
+
+// Fetch reads HMAC key from given URL
+func (p HTTPKeyProvider) Fetch(ref string) ([]byte, error) {
+	client, err := NewHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	if Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ReadToken(Token)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch key from %s, status %s", ref, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxKeySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxKeySize {
+		return nil, fmt.Errorf("key fetched from %s exceeds %d bytes", ref, MaxKeySize)
+	}
+	return data, nil
+}
+
+// keyProviders holds registered key providers, the map key is URL scheme
+var keyProviders = map[string]KeyProvider{
+	"file":  FileKeyProvider{},
+	"https": HTTPKeyProvider{},
+}
+
+// keyProvidersLock keeps lock for keyProviders updates
+var keyProvidersLock sync.RWMutex
+
+// RegisterKeyProvider registers key provider for given scheme, e.g. vault
+func RegisterKeyProvider(scheme string, provider KeyProvider) {
+	keyProvidersLock.Lock()
+	defer keyProvidersLock.Unlock()
+	keyProviders[strings.ToLower(scheme)] = provider
+}
+
+// FetchKey fetches HMAC key for given reference. The reference may either be
+// a bare file name or scheme://path reference resolved by registered provider.
+func FetchKey(ref string) ([]byte, error) {
+	scheme := "file"
+	if idx := strings.Index(ref, "://"); idx > 0 {
+		scheme = strings.ToLower(ref[:idx])
+	}
+	keyProvidersLock.RLock()
+	provider, ok := keyProviders[scheme]
+	keyProvidersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no key provider registered for scheme %s", scheme)
+	}
+	return provider.Fetch(ref)
+}
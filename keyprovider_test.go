@@ -0,0 +1,76 @@
+package cmsauth
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockKeyProvider provides static keys for given references
+type mockKeyProvider struct {
+	keys map[string][]byte
+}
+
+// Fetch implements KeyProvider interface
+func (p mockKeyProvider) Fetch(ref string) ([]byte, error) {
+	if key, ok := p.keys[ref]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown reference")
+}
+
+// TestKeyProvider function
+func TestKeyProvider(t *testing.T) {
+	ref := "vault://cms/hmac"
+	RegisterKeyProvider("vault", mockKeyProvider{keys: map[string][]byte{ref: []byte("secret")}})
+	var vaultAuth CMSAuth
+	vaultAuth.Init(ref)
+	fileAuth := initCMSAuth(t)
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-name", "First Last")
+	hmac1, _ := vaultAuth.GetHmac(r, false)
+	hmac2, _ := fileAuth.GetHmac(r, false)
+	assert.Equal(t, hmac1, hmac2)
+
+	_, err := FetchKey("unknown://key")
+	assert.NotNil(t, err)
+}
+
+// TestHTTPKeyProvider function
+func TestHTTPKeyProvider(t *testing.T) {
+	key := "secret"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(key))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test-ca.pem"), data, 0600))
+	t.Setenv("X509_CERT_DIR", dir)
+
+	hkey, err := FetchKey(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, hkey, []byte("secret"))
+	_, err = FetchKey(server.URL + "/missing")
+	assert.NotNil(t, err)
+
+	// plain HTTP is not allowed
+	_, err = FetchKey(strings.Replace(server.URL, "https://", "http://", 1))
+	assert.NotNil(t, err)
+
+	key = strings.Repeat("x", int(MaxKeySize)+1)
+	_, err = FetchKey(server.URL)
+	assert.NotNil(t, err)
+}
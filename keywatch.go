@@ -0,0 +1,77 @@
+package cmsauth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// KeyWatchInterval defines interval to check auth file for changes
+var KeyWatchInterval = 10 * time.Second
+
+// ReloadKey re-reads HMAC key from CMSAuth auth file. The existing key is
+// kept if auth file can not be read.
+func (a *CMSAuth) ReloadKey() error {
+	if a.afile == "" {
+		return errors.New("CMSAuth auth file is not set")
+	}
+	hkey, err := FetchKey(a.afile)
+	if err != nil {
+		return err
+	}
+	if len(hkey) == 0 {
+		return errors.New("CMSAuth auth file is empty")
+	}
+	a.setKey(hkey)
+	return nil
+}
+
+// WatchKeyFile watches CMSAuth auth file and reloads HMAC key when file is
+// changed or process receives SIGHUP signal. The file is polled every
+// KeyWatchInterval. It blocks until given context is done, and therefore
+// should be run in a goroutine.
+func (a *CMSAuth) WatchKeyFile(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	ticker := time.NewTicker(KeyWatchInterval)
+	defer ticker.Stop()
+	modTime, size := fileStat(a.afile)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			a.reloadKey("SIGHUP")
+			modTime, size = fileStat(a.afile)
+		case <-ticker.C:
+			mt, sz := fileStat(a.afile)
+			if mt.Equal(modTime) && sz == size {
+				continue
+			}
+			modTime, size = mt, sz
+			a.reloadKey("file change")
+		}
+	}
+}
+
+// helper function to reload HMAC key and log the outcome
+func (a *CMSAuth) reloadKey(reason string) {
+	if err := a.ReloadKey(); err != nil {
+		GetLogger().Warnf("CMSAuth, unable to reload %s on %s, keep existing key, error %v", a.afile, reason, err)
+		return
+	}
+	GetLogger().Infof("CMSAuth, reloaded %s on %s", a.afile, reason)
+}
+
+// helper function to return modification time and size of given file
+func fileStat(fname string) (time.Time, int64) {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return fi.ModTime(), fi.Size()
+}
@@ -0,0 +1,121 @@
+package cmsauth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatchKeyFile function
+func TestWatchKeyFile(t *testing.T) {
+	interval := KeyWatchInterval
+	defer func() { KeyWatchInterval = interval }()
+	KeyWatchInterval = 10 * time.Millisecond
+
+	fname := filepath.Join(t.TempDir(), "hmac.key")
+	err := os.WriteFile(fname, []byte("secret"), 0600)
+	assert.Nil(t, err)
+	var cmsAuth CMSAuth
+	cmsAuth.Init(fname)
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-name", "First Last")
+	hmac1, _ := cmsAuth.GetHmac(r, false)
+	expect, _ := cmsAuth.GetHmacWithKey(r, []byte("new-secret"), false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cmsAuth.WatchKeyFile(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	// unreadable file keeps existing key
+	err = os.Remove(fname)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	hmac, _ := cmsAuth.GetHmac(r, false)
+	assert.Equal(t, hmac, hmac1)
+
+	err = os.WriteFile(fname, []byte("new-secret"), 0600)
+	assert.Nil(t, err)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hmac, _ = cmsAuth.GetHmac(r, false)
+		if hmac == expect {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, hmac, expect)
+}
+
+// TestKeyGracePeriod function
+func TestKeyGracePeriod(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "hmac.key")
+	err := os.WriteFile(fname, []byte("secret"), 0600)
+	assert.Nil(t, err)
+	cmsAuth := &CMSAuth{KeyGracePeriod: time.Hour}
+	assert.Nil(t, cmsAuth.InitError(fname))
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-login", "user")
+	oldHmac, _ := cmsAuth.GetHmac(r, false)
+
+	err = os.WriteFile(fname, []byte("new-secret"), 0600)
+	assert.Nil(t, err)
+	assert.Nil(t, cmsAuth.ReloadKey())
+	newHmac, _ := cmsAuth.GetHmac(r, false)
+	assert.NotEqual(t, newHmac, oldHmac)
+
+	// both old and new keys are accepted within grace period
+	for _, hmac := range []string{oldHmac, newHmac} {
+		header := lowerHeaders(r.Header)
+		header["cms-authn-hmac"] = []string{hmac}
+		assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	}
+
+	// old key is rejected after grace period
+	cmsAuth.kmutex.Lock()
+	cmsAuth.prevExpire = time.Now().Add(-time.Second)
+	cmsAuth.kmutex.Unlock()
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{oldHmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+}
+
+// TestKeyRing function
+func TestKeyRing(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "frontend1.key"), []byte("key1"), 0600)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(dir, "frontend2.key"), []byte("key2"), 0600)
+	assert.Nil(t, err)
+	assert.Nil(t, cmsAuth.SetKeyRing(dir))
+
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-auth-status", "ok")
+	r.Header.Set("cms-authn-login", "user")
+	for key, name := range map[string]string{"key2": "frontend2.key", "secret": "hmac.key"} {
+		hmac, _ := cmsAuth.GetHmacWithKey(r, []byte(key), false)
+		header := lowerHeaders(r.Header)
+		header["cms-authn-hmac"] = []string{hmac}
+		header["Cms-Auth-Key"] = []string{"spoofed"}
+		assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+		assert.Equal(t, header["cms-auth-key"], []string{name})
+		_, ok := header["Cms-Auth-Key"]
+		assert.Equal(t, ok, false)
+	}
+
+	hmac, _ := cmsAuth.GetHmacWithKey(r, []byte("key3"), false)
+	header := lowerHeaders(r.Header)
+	header["cms-authn-hmac"] = []string{hmac}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+
+	assert.NotNil(t, cmsAuth.SetKeyRing(filepath.Join(dir, "missing.key")))
+}
@@ -0,0 +1,111 @@
+package cmsauth
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// LogLevel defines severity of log messages
+type LogLevel int
+
+// supported log levels
+const (
+	LevelDebug LogLevel = iota // debug messages
+	LevelInfo                  // informational messages, e.g. verbose output
+	LevelWarn                  // recoverable problems
+	LevelError                 // errors
+)
+
+// String returns name of log level
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Logger defines interface used by cmsauth to write log messages. It allows
+// services to route cmsauth logs into their own logging pipeline, e.g. zap
+// SugaredLogger satisfies this interface and SlogLogger adapts slog.Logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// StdLogger implements Logger using standard log package, messages below
+// Level are discarded
+type StdLogger struct {
+	Level  LogLevel    // minimal level of written messages
+	Logger *log.Logger // logger to use, default is standard logger
+}
+
+// helper function to write log message of given level
+func (l *StdLogger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if level != LevelInfo {
+		msg = level.String() + " " + msg
+	}
+	if l.Logger != nil {
+		l.Logger.Output(3, msg)
+		return
+	}
+	log.Output(3, msg)
+}
+
+// Debugf writes debug message
+func (l *StdLogger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, format, args...)
+}
+
+// Infof writes informational message
+func (l *StdLogger) Infof(format string, args ...interface{}) {
+	l.logf(LevelInfo, format, args...)
+}
+
+// Warnf writes warning message
+func (l *StdLogger) Warnf(format string, args ...interface{}) {
+	l.logf(LevelWarn, format, args...)
+}
+
+// Errorf writes error message
+func (l *StdLogger) Errorf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+}
+
+// loggerHolder allows to store Logger interface in atomic.Value
+type loggerHolder struct {
+	Logger
+}
+
+// pkgLogger holds Logger used by the package
+var pkgLogger atomic.Value
+
+// SetLogger sets Logger used by the package, nil restores default StdLogger
+// which writes messages of LevelInfo and above via standard log package
+func SetLogger(l Logger) {
+	if l == nil {
+		l = &StdLogger{Level: LevelInfo}
+	}
+	pkgLogger.Store(loggerHolder{l})
+}
+
+// GetLogger returns Logger used by the package
+func GetLogger() Logger {
+	if h, ok := pkgLogger.Load().(loggerHolder); ok {
+		return h.Logger
+	}
+	return &StdLogger{Level: LevelInfo}
+}
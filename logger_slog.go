@@ -0,0 +1,46 @@
+//go:build go1.21
+
+package cmsauth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger adapts slog.Logger to Logger interface
+type SlogLogger struct {
+	Logger *slog.Logger // slog logger to use, default is slog.Default()
+}
+
+// helper function to write log message of given level
+func (l *SlogLogger) logf(level slog.Level, format string, args ...interface{}) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+// Debugf writes debug message
+func (l *SlogLogger) Debugf(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+// Infof writes informational message
+func (l *SlogLogger) Infof(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+// Warnf writes warning message
+func (l *SlogLogger) Warnf(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+// Errorf writes error message
+func (l *SlogLogger) Errorf(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+}
@@ -0,0 +1,22 @@
+//go:build go1.21
+
+package cmsauth
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlogLogger function
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})
+	logger := &SlogLogger{Logger: slog.New(handler)}
+	logger.Debugf("debug %d", 1)
+	logger.Warnf("warn %d", 2)
+	assert.NotContains(t, buf.String(), "debug 1")
+	assert.Contains(t, buf.String(), "level=WARN msg=\"warn 2\"")
+}
@@ -0,0 +1,71 @@
+package cmsauth
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testLogger records log messages
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "debug "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "info "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warn "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "error "+fmt.Sprintf(format, args...))
+}
+
+// TestStdLogger function
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &StdLogger{Level: LevelWarn, Logger: log.New(&buf, "", 0)}
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warn %d", 3)
+	logger.Errorf("error %d", 4)
+	assert.Equal(t, buf.String(), "WARN warn 3\nERROR error 4\n")
+}
+
+// TestSetLogger function
+func TestSetLogger(t *testing.T) {
+	logger := &testLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	var cmsAuth CMSAuth
+	cmsAuth.Init("/non/existing/file")
+	assert.Equal(t, len(logger.messages), 1)
+	assert.Contains(t, logger.messages[0], "error CMSAuth, unable to read /non/existing/file")
+
+	// verbose HMAC computation never logs the secret key
+	logger.messages = nil
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("cms-authn-login", "user")
+	_, err := cmsAuth.GetHmacWithKey(r, []byte("top-secret-key"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, len(logger.messages), 2)
+	for _, msg := range logger.messages {
+		assert.NotContains(t, msg, "top-secret-key")
+	}
+	assert.Contains(t, logger.messages[0], "key sha256:"+keyFingerprint([]byte("top-secret-key")))
+
+	SetLogger(nil)
+	_, ok := GetLogger().(*StdLogger)
+	assert.Equal(t, ok, true)
+}
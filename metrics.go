@@ -0,0 +1,184 @@
+package cmsauth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// histogram represents Prometheus histogram with fixed buckets
+type histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// helper function to create new histogram with given bucket upper bounds
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// helper function to record observation in histogram
+func (h *histogram) observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// helper function to write histogram in Prometheus text format
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// authMetrics keeps metrics of authentication and authorization decisions
+var authMetrics = struct {
+	successes      atomic.Uint64
+	hmacMismatches atomic.Uint64
+	tokenHits      atomic.Uint64
+	tokenMisses    atomic.Uint64
+	cricRecords    atomic.Int64
+	revoked        atomic.Int64
+	revokeErrors   atomic.Uint64
+	mutex          sync.Mutex
+	failures       map[string]uint64
+	revokedTokens  map[string]uint64
+	cricRefresh    *histogram
+	tokenLatency   *histogram
+}{
+	failures:      make(map[string]uint64),
+	revokedTokens: make(map[string]uint64),
+	cricRefresh:   newHistogram(0.1, 0.5, 1, 5, 10, 30, 60),
+	tokenLatency:  newHistogram(0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1),
+}
+
+// helper function to record successful authentication and authorization
+func recordAuthSuccess() {
+	authMetrics.successes.Add(1)
+}
+
+// helper function to record authentication or authorization failure
+func recordAuthFailure(reason string) {
+	if reason == "hmac_mismatch" {
+		authMetrics.hmacMismatches.Add(1)
+	}
+	authMetrics.mutex.Lock()
+	authMetrics.failures[reason]++
+	authMetrics.mutex.Unlock()
+}
+
+// helper function to record CRIC refresh duration and number of records
+func recordCricRefresh(d time.Duration, records int) {
+	authMetrics.cricRefresh.observe(d.Seconds())
+	authMetrics.cricRecords.Store(int64(records))
+}
+
+// helper function to record token validation latency
+func recordTokenValidation(d time.Duration) {
+	authMetrics.tokenLatency.observe(d.Seconds())
+}
+
+// helper function to record token cache hit or miss
+func recordTokenCache(hit bool) {
+	if hit {
+		authMetrics.tokenHits.Add(1)
+	} else {
+		authMetrics.tokenMisses.Add(1)
+	}
+}
+
+// helper function to record rejected revoked token by claim which revoked it
+func recordTokenRevoked(claim string) {
+	authMetrics.mutex.Lock()
+	authMetrics.revokedTokens[claim]++
+	authMetrics.mutex.Unlock()
+}
+
+// helper function to record number of entries of refreshed revocation list
+func recordRevocationRefresh(entries int) {
+	authMetrics.revoked.Store(int64(entries))
+}
+
+// helper function to record failed refresh of revocation list
+func recordRevocationError() {
+	authMetrics.revokeErrors.Add(1)
+}
+
+// WriteMetrics writes cmsauth metrics in Prometheus text format
+func WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP cmsauth_auth_success_total Number of successful authentication and authorization checks\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_auth_success_total counter\n")
+	fmt.Fprintf(w, "cmsauth_auth_success_total %d\n", authMetrics.successes.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_auth_failure_total Number of failed authentication and authorization checks by reason\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_auth_failure_total counter\n")
+	authMetrics.mutex.Lock()
+	var reasons []string
+	for reason := range authMetrics.failures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "cmsauth_auth_failure_total{reason=\"%s\"} %d\n", reason, authMetrics.failures[reason])
+	}
+	authMetrics.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP cmsauth_hmac_mismatch_total Number of requests with HMAC mismatch\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_hmac_mismatch_total counter\n")
+	fmt.Fprintf(w, "cmsauth_hmac_mismatch_total %d\n", authMetrics.hmacMismatches.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_cric_records Number of CRIC records\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_cric_records gauge\n")
+	fmt.Fprintf(w, "cmsauth_cric_records %d\n", authMetrics.cricRecords.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_token_cache_hits_total Number of token validations served from cache\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_token_cache_hits_total counter\n")
+	fmt.Fprintf(w, "cmsauth_token_cache_hits_total %d\n", authMetrics.tokenHits.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_token_cache_misses_total Number of token validations not found in cache\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_token_cache_misses_total counter\n")
+	fmt.Fprintf(w, "cmsauth_token_cache_misses_total %d\n", authMetrics.tokenMisses.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_token_revoked_total Number of rejected revoked tokens by claim\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_token_revoked_total counter\n")
+	authMetrics.mutex.Lock()
+	var claims []string
+	for claim := range authMetrics.revokedTokens {
+		claims = append(claims, claim)
+	}
+	sort.Strings(claims)
+	for _, claim := range claims {
+		fmt.Fprintf(w, "cmsauth_token_revoked_total{claim=\"%s\"} %d\n", claim, authMetrics.revokedTokens[claim])
+	}
+	authMetrics.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP cmsauth_revocation_list_entries Number of entries of token revocation list\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_revocation_list_entries gauge\n")
+	fmt.Fprintf(w, "cmsauth_revocation_list_entries %d\n", authMetrics.revoked.Load())
+	fmt.Fprintf(w, "# HELP cmsauth_revocation_refresh_errors_total Number of failed refreshes of token revocation list\n")
+	fmt.Fprintf(w, "# TYPE cmsauth_revocation_refresh_errors_total counter\n")
+	fmt.Fprintf(w, "cmsauth_revocation_refresh_errors_total %d\n", authMetrics.revokeErrors.Load())
+	authMetrics.cricRefresh.write(w, "cmsauth_cric_refresh_duration_seconds", "Duration of CRIC refresh")
+	authMetrics.tokenLatency.write(w, "cmsauth_token_validation_duration_seconds", "Latency of token validation")
+}
+
+// MetricsHandler returns HTTP handler which exposes cmsauth metrics in
+// Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
@@ -0,0 +1,32 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetricsHandler function
+func TestMetricsHandler(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	header := http.Header{
+		"cms-auth-status": {"ok"},
+		"cms-authn-name":  {"First Last"},
+		"cms-authn-hmac":  {"invalid"},
+	}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+	recordCricRefresh(0, 2)
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	MetricsHandler().ServeHTTP(rr, r)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	body := rr.Body.String()
+	assert.Contains(t, body, "cmsauth_auth_success_total")
+	assert.Contains(t, body, "cmsauth_auth_failure_total{reason=\"hmac_mismatch\"}")
+	assert.Contains(t, body, "cmsauth_cric_records 2\n")
+	assert.Contains(t, body, "cmsauth_cric_refresh_duration_seconds_bucket{le=\"0.1\"}")
+	assert.Contains(t, body, "cmsauth_token_validation_duration_seconds_count")
+}
@@ -0,0 +1,125 @@
+package cmsauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// userContextKey defines type of context key used to store User
+type userContextKey struct{}
+
+// NewUserContext returns copy of given context with given User
+func NewUserContext(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns User stored in given context by Middleware
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(User)
+	return user, ok
+}
+
+// userInfoContextKey defines type of context key used to store UserInfo
+type userInfoContextKey struct{}
+
+// NewUserInfoContext returns copy of given context with given UserInfo
+func NewUserInfoContext(ctx context.Context, info *UserInfo) context.Context {
+	return context.WithValue(ctx, userInfoContextKey{}, info)
+}
+
+// UserInfoFromContext returns UserInfo stored in given context by Middleware,
+// it is only available for authenticated requests
+func UserInfoFromContext(ctx context.Context) (*UserInfo, bool) {
+	info, ok := ctx.Value(userInfoContextKey{}).(*UserInfo)
+	return info, ok
+}
+
+// Middleware returns HTTP handler which performs authentication and
+// authorization of HTTP requests before passing them to next handler. The
+// requests which fail authentication are rejected with 401 status code and
+// requests which fail authorization are rejected with 403 status code. The
+// authenticated User and UserInfo are stored in request context, see
+// UserFromContext and UserInfoFromContext. Without auth file the requests
+// are passed as is, i.e. identity obtained from unverified headers is not
+// stored in request context.
+func (a *CMSAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.afile == "" { // no auth file is provided
+			next.ServeHTTP(w, r)
+			return
+		}
+		if status, reason := a.authnAuthz(r.Header, r.Method, r.Host, r.URL.Path); !status {
+			code := ErrorStatus(ReasonError(reason))
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		ctx := NewUserContext(r.Context(), a.UserFromHeader(r.Header))
+		if info, err := a.GetUserInfo(r); err == nil {
+			ctx = NewUserInfoContext(ctx, info)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole returns middleware which allows only requests of users with
+// given role and, if provided, any of given groups or sites, e.g.
+// RequireRole("admin", "group:dbs", "site:T1_*"). The values without prefix
+// are treated as groups and may contain shell wildcards. Values are matched
+// exactly against cms-authz headers, therefore it should be chained after
+// CMSAuth Middleware which verifies the headers. Requests which fail the
+// check are rejected with 403 status code. Use CMSAuth RequireRole with
+// custom HeaderPrefix.
+func RequireRole(role string, groups ...string) func(http.Handler) http.Handler {
+	return requireUser(roleExpr(role, groups...), UserFromHeader)
+}
+
+// RequireRole returns middleware similar to RequireRole function which
+// matches authz headers of configured namespace
+func (a *CMSAuth) RequireRole(role string, groups ...string) func(http.Handler) http.Handler {
+	return requireUser(roleExpr(role, groups...), a.UserFromHeader)
+}
+
+// RequireExpr returns middleware which allows only requests whose roles in
+// authz headers of configured namespace satisfy given expression, see
+// AuthzExpr. Requests which fail the check are rejected with 403 status code.
+func (a *CMSAuth) RequireExpr(expr *AuthzExpr) func(http.Handler) http.Handler {
+	return requireUser(expr, a.UserFromHeader)
+}
+
+// helper function to build authorization expression of given role and any of
+// given groups or sites
+func roleExpr(role string, groups ...string) *AuthzExpr {
+	expr := &AuthzExpr{kind: "role", value: role}
+	var alt *AuthzExpr
+	for _, g := range groups {
+		kind, value, ok := strings.Cut(g, ":")
+		if !ok || (kind != "group" && kind != "site") {
+			kind, value = "group", g
+		}
+		term := &AuthzExpr{kind: kind, value: value}
+		if alt == nil {
+			alt = term
+		} else {
+			alt = &AuthzExpr{op: "OR", args: []*AuthzExpr{alt, term}}
+		}
+	}
+	if alt != nil {
+		expr = &AuthzExpr{op: "AND", args: []*AuthzExpr{expr, alt}}
+	}
+	return expr
+}
+
+// helper function to return middleware which matches user obtained from
+// request headers by given function against authorization expression
+func requireUser(expr *AuthzExpr, user func(http.Header) User) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !expr.MatchUser(user(r.Header)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
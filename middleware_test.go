@@ -0,0 +1,112 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMiddleware function
+func TestMiddleware(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	var login string
+	handler := cmsAuth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		assert.Equal(t, ok, true)
+		login = user.Login
+		info, ok := UserInfoFromContext(r.Context())
+		assert.Equal(t, ok, true)
+		assert.Equal(t, info.Login, user.Login)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// helper function to create signed request
+	request := func() *http.Request {
+		r := httptest.NewRequest("GET", "/path", nil)
+		r.Header.Set("cms-auth-status", "ok")
+		r.Header.Set("cms-authn-login", "user")
+		r.Header.Set("cms-request-uri", "/path")
+		hmac, _ := cmsAuth.GetHmac(r, false)
+		r.Header.Set("cms-authn-hmac", hmac)
+		return r
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, request())
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, login, "user")
+
+	r := request()
+	r.Header.Set("cms-authn-hmac", "invalid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, rec.Code, http.StatusUnauthorized)
+
+	cmsAuth.SetAuthorizer(&mockAuthorizer{grant: false})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request())
+	assert.Equal(t, rec.Code, http.StatusForbidden)
+
+	// identity from unverified headers is not stored without auth file
+	var noAuth CMSAuth
+	var found bool
+	handler = noAuth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, found = UserFromContext(r.Context())
+		_, ok := UserInfoFromContext(r.Context())
+		found = found || ok
+	}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, request())
+	assert.Equal(t, rec.Code, http.StatusOK)
+	assert.Equal(t, found, false)
+}
+
+// TestRequireRole function
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		guard func(http.Handler) http.Handler
+		code  int
+	}{
+		{RequireRole("admin"), http.StatusOK},
+		{RequireRole("admin", "dbs"), http.StatusOK},
+		{RequireRole("admin", "group:das", "site:T1_*"), http.StatusOK},
+		{RequireRole("admin", "site:T1"), http.StatusForbidden},
+		{RequireRole("operator"), http.StatusForbidden},
+		{RequireRole("admin", "das"), http.StatusForbidden},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		r.Header.Set("cms-authz-admin", "group:dbs site:T1_US_FNAL")
+		rec := httptest.NewRecorder()
+		test.guard(ok).ServeHTTP(rec, r)
+		assert.Equal(t, rec.Code, test.code, i)
+	}
+
+	// custom header prefix
+	atlasAuth := CMSAuth{HeaderPrefix: "atlas"}
+	expr, err := ParseAuthzExpr("role:admin AND site:T1_*")
+	assert.Nil(t, err)
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.Header.Set("atlas-authz-admin", "group:dbs site:T1_US_FNAL")
+	assert.Equal(t, atlasAuth.MatchAuthzExpr(r.Header, expr), true)
+	assert.Equal(t, expr.Match(r.Header), false)
+	tests = []struct {
+		guard func(http.Handler) http.Handler
+		code  int
+	}{
+		{atlasAuth.RequireRole("admin", "dbs"), http.StatusOK},
+		{atlasAuth.RequireExpr(expr), http.StatusOK},
+		{atlasAuth.RequireRole("operator"), http.StatusForbidden},
+		{RequireRole("admin"), http.StatusForbidden},
+	}
+	for i, test := range tests {
+		rec := httptest.NewRecorder()
+		test.guard(ok).ServeHTTP(rec, r)
+		assert.Equal(t, rec.Code, test.code, i)
+	}
+}
@@ -0,0 +1,153 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TokenSource provides access token for HTTP requests
+type TokenSource func() (string, error)
+
+// Option configures HTTP client created by HttpClientWithOptions
+type Option func(*clientOptions)
+
+// clientOptions holds options of HTTP client
+type clientOptions struct {
+	config      Config
+	timeout     time.Duration
+	proxy       *url.URL
+	transport   http.RoundTripper
+	retries     int
+	retryWait   time.Duration
+	tokenSource TokenSource
+}
+
+// WithConfig sets client configuration, by default DefaultConfig is used
+func WithConfig(config Config) Option {
+	return func(o *clientOptions) {
+		o.config = config
+	}
+}
+
+// WithTimeout sets timeout of HTTP requests
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithProxy sets URL of HTTP proxy, by default proxy is taken from environment
+func WithProxy(proxy *url.URL) Option {
+	return func(o *clientOptions) {
+		o.proxy = proxy
+	}
+}
+
+// WithTransport sets custom transport, in this case X509 certs, CA and proxy
+// settings are not applied
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// WithRetry sets number of retries and wait time between retries of
+// idempotent requests which fail with network error or 5xx status code
+func WithRetry(retries int, wait time.Duration) Option {
+	return func(o *clientOptions) {
+		o.retries = retries
+		o.retryWait = wait
+	}
+}
+
+// WithTokenSource sets source of bearer token added to every request, in this
+// case X509 certs are not used
+func WithTokenSource(src TokenSource) Option {
+	return func(o *clientOptions) {
+		o.tokenSource = src
+	}
+}
+
+// HttpClientWithOptions provides HTTP client configured by given options
+// instead of package global variables
+func HttpClientWithOptions(opts ...Option) *http.Client {
+	o := &clientOptions{config: DefaultConfig()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	timeout := o.timeout
+	if timeout == 0 && o.config.Timeout > 0 {
+		timeout = time.Duration(o.config.Timeout) * time.Second
+	}
+	transport := o.transport
+	if transport == nil {
+		c := New(o.config)
+		useCerts := o.config.Token == "" && o.tokenSource == nil
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tlsConfig, err := c.tlsConfig(useCerts)
+		if err != nil {
+			GetLogger().Errorf("unable to create HTTP client, error %v", err)
+			return &http.Client{Transport: errTransport{err: err}, Timeout: timeout}
+		}
+		tr.TLSClientConfig = tlsConfig
+		if o.proxy != nil {
+			tr.Proxy = http.ProxyURL(o.proxy)
+		}
+		transport = tr
+	}
+	if o.tokenSource != nil {
+		transport = &tokenTransport{next: transport, src: o.tokenSource}
+	}
+	if o.retries > 0 {
+		transport = &retryTransport{next: transport, retries: o.retries, wait: o.retryWait}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// tokenTransport adds bearer token to HTTP requests
+type tokenTransport struct {
+	next http.RoundTripper
+	src  TokenSource
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.src()
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(r)
+}
+
+// retryTransport retries idempotent HTTP requests
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	wait    time.Duration
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t *retryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return resp, err
+	}
+	for i := 0; i < t.retries; i++ {
+		if err == nil && resp.StatusCode < 500 {
+			break
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(t.wait):
+		}
+		resp, err = t.next.RoundTrip(r)
+	}
+	return resp, err
+}
@@ -0,0 +1,40 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// UserDataFunc returns user data, e.g. OAuth claims or validated token claims,
+// of authenticated user for given HTTP request
+type UserDataFunc func(r *http.Request) (map[string]interface{}, error)
+
+// ProxyDirector returns director function for httputil.ReverseProxy which
+// rewrites requests to given target and sets and signs CMS headers using
+// user data and CRIC records, similar to CMS auth-proxy-server. Any cms-*
+// headers provided by the client are removed. If user data can not be
+// obtained the request is forwarded with cms-auth-status NONE.
+func (a *CMSAuth) ProxyDirector(target *url.URL, userData UserDataFunc, records func() CricRecords) func(*http.Request) {
+	director := httputil.NewSingleHostReverseProxy(target).Director
+	return func(r *http.Request) {
+		director(r)
+		data, err := userData(r)
+		if err != nil {
+			a.clearCMSHeaders(r)
+			r.Header.Set(a.HeaderKey("auth-status"), "NONE")
+			return
+		}
+		var cricRecords CricRecords
+		if records != nil {
+			cricRecords = records()
+		}
+		a.SetCMSHeadersClean(r, data, cricRecords, false)
+	}
+}
+
+// ReverseProxy returns httputil.ReverseProxy to given target which signs
+// outgoing requests, see ProxyDirector
+func (a *CMSAuth) ReverseProxy(target *url.URL, userData UserDataFunc, records func() CricRecords) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{Director: a.ProxyDirector(target, userData, records)}
+}
@@ -0,0 +1,54 @@
+package cmsauth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReverseProxy function
+func TestReverseProxy(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cmsAuth.CheckAuthnAuthz(r.Header) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("cms-authn-login") + " " + r.Header.Get("cms-authz-operator")))
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	records, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	userData := func(r *http.Request) (map[string]interface{}, error) {
+		if r.Header.Get("Authorization") == "" {
+			return nil, errors.New("no credentials")
+		}
+		return map[string]interface{}{"cern_upn": "user1", "dn": testCricEntries()[0].DN}, nil
+	}
+	proxy := httptest.NewServer(cmsAuth.ReverseProxy(target, userData, func() CricRecords { return records }))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/path", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("cms-authz-admin", "group:injected")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, string(body), "user1 group:dbs")
+
+	// unauthenticated requests are forwarded without CMS user headers
+	req.Header.Del("Authorization")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+}
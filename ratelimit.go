@@ -0,0 +1,136 @@
+package cmsauth
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// MaxRateLimitBuckets defines number of rate limiter buckets after which idle
+// buckets are removed
+var MaxRateLimitBuckets = 10000
+
+// tokenBucket represents token bucket of single identity
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter throttles requests of authenticated users using token bucket
+// per identity, i.e. each user can perform Burst requests at once and Rate
+// requests per second afterwards. The identity is taken from cms-authn-login
+// header, or cms-authn-dn header if login is not set, and client address is
+// used for unauthenticated requests. The RateLimiter can be used per
+// endpoint by wrapping endpoint handlers with separate instances.
+type RateLimiter struct {
+	Rate    float64                      // allowed number of requests per second, zero disables limit
+	Burst   int                          // maximum number of requests at once
+	KeyFunc func(r *http.Request) string // optional function to return request identity
+	Prefix  string                       // header prefix, e.g. CMSAuth HeaderPrefix, default is DefaultHeaderPrefix
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// NewRateLimiter creates RateLimiter with given rate (requests per second) and
+// burst, the burst smaller than one is set to one
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{Rate: rate, Burst: burst}
+}
+
+// Allow consumes token of given identity and reports whether request is
+// allowed, for rejected requests it returns time after which request can be
+// retried
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.Rate <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxRateLimitBuckets {
+			l.purge(now, burst)
+		}
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	return false, wait
+}
+
+// helper function to remove buckets which are refilled, i.e. do not differ
+// from new ones
+func (l *RateLimiter) purge(now time.Time, burst float64) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Key returns identity of given HTTP request used by RateLimiter
+func (l *RateLimiter) Key(r *http.Request) string {
+	if l.KeyFunc != nil {
+		return l.KeyFunc(r)
+	}
+	prefix := l.Prefix
+	if prefix == "" {
+		prefix = DefaultHeaderPrefix
+	}
+	if login := HeaderValue(r.Header, headerKey(prefix, "authn-login")); login != "" {
+		return "login:" + login
+	}
+	if dn := HeaderValue(r.Header, headerKey(prefix, "authn-dn")); dn != "" {
+		return "dn:" + dn
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware returns HTTP handler which rejects requests exceeding rate limit
+// with 429 status code and Retry-After header. It should be chained after
+// CMSAuth Middleware, i.e. when cms-authn headers are verified.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(l.Key(r)); !ok {
+			recordAuthFailure(ErrRateLimited.Reason)
+			secs := int64(math.Ceil(wait.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+			code := http.StatusTooManyRequests
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
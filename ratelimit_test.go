@@ -0,0 +1,80 @@
+package cmsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRateLimiter function
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.Allow("user")
+		assert.True(t, ok)
+	}
+	ok, wait := limiter.Allow("user")
+	assert.False(t, ok)
+	assert.Equal(t, wait, 500*time.Millisecond)
+	// other identities have their own buckets
+	ok, _ = limiter.Allow("other")
+	assert.True(t, ok)
+	// tokens are refilled with given rate
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		ok, _ = limiter.Allow("user")
+		assert.True(t, ok)
+	}
+	ok, _ = limiter.Allow("user")
+	assert.False(t, ok)
+
+	// idle buckets are removed
+	maxBuckets := MaxRateLimitBuckets
+	defer func() { MaxRateLimitBuckets = maxBuckets }()
+	MaxRateLimitBuckets = 2
+	now = now.Add(time.Hour)
+	limiter.Allow("new")
+	assert.Equal(t, len(limiter.buckets), 1)
+
+	// zero rate disables limit
+	limiter = NewRateLimiter(0, 1)
+	for i := 0; i < 10; i++ {
+		ok, _ = limiter.Allow("user")
+		assert.True(t, ok)
+	}
+}
+
+// TestRateLimiterMiddleware function
+func TestRateLimiterMiddleware(t *testing.T) {
+	limiter := NewRateLimiter(0.1, 1)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(login, dn string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/path", nil)
+		if login != "" {
+			r.Header.Set("cms-authn-login", login)
+		}
+		if dn != "" {
+			r.Header.Set("cms-authn-dn", dn)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, request("user", "").Code, http.StatusOK)
+	w := request("user", "")
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, w.Header().Get("Retry-After"), "10")
+	assert.Equal(t, request("", "/DC=ch/CN=user").Code, http.StatusOK)
+	assert.Equal(t, request("", "/DC=ch/CN=user").Code, http.StatusTooManyRequests)
+	// unauthenticated requests are keyed by client address
+	assert.Equal(t, request("", "").Code, http.StatusOK)
+	assert.Equal(t, request("", "").Code, http.StatusTooManyRequests)
+	assert.Equal(t, request("other", "").Code, http.StatusOK)
+}
@@ -0,0 +1,92 @@
+package cmsauth
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResponseClaimPrefix defines prefix of response headers signed by SignResponse
+var ResponseClaimPrefix = "cms-response-"
+
+// SignResponse sets given claims as response headers with ResponseClaimPrefix,
+// e.g. quota decision {"quota": "exceeded"} is set as cms-response-quota
+// header, and signs them with CMSAuth HMAC key, so downstream proxies sharing
+// the key can verify claims with VerifyResponse. The signature is set in
+// <prefix>hmac header along with <prefix>timestamp header which is signed as
+// well. It should be called before response status is written.
+func (a *CMSAuth) SignResponse(w http.ResponseWriter, claims map[string]string) error {
+	hkey := a.key()
+	if len(hkey) == 0 {
+		return errors.New("CMSAuth HMAC key is not initialized")
+	}
+	prefix := strings.ToLower(ResponseClaimPrefix)
+	signed := make(map[string]string)
+	for k, v := range claims {
+		key := prefix + strings.ToLower(k)
+		if key == prefix+"hmac" || key == prefix+"timestamp" {
+			return fmt.Errorf("response claim %s is reserved", k)
+		}
+		w.Header().Set(key, v)
+		signed[key] = a.canonicalValue(v)
+	}
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	w.Header().Set(prefix+"timestamp", timestamp)
+	signed[prefix+"timestamp"] = timestamp
+	val := CanonicalSignString(signed)
+	w.Header().Set(prefix+"hmac", hmacDigest(a.signingScheme(), hkey, val))
+	return nil
+}
+
+// VerifyResponse verifies response headers signed by SignResponse and returns
+// signed claims with ResponseClaimPrefix removed from their keys. The
+// signature is accepted if it is produced by any of verification keys, e.g.
+// previous key during key rotation, and signing timestamp is within
+// TimestampSkew.
+func (a *CMSAuth) VerifyResponse(header http.Header) (map[string]string, error) {
+	prefix := strings.ToLower(ResponseClaimPrefix)
+	signature := HeaderValue(header, prefix+"hmac")
+	if signature == "" {
+		return nil, authErrorf(ErrHmacMismatch, "missing %shmac header", prefix)
+	}
+	if !a.validTimestamp(HeaderValue(header, prefix+"timestamp")) {
+		return nil, ErrInvalidTimestamp
+	}
+	// header keys are sorted to sign values of keys which differ only by
+	// case in deterministic order
+	var keys []string
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	signed := make(map[string][]string)
+	claims := make(map[string]string)
+	for _, k := range keys {
+		vals := header[k]
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, prefix) || key == prefix+"hmac" {
+			continue
+		}
+		signed[key] = append(signed[key], a.signedValues(vals)...)
+		if key != prefix+"timestamp" {
+			claims[strings.TrimPrefix(key, prefix)] = strings.Join(vals, ",")
+		}
+	}
+	val := canonicalSignValues(signed)
+	for _, nkey := range a.verificationKeys() {
+		if len(nkey.key) == 0 {
+			continue
+		}
+		for _, scheme := range a.acceptedSchemes() {
+			expect := hmacDigest(scheme, nkey.key, val)
+			if hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))) {
+				return claims, nil
+			}
+		}
+	}
+	return nil, ErrHmacMismatch
+}
@@ -0,0 +1,61 @@
+package cmsauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignResponse function
+func TestSignResponse(t *testing.T) {
+	cmsAuth := initCMSAuth(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := cmsAuth.SignResponse(w, map[string]string{"Quota": "exceeded", "limit": "100"})
+		assert.Nil(t, err)
+		w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.Header.Get("cms-response-quota"), "exceeded")
+
+	claims, err := cmsAuth.VerifyResponse(resp.Header)
+	assert.Nil(t, err)
+	assert.Equal(t, claims, map[string]string{"quota": "exceeded", "limit": "100"})
+
+	// tampered, injected and missing claims are rejected
+	header := resp.Header.Clone()
+	header.Set("cms-response-quota", "ok")
+	_, err = cmsAuth.VerifyResponse(header)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+	header = resp.Header.Clone()
+	header.Set("cms-response-admin", "true")
+	_, err = cmsAuth.VerifyResponse(header)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+	header = resp.Header.Clone()
+	header.Del("cms-response-limit")
+	_, err = cmsAuth.VerifyResponse(header)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+	header = resp.Header.Clone()
+	header.Del("cms-response-hmac")
+	_, err = cmsAuth.VerifyResponse(header)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+	header = resp.Header.Clone()
+	header.Set("cms-response-timestamp", fmt.Sprintf("%d", time.Now().Add(-time.Hour).Unix()))
+	_, err = cmsAuth.VerifyResponse(header)
+	assert.ErrorIs(t, err, ErrInvalidTimestamp)
+
+	// response signed with other key is rejected
+	_, err = (&CMSAuth{}).VerifyResponse(resp.Header)
+	assert.ErrorIs(t, err, ErrHmacMismatch)
+
+	w := httptest.NewRecorder()
+	assert.NotNil(t, cmsAuth.SignResponse(w, map[string]string{"hmac": "value"}))
+	assert.NotNil(t, (&CMSAuth{}).SignResponse(w, nil))
+}
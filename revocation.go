@@ -0,0 +1,213 @@
+package cmsauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRevocationInterval defines default interval to refresh token
+// revocation list
+var DefaultRevocationInterval = time.Minute
+
+// MaxRevocationListSize defines maximum size of token revocation list
+var MaxRevocationListSize int64 = 10 * 1024 * 1024
+
+// RevocationList keeps deny-list of compromised tokens which are rejected by
+// TokenManager even if they are otherwise valid. The list is periodically
+// loaded from a file or HTTP(S) URL and revokes tokens either by token ID
+// (jti claim) or by subject (sub claim). It is provided either as JSON object
+// {"jti": ["id1"], "sub": ["subject1"]} or as plain text with one jti:<id> or
+// sub:<subject> entry per line, where empty lines and lines starting with #
+// are ignored.
+type RevocationList struct {
+	Source   string        // file name or HTTP(S) URL of revocation list
+	Interval time.Duration // refresh interval
+	Verbose  bool          // verbose mode
+
+	mutex    sync.RWMutex
+	jtis     map[string]bool
+	subjects map[string]bool
+	updated  time.Time
+}
+
+// revocationListJSON represents JSON form of token revocation list
+type revocationListJSON struct {
+	JTI []string `json:"jti"` // revoked token IDs
+	Sub []string `json:"sub"` // revoked token subjects
+}
+
+// NewRevocationList creates new instance of RevocationList
+func NewRevocationList(source string, interval time.Duration) *RevocationList {
+	if interval <= 0 {
+		interval = DefaultRevocationInterval
+	}
+	return &RevocationList{Source: source, Interval: interval}
+}
+
+// Refresh loads revocation list from its source and swaps it with current
+// one. On error the current list is kept.
+func (l *RevocationList) Refresh() error {
+	data, err := l.read()
+	if err != nil {
+		recordRevocationError()
+		return err
+	}
+	jtis, subjects, err := parseRevocationList(data)
+	if err != nil {
+		recordRevocationError()
+		return fmt.Errorf("invalid revocation list %s: %v", l.Source, err)
+	}
+	l.mutex.Lock()
+	l.jtis = jtis
+	l.subjects = subjects
+	l.updated = time.Now()
+	l.mutex.Unlock()
+	recordRevocationRefresh(len(jtis) + len(subjects))
+	if l.Verbose {
+		GetLogger().Infof("loaded %d revoked token IDs and %d revoked subjects from %s", len(jtis), len(subjects), l.Source)
+	}
+	return nil
+}
+
+// helper function to read revocation list from file or URL
+func (l *RevocationList) read() ([]byte, error) {
+	if !strings.HasPrefix(l.Source, "http://") && !strings.HasPrefix(l.Source, "https://") {
+		file, err := os.Open(l.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readLimited(file, l.Source)
+	}
+	client, err := NewHttpClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCricTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", l.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch revocation list from %s, status %s", l.Source, resp.Status)
+	}
+	return readLimited(resp.Body, l.Source)
+}
+
+// helper function to read data up to MaxRevocationListSize
+func readLimited(r io.Reader, source string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxRevocationListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxRevocationListSize {
+		return nil, fmt.Errorf("revocation list %s exceeds %d bytes", source, MaxRevocationListSize)
+	}
+	return data, nil
+}
+
+// helper function to parse revocation list in JSON or plain text form
+func parseRevocationList(data []byte) (map[string]bool, map[string]bool, error) {
+	jtis := make(map[string]bool)
+	subjects := make(map[string]bool)
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("{")) {
+		var list revocationListJSON
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, nil, err
+		}
+		for _, jti := range list.JTI {
+			jtis[jti] = true
+		}
+		for _, sub := range list.Sub {
+			subjects[sub] = true
+		}
+		return jtis, subjects, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, ok := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, nil, fmt.Errorf("invalid entry '%s'", line)
+		}
+		switch strings.TrimSpace(kind) {
+		case "jti":
+			jtis[value] = true
+		case "sub":
+			subjects[value] = true
+		default:
+			return nil, nil, fmt.Errorf("unknown entry type '%s'", kind)
+		}
+	}
+	return jtis, subjects, scanner.Err()
+}
+
+// Run refreshes revocation list every Interval until given context is done.
+// It performs initial refresh immediately and should be run in a goroutine.
+func (l *RevocationList) Run(ctx context.Context) {
+	l.refresh()
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.refresh()
+		}
+	}
+}
+
+// helper function to refresh revocation list and log errors
+func (l *RevocationList) refresh() {
+	if err := l.Refresh(); err != nil {
+		GetLogger().Warnf("unable to refresh token revocation list from %s, error %v", l.Source, err)
+	}
+}
+
+// Revoked checks if token with given claims is revoked and returns the claim
+// which revoked it, i.e. jti or sub
+func (l *RevocationList) Revoked(claims map[string]interface{}) (string, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if jti, ok := claims["jti"].(string); ok && l.jtis[jti] {
+		return "jti", true
+	}
+	if sub, ok := claims["sub"].(string); ok && l.subjects[sub] {
+		return "sub", true
+	}
+	return "", false
+}
+
+// Updated returns time of the last successful refresh
+func (l *RevocationList) Updated() time.Time {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.updated
+}
+
+// Size returns number of revoked token IDs and subjects
+func (l *RevocationList) Size() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return len(l.jtis) + len(l.subjects)
+}
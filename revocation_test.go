@@ -0,0 +1,99 @@
+package cmsauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRevocationList function
+func TestRevocationList(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "revoked.txt")
+	data := "# compromised tokens\njti: abc\n\nsub:user1\n"
+	assert.Nil(t, os.WriteFile(fname, []byte(data), 0600))
+	list := NewRevocationList(fname, 0)
+	assert.Equal(t, list.Interval, DefaultRevocationInterval)
+	assert.Nil(t, list.Refresh())
+	assert.Equal(t, list.Size(), 2)
+	assert.Equal(t, list.Updated().IsZero(), false)
+	claim, ok := list.Revoked(map[string]interface{}{"jti": "abc", "sub": "user2"})
+	assert.Equal(t, ok, true)
+	assert.Equal(t, claim, "jti")
+	claim, ok = list.Revoked(map[string]interface{}{"jti": "xyz", "sub": "user1"})
+	assert.Equal(t, ok, true)
+	assert.Equal(t, claim, "sub")
+	_, ok = list.Revoked(map[string]interface{}{"jti": "xyz", "sub": "user2"})
+	assert.Equal(t, ok, false)
+
+	// invalid list keeps previous one
+	errors := authMetrics.revokeErrors.Load()
+	assert.Nil(t, os.WriteFile(fname, []byte("kid:abc\n"), 0600))
+	assert.NotNil(t, list.Refresh())
+	assert.Equal(t, list.Size(), 2)
+	assert.Equal(t, authMetrics.revokeErrors.Load()-errors, uint64(1))
+
+	// JSON list provided by URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jti": ["id1", "id2"], "sub": []}`))
+	}))
+	defer server.Close()
+	list = NewRevocationList(server.URL, time.Minute)
+	assert.Nil(t, list.Refresh())
+	assert.Equal(t, list.Size(), 2)
+	_, ok = list.Revoked(map[string]interface{}{"jti": "id2"})
+	assert.Equal(t, ok, true)
+	var buf bytes.Buffer
+	WriteMetrics(&buf)
+	assert.Contains(t, buf.String(), "cmsauth_revocation_list_entries 2")
+}
+
+// TestTokenRevocation function
+func TestTokenRevocation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	server := testJWKSServer(t, key, "kid1")
+	fname := filepath.Join(t.TempDir(), "revoked.txt")
+	assert.Nil(t, os.WriteFile(fname, []byte("jti:other\n"), 0600))
+	mgr := NewTokenManager(server.URL, "https://issuer", "cms")
+	mgr.CacheSize = 10
+	mgr.Revocations = NewRevocationList(fname, time.Minute)
+	assert.Nil(t, mgr.Revocations.Refresh())
+
+	claims := func(jti, sub string) map[string]interface{} {
+		exp := time.Now().Add(time.Hour).Unix()
+		return map[string]interface{}{"iss": "https://issuer", "aud": "cms", "jti": jti, "sub": sub, "exp": exp}
+	}
+	token1 := signTestToken(t, key, "kid1", claims("id1", "user1"))
+	token2 := signTestToken(t, key, "kid1", claims("id2", "user2"))
+	_, err = mgr.Validate(token1)
+	assert.Nil(t, err)
+	_, err = mgr.Validate(token2)
+	assert.Nil(t, err)
+
+	// cached tokens are rejected after revocation
+	assert.Nil(t, os.WriteFile(fname, []byte("jti:id1\nsub:user2\n"), 0600))
+	assert.Nil(t, mgr.Revocations.Refresh())
+	revoked := authMetrics.revokedTokens["jti"]
+	_, err = mgr.Validate(token1)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	assert.Equal(t, ErrorStatus(err), http.StatusUnauthorized)
+	assert.Equal(t, authMetrics.revokedTokens["jti"]-revoked, uint64(1))
+	_, err = mgr.Validate(token2)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	// tokens are rejected without cache as well
+	mgr.CacheSize = 0
+	_, err = mgr.Validate(token1)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	token3 := signTestToken(t, key, "kid1", claims("id3", "user3"))
+	_, err = mgr.Validate(token3)
+	assert.Nil(t, err)
+}
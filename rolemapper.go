@@ -0,0 +1,139 @@
+package cmsauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RoleMapping maps CRIC role and group into service role
+type RoleMapping struct {
+	Role  string `json:"role"`  // CRIC role, empty matches any role
+	Group string `json:"group"` // CRIC group or site, trailing * matches prefix, e.g. group:cms-*
+	To    string `json:"to"`    // service role, e.g. admin
+}
+
+// RoleMapper translates CRIC roles and groups into service specific roles,
+// so services do not need to hardcode CRIC group names. The mapped roles are
+// added to original ones, i.e. original cms-authz headers are kept.
+type RoleMapper struct {
+	// Aliases maps service role into list of CRIC roles, e.g.
+	// "admin": ["global-admin", "web-service-admin"], the groups of CRIC
+	// roles are assigned to service role
+	Aliases map[string][]string `json:"aliases"`
+	// Mappings maps CRIC role and group into service role
+	Mappings []RoleMapping `json:"mappings"`
+}
+
+// NewRoleMapper creates RoleMapper from given JSON file, e.g.
+// {"aliases": {"admin": ["global-admin"]},
+// "mappings": [{"role": "operator", "group": "group:cms-ops", "to": "admin"}]}
+func NewRoleMapper(fname string) (*RoleMapper, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var mapper RoleMapper
+	if err := json.Unmarshal(data, &mapper); err != nil {
+		return nil, fmt.Errorf("unable to parse role mapping %s: %v", fname, err)
+	}
+	for _, m := range mapper.Mappings {
+		if m.To == "" || (m.Role == "" && m.Group == "") {
+			return nil, fmt.Errorf("invalid role mapping %+v in %s", m, fname)
+		}
+	}
+	return &mapper, nil
+}
+
+// Map returns given roles along with mapped service roles, role names are
+// normalized as in cms-authz headers
+func (m *RoleMapper) Map(roles map[string][]string) map[string][]string {
+	out := make(map[string][]string)
+	add := func(role, val string) {
+		role = normalizeRole(role)
+		for _, v := range out[role] {
+			if v == val {
+				return
+			}
+		}
+		out[role] = append(out[role], val)
+	}
+	var names []string
+	for role := range roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		for _, val := range roles[role] {
+			for _, v := range strings.Fields(val) {
+				add(role, v)
+			}
+		}
+	}
+	for to, aliases := range m.Aliases {
+		for _, alias := range aliases {
+			for _, val := range out[normalizeRole(alias)] {
+				add(to, val)
+			}
+		}
+	}
+	for _, mapping := range m.Mappings {
+		for _, role := range names {
+			if mapping.Role != "" && normalizeRole(mapping.Role) != normalizeRole(role) {
+				continue
+			}
+			for _, val := range roles[role] {
+				for _, v := range strings.Fields(val) {
+					if mapping.Group == "" || matchGroup(mapping.Group, v) {
+						add(mapping.To, v)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// helper function to match group or site against pattern with optional
+// trailing wildcard
+func matchGroup(pattern, val string) bool {
+	pattern = strings.ToLower(pattern)
+	val = strings.ToLower(val)
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(val, prefix)
+	}
+	return pattern == val
+}
+
+// helper function to return header with authz headers of given prefix
+// replaced by headers of mapped roles
+func (m *RoleMapper) mapHeader(header http.Header, prefix string) http.Header {
+	out := make(http.Header)
+	for key, vals := range header {
+		if !strings.HasPrefix(strings.ToLower(key), headerKey(prefix, "authz-")) {
+			out[key] = vals
+		}
+	}
+	for role, vals := range m.Map(userFromHeader(header, prefix).Roles) {
+		out[roleHeaderKey(prefix, role)] = []string{strings.Join(vals, " ")}
+	}
+	return out
+}
+
+// SetRoleMapper sets RoleMapper applied to CRIC roles in SetCMSHeaders and
+// SetSessionHeaders and to cms-authz headers in CheckCMSAuthz, nil disables
+// role mapping
+func (a *CMSAuth) SetRoleMapper(mapper *RoleMapper) {
+	a.roleMapper = mapper
+}
+
+// helper function to return CRIC roles mapped by RoleMapper
+func (a *CMSAuth) mappedRoles(roles map[string][]string) map[string][]string {
+	if a.roleMapper == nil {
+		return roles
+	}
+	return a.roleMapper.Map(roles)
+}
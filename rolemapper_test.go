@@ -0,0 +1,67 @@
+package cmsauth
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRoleMapper function
+func TestRoleMapper(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "roles.json")
+	data := `{"aliases": {"admin": ["global-admin"]},
+		"mappings": [
+			{"role": "operator", "group": "group:cms-ops", "to": "admin"},
+			{"group": "site:T1_*", "to": "tier1"}
+		]}`
+	err := os.WriteFile(fname, []byte(data), 0600)
+	assert.Nil(t, err)
+	mapper, err := NewRoleMapper(fname)
+	assert.Nil(t, err)
+
+	roles := mapper.Map(map[string][]string{
+		"operator":     {"group:cms-ops group:dbs"},
+		"Global Admin": {"group:cms"},
+		"production":   {"site:T1_US_FNAL", "site:T2_CH_CERN"},
+	})
+	assert.ElementsMatch(t, roles["admin"], []string{"group:cms", "group:cms-ops"})
+	assert.Equal(t, roles["tier1"], []string{"site:T1_US_FNAL"})
+	assert.Equal(t, roles["operator"], []string{"group:cms-ops", "group:dbs"})
+
+	err = os.WriteFile(fname, []byte(`{"mappings": [{"role": "operator"}]}`), 0600)
+	assert.Nil(t, err)
+	_, err = NewRoleMapper(fname)
+	assert.NotNil(t, err)
+
+	// mapped roles are set by SetCMSHeaders and checked by CheckCMSAuthz
+	cmsAuth := initCMSAuth(t)
+	cmsAuth.SetRoleMapper(mapper)
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user/CN=1/CN=First Last"
+	records := CricRecords{GetSortedDN(dn): CricEntry{
+		DN:    dn,
+		Login: "user",
+		Roles: map[string][]string{"operator": {"group:cms-ops"}},
+	}}
+	r, _ := http.NewRequest("GET", "/path", nil)
+	userData := map[string]interface{}{"cern_upn": "user", "dn": dn, "exp": 1}
+	cmsAuth.SetCMSHeaders(r, userData, records, false)
+	assert.Equal(t, r.Header.Get("cms-authz-admin"), "group:cms-ops")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(r.Header), true)
+
+	// session roles are mapped in the same way
+	s := &Session{Login: "user", DN: dn, Roles: records[GetSortedDN(dn)].Roles, Expire: 1}
+	r, _ = http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetSessionHeaders(r, s, false)
+	assert.Equal(t, r.Header.Get("cms-authz-admin"), "group:cms-ops")
+	assert.Equal(t, r.Header.Get("cms-authz-operator"), "group:cms-ops")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(r.Header), true)
+
+	header := make(http.Header)
+	header.Set("cms-authz-global-admin", "group:cms")
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(header, "admin", "group:cms", ""), true)
+	cmsAuth.SetRoleMapper(nil)
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(header, "admin", "group:cms", ""), false)
+}
@@ -0,0 +1,124 @@
+package cmsauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceTokenRefresh defines how long before expiration service token is
+// refreshed by ServiceTokenSource
+var ServiceTokenRefresh = time.Minute
+
+// MaxServiceTokenSize defines maximum size of service token response
+var MaxServiceTokenSize int64 = 1024 * 1024
+
+// ServiceToken represents access token obtained via OAuth2 client credentials
+// flow
+type ServiceToken struct {
+	AccessToken string    // access token
+	TokenType   string    // token type, e.g. Bearer
+	Expiry      time.Time // token expiration time, zero if not provided
+}
+
+// helper function to check if token should be refreshed
+func (t *ServiceToken) expired() bool {
+	if t == nil || t.AccessToken == "" {
+		return true
+	}
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return time.Now().Add(ServiceTokenRefresh).After(t.Expiry)
+}
+
+// GetServiceToken obtains access token from given issuer token endpoint, e.g.
+// https://auth.cern.ch/auth/realms/cern/protocol/openid-connect/token,
+// using OAuth2 client credentials flow with given client ID, secret and scopes
+func GetServiceToken(ctx context.Context, issuerURL, clientID, secret string, scopes []string) (*ServiceToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", issuerURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client credentials are used instead of X509 certs
+	c := defaultClient()
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := c.tlsConfig(false)
+	if err != nil {
+		return nil, err
+	}
+	tr.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: tr, Timeout: time.Duration(c.Config.Timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxServiceTokenSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxServiceTokenSize {
+		return nil, fmt.Errorf("service token response from %s exceeds %d bytes", issuerURL, MaxServiceTokenSize)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to obtain service token from %s, status %s", issuerURL, resp.Status)
+	}
+	var rec struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("unable to parse service token response: %v", err)
+	}
+	if rec.AccessToken == "" {
+		return nil, errors.New("service token response has no access token")
+	}
+	token := &ServiceToken{AccessToken: rec.AccessToken, TokenType: rec.TokenType}
+	if rec.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(rec.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// ServiceTokenSource returns TokenSource which obtains service token via
+// GetServiceToken and caches it until ServiceTokenRefresh before expiration.
+// It can be used with WithTokenSource option of HttpClientWithOptions.
+func ServiceTokenSource(issuerURL, clientID, secret string, scopes []string) TokenSource {
+	var mutex sync.Mutex
+	var token *ServiceToken
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if token.expired() {
+			t, err := GetServiceToken(context.Background(), issuerURL, clientID, secret, scopes)
+			if err != nil {
+				return "", err
+			}
+			token = t
+		}
+		return token.AccessToken, nil
+	}
+}
+
+// WithServiceToken sets service token obtained via client credentials flow
+// as bearer token of every request, see ServiceTokenSource
+func WithServiceToken(issuerURL, clientID, secret string, scopes []string) Option {
+	return WithTokenSource(ServiceTokenSource(issuerURL, clientID, secret, scopes))
+}
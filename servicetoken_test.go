@@ -0,0 +1,82 @@
+package cmsauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to create test token endpoint
+func testTokenServer(t *testing.T, expiresIn int64) (*httptest.Server, *int) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		calls++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token" + r.FormValue("scope"),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// TestGetServiceToken function
+func TestGetServiceToken(t *testing.T) {
+	server, _ := testTokenServer(t, 300)
+	token, err := GetServiceToken(context.Background(), server.URL, "client", "secret", []string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, token.AccessToken, "tokena b")
+	assert.Equal(t, token.TokenType, "Bearer")
+	assert.Greater(t, time.Until(token.Expiry), 4*time.Minute)
+
+	_, err = GetServiceToken(context.Background(), server.URL, "client", "wrong", nil)
+	assert.NotNil(t, err)
+
+	// responses exceeding MaxServiceTokenSize are rejected
+	maxSize := MaxServiceTokenSize
+	MaxServiceTokenSize = 16
+	defer func() { MaxServiceTokenSize = maxSize }()
+	_, err = GetServiceToken(context.Background(), server.URL, "client", "secret", nil)
+	assert.NotNil(t, err)
+}
+
+// TestServiceTokenSource function
+func TestServiceTokenSource(t *testing.T) {
+	server, calls := testTokenServer(t, 300)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	client := HttpClientWithOptions(WithServiceToken(server.URL, "client", "secret", []string{"read"}))
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(api.URL)
+		assert.Nil(t, err)
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, string(data), "Bearer tokenread")
+	}
+	assert.Equal(t, *calls, 1)
+
+	// tokens expiring within ServiceTokenRefresh are refreshed
+	server, calls = testTokenServer(t, 30)
+	src := ServiceTokenSource(server.URL, "client", "secret", nil)
+	for i := 0; i < 2; i++ {
+		token, err := src()
+		assert.Nil(t, err)
+		assert.Equal(t, token, "token")
+	}
+	assert.Equal(t, *calls, 2)
+}
@@ -0,0 +1,184 @@
+package cmsauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultSessionCookie defines default name of session cookie
+var DefaultSessionCookie = "cms-session"
+
+// DefaultSessionTTL defines default lifetime of sessions
+var DefaultSessionTTL = 8 * time.Hour
+
+// Session represents authenticated user session stored in signed cookie
+type Session struct {
+	Login  string              `json:"login"`  // user login
+	Name   string              `json:"name"`   // user name
+	DN     string              `json:"dn"`     // user DN
+	Roles  map[string][]string `json:"roles"`  // user roles and their groups/sites
+	Method string              `json:"method"` // original authentication method, e.g. X509Cert
+	Expire int64               `json:"exp"`    // session expiration as unix time
+}
+
+// SessionManager issues and verifies HMAC signed session cookies. It allows
+// browser clients authenticated via OIDC or X509 to skip token validation and
+// CRIC lookup on subsequent requests, see CMSAuth.SetSessionHeaders.
+type SessionManager struct {
+	CookieName string        // cookie name, default is DefaultSessionCookie
+	Path       string        // cookie path, default is /
+	Domain     string        // cookie domain
+	Secure     bool          // send cookie only over HTTPS
+	TTL        time.Duration // session lifetime, default is DefaultSessionTTL
+	key        []byte
+}
+
+// NewSessionManager creates SessionManager which signs sessions with given key
+func NewSessionManager(key []byte, ttl time.Duration) *SessionManager {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &SessionManager{CookieName: DefaultSessionCookie, Path: "/", Secure: true, TTL: ttl, key: key}
+}
+
+// NewSession creates session for given authenticated user
+func (m *SessionManager) NewSession(user User, method string) *Session {
+	return &Session{
+		Login:  user.Login,
+		Name:   user.Name,
+		DN:     user.DN,
+		Roles:  user.Roles,
+		Method: method,
+		Expire: time.Now().Add(m.TTL).Unix(),
+	}
+}
+
+// Encode returns signed representation of given session
+func (m *SessionManager) Encode(s *Session) (string, error) {
+	if len(m.key) == 0 {
+		return "", errors.New("session key is not set")
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + m.sign(payload), nil
+}
+
+// Decode verifies signature and expiration of given signed session
+func (m *SessionManager) Decode(val string) (*Session, error) {
+	if len(m.key) == 0 {
+		return nil, errors.New("session key is not set")
+	}
+	payload, sig, ok := strings.Cut(val, ".")
+	if !ok {
+		return nil, errors.New("malformed session")
+	}
+	if !hmac.Equal([]byte(sig), []byte(m.sign(payload))) {
+		return nil, errors.New("invalid session signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session: %v", err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid session: %v", err)
+	}
+	if time.Now().Unix() > s.Expire {
+		return nil, errors.New("session is expired")
+	}
+	return &s, nil
+}
+
+// helper function to compute signature of session payload
+func (m *SessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue sets signed session cookie in given HTTP response
+func (m *SessionManager) Issue(w http.ResponseWriter, s *Session) error {
+	val, err := m.Encode(s)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, m.cookie(val, time.Unix(s.Expire, 0)))
+	return nil
+}
+
+// Verify returns session from session cookie of given HTTP request
+func (m *SessionManager) Verify(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(m.cookieName())
+	if err != nil {
+		return nil, err
+	}
+	return m.Decode(c.Value)
+}
+
+// Clear removes session cookie, e.g. on logout
+func (m *SessionManager) Clear(w http.ResponseWriter) {
+	c := m.cookie("", time.Unix(0, 0))
+	c.MaxAge = -1
+	http.SetCookie(w, c)
+}
+
+// helper function to return session cookie name
+func (m *SessionManager) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultSessionCookie
+	}
+	return m.CookieName
+}
+
+// helper function to create session cookie
+func (m *SessionManager) cookie(val string, expire time.Time) *http.Cookie {
+	path := m.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    val,
+		Path:     path,
+		Domain:   m.Domain,
+		Expires:  expire,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// SetSessionHeaders removes existing cms-* headers of given HTTP request and
+// sets CMS headers based on given session, the headers are signed with CMSAuth
+// HMAC key similar to SetCMSHeaders. Session roles are mapped by configured
+// RoleMapper, i.e. sessions keep roles as provided by CRIC or VOMS
+func (a *CMSAuth) SetSessionHeaders(r *http.Request, s *Session, verbose bool) {
+	a.clearCMSHeaders(r)
+	r.Header.Set(a.HeaderKey("auth-status"), "ok")
+	r.Header.Set(a.HeaderKey("authn-name"), s.Name)
+	r.Header.Set(a.HeaderKey("authn-login"), s.Login)
+	if s.DN != "" {
+		r.Header.Set(a.HeaderKey("authn-dn"), s.DN)
+		r.Header.Set(a.HeaderKey("auth-cert"), s.DN)
+	}
+	for k, v := range a.mappedRoles(s.Roles) {
+		r.Header.Set(a.RoleHeaderKey(k), strings.Join(v, " "))
+	}
+	r.Header.Set(a.HeaderKey("authn-method"), s.Method)
+	r.Header.Set(a.HeaderKey("auth-expire"), fmt.Sprintf("%d", s.Expire))
+	r.Header.Set(a.HeaderKey("request-uri"), r.URL.Path)
+	r.Header.Set(a.HeaderKey("authn-timestamp"), fmt.Sprintf("%d", time.Now().Unix()))
+	if hmac, err := a.GetHmac(r, verbose); err == nil {
+		r.Header.Set(a.HeaderKey("authn-hmac"), hmac)
+	}
+}
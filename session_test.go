@@ -0,0 +1,64 @@
+package cmsauth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSessionManager function
+func TestSessionManager(t *testing.T) {
+	mgr := NewSessionManager([]byte("session-key"), time.Hour)
+	user := User{
+		Login: "user",
+		Name:  "First Last",
+		DN:    "/DC=ch/CN=user",
+		Roles: map[string][]string{"operator": {"group:dbs"}},
+	}
+	rec := httptest.NewRecorder()
+	assert.Nil(t, mgr.Issue(rec, mgr.NewSession(user, "X509Cert")))
+	cookies := rec.Result().Cookies()
+	assert.Equal(t, len(cookies), 1)
+	assert.Equal(t, cookies[0].Name, DefaultSessionCookie)
+	assert.Equal(t, cookies[0].HttpOnly, true)
+
+	r := httptest.NewRequest("GET", "/path", nil)
+	r.AddCookie(cookies[0])
+	s, err := mgr.Verify(r)
+	assert.Nil(t, err)
+	assert.Equal(t, s.Login, "user")
+	assert.Equal(t, s.Roles["operator"], []string{"group:dbs"})
+
+	// session maps to signed CMS headers
+	cmsAuth := initCMSAuth(t)
+	r.Header.Set("cms-authz-admin", "group:injected")
+	cmsAuth.SetSessionHeaders(r, s, false)
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "user")
+	assert.Equal(t, r.Header.Get("cms-authz-operator"), "group:dbs")
+	assert.Equal(t, r.Header.Get("cms-authz-admin"), "")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(r.Header), true)
+	assert.Equal(t, UserFromHeader(r.Header).Roles, user.Roles)
+
+	// tampered, foreign and expired sessions are rejected
+	val, err := mgr.Encode(s)
+	assert.Nil(t, err)
+	_, err = mgr.Decode("x" + val)
+	assert.NotNil(t, err)
+	other := NewSessionManager([]byte("other-key"), time.Hour)
+	_, err = other.Decode(val)
+	assert.NotNil(t, err)
+	s.Expire = time.Now().Add(-time.Minute).Unix()
+	val, _ = mgr.Encode(s)
+	_, err = mgr.Decode(val)
+	assert.NotNil(t, err)
+	_, err = mgr.Verify(httptest.NewRequest("GET", "/path", nil))
+	assert.NotNil(t, err)
+
+	rec = httptest.NewRecorder()
+	mgr.Clear(rec)
+	assert.Equal(t, rec.Result().Cookies()[0].MaxAge, -1)
+	_, err = (&SessionManager{}).Encode(s)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,487 @@
+package cmsauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWK represents JSON Web Key, only RSA keys are supported
+type JWK struct {
+	Kid string `json:"kid"` // key ID
+	Kty string `json:"kty"` // key type
+	Alg string `json:"alg"` // key algorithm
+	Use string `json:"use"` // key usage
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+}
+
+// JWKS represents JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// TokenManager validates RS256 signed JWT bearer tokens, e.g. issued by CERN
+// SSO, using keys obtained from JWKS URL
+type TokenManager struct {
+	JWKSURL  string // JWKS URL, e.g. https://auth.cern.ch/auth/realms/cern/protocol/openid-connect/certs
+	Issuer   string // expected token issuer
+	Audience string // expected token audience
+	Verbose  bool   // verbose mode
+
+	// CacheSize defines maximum number of validated tokens kept in cache,
+	// zero disables the cache
+	CacheSize int
+	// CacheTTL defines maximum time validated token is kept in cache, tokens
+	// are never cached beyond their expiration, zero means token expiration
+	CacheTTL time.Duration
+
+	// Lookups defines places of token in HTTP request used by
+	// ValidateRequest, by default only Authorization header is used
+	Lookups []TokenLookup
+
+	// Revocations defines optional deny-list of compromised tokens, revoked
+	// tokens are rejected with ErrTokenRevoked even if they are cached
+	Revocations *RevocationList
+
+	// KeyRefreshInterval defines minimum interval between JWKS re-fetches
+	// triggered by tokens with unknown key ID, zero means
+	// DefaultKeyRefreshInterval
+	KeyRefreshInterval time.Duration
+
+	mutex     sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchLock sync.Mutex
+	lastFetch time.Time
+	cacheOnce sync.Once
+	cache     *tokenCache
+}
+
+// DefaultKeyRefreshInterval defines default minimum interval between JWKS
+// re-fetches triggered by tokens with unknown key ID
+var DefaultKeyRefreshInterval = time.Minute
+
+// MaxJWKSSize defines maximum size of JWKS response
+var MaxJWKSSize int64 = 1024 * 1024
+
+// NewTokenManager creates new TokenManager, both issuer and audience are
+// required to validate tokens
+func NewTokenManager(jwksURL, issuer, audience string) *TokenManager {
+	return &TokenManager{JWKSURL: jwksURL, Issuer: issuer, Audience: audience}
+}
+
+// FetchKeys fetches RSA public keys from JWKS URL
+func (m *TokenManager) FetchKeys() error {
+	return m.FetchKeysCtx(context.Background())
+}
+
+// FetchKeysCtx fetches RSA public keys from JWKS URL, the request is cancelled
+// when given context is done
+func (m *TokenManager) FetchKeysCtx(ctx context.Context) error {
+	client, err := NewHttpClient()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", m.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch JWKS from %s, status %s", m.JWKSURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxJWKSSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > MaxJWKSSize {
+		return fmt.Errorf("JWKS from %s exceeds %d bytes", m.JWKSURL, MaxJWKSSize)
+	}
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := rsaPublicKey(k)
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = key
+	}
+	m.mutex.Lock()
+	m.keys = keys
+	m.mutex.Unlock()
+	return nil
+}
+
+// helper function to convert JWK into RSA public key
+func rsaPublicKey(k JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus of key %s: %v", k.Kid, err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent of key %s: %v", k.Kid, err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// helper function to return public key for given key ID
+func (m *TokenManager) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	key, ok := m.keys[kid]
+	return key, ok
+}
+
+// helper function to return public key for given key ID, keys are re-fetched
+// if key ID is not known. Concurrent re-fetches are merged into one and keys
+// are re-fetched at most once per KeyRefreshInterval, otherwise unknown key
+// IDs are rejected immediately.
+func (m *TokenManager) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if key, ok := m.lookupKey(kid); ok {
+		return key, nil
+	}
+	m.fetchLock.Lock()
+	defer m.fetchLock.Unlock()
+	// keys could be fetched while waiting for the lock
+	if key, ok := m.lookupKey(kid); ok {
+		return key, nil
+	}
+	interval := m.KeyRefreshInterval
+	if interval <= 0 {
+		interval = DefaultKeyRefreshInterval
+	}
+	if time.Since(m.lastFetch) < interval {
+		return nil, fmt.Errorf("unknown token key ID %s", kid)
+	}
+	last := m.lastFetch
+	m.lastFetch = time.Now()
+	if err := m.FetchKeysCtx(ctx); err != nil {
+		if ctx.Err() != nil {
+			// cancelled requests do not count as re-fetch
+			m.lastFetch = last
+		}
+		return nil, err
+	}
+	key, ok := m.lookupKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown token key ID %s", kid)
+	}
+	return key, nil
+}
+
+// Validate validates given JWT token and returns its claims which can be used
+// as user data in SetCMSHeaders
+func (m *TokenManager) Validate(token string) (map[string]interface{}, error) {
+	return m.ValidateCtx(context.Background(), token)
+}
+
+// ValidateCtx validates given JWT token similar to Validate, fetching of
+// unknown token keys is cancelled when given context is done
+func (m *TokenManager) ValidateCtx(ctx context.Context, token string) (map[string]interface{}, error) {
+	defer func(t time.Time) { recordTokenValidation(time.Since(t)) }(time.Now())
+	if m.CacheSize <= 0 {
+		claims, err := m.validate(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return claims, m.checkRevoked(claims)
+	}
+	m.cacheOnce.Do(func() { m.cache = newTokenCache(m.CacheSize) })
+	if claims, ok := m.cache.get(token); ok {
+		recordTokenCache(true)
+		return claims, m.checkRevoked(claims)
+	}
+	recordTokenCache(false)
+	claims, err := m.validate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkRevoked(claims); err != nil {
+		return nil, err
+	}
+	// validated claims always have expiration
+	expire := time.Unix(int64(claims["exp"].(float64)), 0)
+	if m.CacheTTL > 0 && time.Now().Add(m.CacheTTL).Before(expire) {
+		expire = time.Now().Add(m.CacheTTL)
+	}
+	m.cache.add(token, claims, expire)
+	return claims, nil
+}
+
+// helper function to check token claims against revocation list
+func (m *TokenManager) checkRevoked(claims map[string]interface{}) error {
+	if m.Revocations == nil {
+		return nil
+	}
+	claim, revoked := m.Revocations.Revoked(claims)
+	if !revoked {
+		return nil
+	}
+	recordTokenRevoked(claim)
+	recordAuthFailure(ErrTokenRevoked.Reason)
+	return authErrorf(ErrTokenRevoked, "token %s %v is revoked", claim, claims[claim])
+}
+
+// helper function to validate token signature and claims
+func (m *TokenManager) validate(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported token algorithm %s", header.Alg)
+	}
+	key, err := m.publicKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %v", err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+	claims := make(map[string]interface{})
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %v", err)
+	}
+	if err := m.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// helper function to validate issuer, audience and expiration of token claims
+func (m *TokenManager) validateClaims(claims map[string]interface{}) error {
+	if m.Issuer == "" || m.Audience == "" {
+		return errors.New("token issuer and audience should be configured")
+	}
+	if claims["iss"] != m.Issuer {
+		return fmt.Errorf("invalid token issuer %v", claims["iss"])
+	}
+	if !hasAudience(claims["aud"], m.Audience) {
+		return fmt.Errorf("invalid token audience %v", claims["aud"])
+	}
+	now := time.Now().Unix()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiration")
+	}
+	if int64(exp) < now {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && int64(nbf) > now {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// helper function to check token audience claim
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// helper function to decode base64url encoded JSON token part
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// BearerToken returns bearer token from Authorization header of HTTP request
+func BearerToken(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", errors.New("no bearer token in Authorization header")
+	}
+	return strings.TrimSpace(auth[7:]), nil
+}
+
+// TokenLookup defines place of token in HTTP request
+type TokenLookup struct {
+	Kind string // kind of token place: header, cookie or query
+	Name string // name of header, cookie or query parameter
+}
+
+// DefaultTokenLookups defines default order of token places used by GetToken.
+// Query parameters are not used by default since URLs end up in access logs
+// and Referer headers, they can be enabled via ParseTokenLookups.
+var DefaultTokenLookups = []TokenLookup{
+	{Kind: "header", Name: "Authorization"},
+	{Kind: "header", Name: "X-Forwarded-Access-Token"},
+	{Kind: "cookie", Name: "access_token"},
+}
+
+// ParseTokenLookups parses comma separated list of token places in kind:name
+// form, e.g. "header:Authorization,cookie:token,query:access_token"
+func ParseTokenLookups(spec string) ([]TokenLookup, error) {
+	var lookups []TokenLookup
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, name, ok := strings.Cut(item, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid token lookup %s", item)
+		}
+		switch kind {
+		case "header", "cookie", "query":
+		default:
+			return nil, fmt.Errorf("unsupported token lookup kind %s", kind)
+		}
+		lookups = append(lookups, TokenLookup{Kind: kind, Name: name})
+	}
+	return lookups, nil
+}
+
+// GetToken returns token of HTTP request looking it up in places defined by
+// DefaultTokenLookups, i.e. Authorization bearer token,
+// X-Forwarded-Access-Token header and access_token cookie
+func GetToken(r *http.Request) (string, error) {
+	return GetTokenFrom(r, DefaultTokenLookups...)
+}
+
+// GetTokenFrom returns token of HTTP request from the first of given places
+// which provides it. Header values may carry "Bearer " prefix.
+func GetTokenFrom(r *http.Request, lookups ...TokenLookup) (string, error) {
+	for _, l := range lookups {
+		var token string
+		switch l.Kind {
+		case "header":
+			token = r.Header.Get(l.Name)
+			if len(token) >= 7 && strings.EqualFold(token[:7], "bearer ") {
+				token = token[7:]
+			} else if strings.EqualFold(l.Name, "Authorization") {
+				// other authorization schemes, e.g. Basic, do not carry token
+				token = ""
+			}
+		case "cookie":
+			if c, err := r.Cookie(l.Name); err == nil {
+				token = c.Value
+			}
+		case "query":
+			token = r.URL.Query().Get(l.Name)
+		}
+		if token = strings.TrimSpace(token); token != "" {
+			return token, nil
+		}
+	}
+	return "", errors.New("no token found in HTTP request")
+}
+
+// ValidateRequest validates bearer token of HTTP request and returns its
+// claims. The token is taken from Authorization header or from places
+// defined by Lookups.
+func (m *TokenManager) ValidateRequest(r *http.Request) (map[string]interface{}, error) {
+	var token string
+	var err error
+	if len(m.Lookups) > 0 {
+		token, err = GetTokenFrom(r, m.Lookups...)
+	} else {
+		token, err = BearerToken(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.ValidateCtx(r.Context(), token)
+}
+
+// ScopesFromClaims returns list of scopes from token claims. The scopes are
+// provided either by space separated "scope" claim or by "scp" list claim.
+func ScopesFromClaims(claims map[string]interface{}) []string {
+	var scopes []string
+	switch v := claims["scope"].(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(v)...)
+	case []string:
+		scopes = append(scopes, v...)
+	}
+	switch v := claims["scp"].(type) {
+	case string:
+		scopes = append(scopes, strings.Fields(v)...)
+	case []string:
+		scopes = append(scopes, v...)
+	case []interface{}:
+		for _, s := range v {
+			if scope, ok := s.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// CheckScopes checks that cms-authn-scope header contains all required scopes
+func CheckScopes(headers http.Header, required []string) bool {
+	return checkScopes(headers, DefaultHeaderPrefix, required)
+}
+
+// CheckScopes checks that authn-scope header of configured namespace contains
+// all required scopes
+func (a *CMSAuth) CheckScopes(headers http.Header, required []string) bool {
+	return checkScopes(headers, a.headerPrefix(), required)
+}
+
+// helper function to check scopes of authn-scope header of given prefix
+func checkScopes(headers http.Header, prefix string, required []string) bool {
+	scopes := make(map[string]bool)
+	for _, v := range lookupHeader(headers, headerKey(prefix, "authn-scope")) {
+		for _, scope := range strings.Fields(v) {
+			scopes[scope] = true
+		}
+	}
+	for _, scope := range required {
+		if !scopes[scope] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,273 @@
+package cmsauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to sign JWT token with given RSA key
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(data))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	assert.Nil(t, err)
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// helper function to start JWKS server for given RSA key
+func testJWKSServer(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	jwks := JWKS{Keys: []JWK{{
+		Kid: kid,
+		Kty: "RSA",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(jwks)
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestTokenManager function
+func TestTokenManager(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	server := testJWKSServer(t, key, "kid1")
+	issuer := "https://auth.cern.ch/auth/realms/cern"
+	mgr := NewTokenManager(server.URL, issuer, "cms")
+
+	claims := map[string]interface{}{
+		"iss":      issuer,
+		"aud":      []string{"cms", "other"},
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"name":     "First Last",
+		"cern_upn": "user",
+	}
+	token := signTestToken(t, key, "kid1", claims)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	userData, err := mgr.ValidateRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, userData["cern_upn"], "user")
+
+	var cmsAuth CMSAuth
+	cmsAuth.SetCMSHeaders(r, userData, CricRecords{}, false)
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "user")
+
+	// invalid tokens
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	_, err = mgr.Validate(signTestToken(t, key, "kid1", claims))
+	assert.NotNil(t, err)
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["aud"] = "other"
+	_, err = mgr.Validate(signTestToken(t, key, "kid1", claims))
+	assert.NotNil(t, err)
+	claims["aud"] = "cms"
+	claims["iss"] = "https://other"
+	_, err = mgr.Validate(signTestToken(t, key, "kid1", claims))
+	assert.NotNil(t, err)
+	claims["iss"] = issuer
+	_, err = mgr.Validate(signTestToken(t, key, "kid2", claims))
+	assert.NotNil(t, err)
+	// issuer and audience are required
+	_, err = NewTokenManager(server.URL, "", "").Validate(token)
+	assert.NotNil(t, err)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err = mgr.Validate(signTestToken(t, otherKey, "kid1", claims))
+	assert.NotNil(t, err)
+	_, err = mgr.Validate("abc")
+	assert.NotNil(t, err)
+	r.Header.Del("Authorization")
+	_, err = mgr.ValidateRequest(r)
+	assert.NotNil(t, err)
+
+	// token provided by cookie
+	mgr.Lookups = []TokenLookup{{Kind: "cookie", Name: "token"}}
+	r.AddCookie(&http.Cookie{Name: "token", Value: token})
+	userData, err = mgr.ValidateRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, userData["cern_upn"], "user")
+}
+
+// TestGetToken function
+func TestGetToken(t *testing.T) {
+	tests := []struct {
+		setup  func(r *http.Request)
+		token  string
+		hasErr bool
+	}{
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc") }, "abc", false},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Basic dXNlcjpwYXNz") }, "", true},
+		{func(r *http.Request) { r.Header.Set("X-Forwarded-Access-Token", "fwd") }, "fwd", false},
+		{func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "access_token", Value: "cookie"}) }, "cookie", false},
+		{func(r *http.Request) { r.URL.RawQuery = "access_token=query" }, "", true},
+		{func(r *http.Request) {
+			r.URL.RawQuery = "access_token=query"
+			r.Header.Set("Authorization", "Bearer abc")
+		}, "abc", false},
+		{func(r *http.Request) {}, "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/path", nil)
+		tt.setup(r)
+		token, err := GetToken(r)
+		assert.Equal(t, token, tt.token)
+		assert.Equal(t, err != nil, tt.hasErr)
+	}
+
+	// custom order
+	lookups, err := ParseTokenLookups("query:token, header:X-Token")
+	assert.Nil(t, err)
+	assert.Equal(t, lookups, []TokenLookup{{Kind: "query", Name: "token"}, {Kind: "header", Name: "X-Token"}})
+	r := httptest.NewRequest("GET", "/path?token=query", nil)
+	r.Header.Set("X-Token", "header")
+	token, err := GetTokenFrom(r, lookups...)
+	assert.Nil(t, err)
+	assert.Equal(t, token, "query")
+	_, err = ParseTokenLookups("body:token")
+	assert.NotNil(t, err)
+	_, err = ParseTokenLookups("header")
+	assert.NotNil(t, err)
+}
+
+// TestCheckScopes function
+func TestCheckScopes(t *testing.T) {
+	claims := map[string]interface{}{
+		"cern_upn": "user",
+		"scope":    "openid compute.read",
+		"scp":      []interface{}{"compute.modify"},
+	}
+	assert.Equal(t, ScopesFromClaims(claims), []string{"openid", "compute.read", "compute.modify"})
+
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	cmsAuth.SetCMSHeaders(r, claims, CricRecords{}, false)
+	assert.Equal(t, CheckScopes(r.Header, []string{"compute.read", "compute.modify"}), true)
+	assert.Equal(t, CheckScopes(r.Header, []string{"compute.create"}), false)
+	assert.Equal(t, CheckScopes(r.Header, nil), true)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(lowerHeaders(r.Header)), true)
+
+	// scope header is signed
+	header := lowerHeaders(r.Header)
+	header["cms-authn-scope"] = []string{"compute.create"}
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), false)
+}
+
+// TestValidateCtx function
+func TestValidateCtx(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	server := testJWKSServer(t, key, "kid1")
+	mgr := NewTokenManager(server.URL, "https://issuer", "cms")
+	claims := map[string]interface{}{"iss": "https://issuer", "aud": "cms", "exp": time.Now().Add(time.Hour).Unix()}
+	token := signTestToken(t, key, "kid1", claims)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = mgr.ValidateCtx(ctx, token)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = mgr.ValidateCtx(context.Background(), token)
+	assert.Nil(t, err)
+}
+
+// TestTokenCache function
+func TestTokenCache(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	server := testJWKSServer(t, key, "kid1")
+	mgr := NewTokenManager(server.URL, "https://issuer", "cms")
+	mgr.CacheSize = 1
+	exp := time.Now().Add(time.Hour).Unix()
+	token1 := signTestToken(t, key, "kid1", map[string]interface{}{"iss": "https://issuer", "aud": "cms", "sub": "1", "exp": exp})
+	token2 := signTestToken(t, key, "kid1", map[string]interface{}{"iss": "https://issuer", "aud": "cms", "sub": "2", "exp": exp})
+
+	hits := authMetrics.tokenHits.Load()
+	misses := authMetrics.tokenMisses.Load()
+	claims, err := mgr.Validate(token1)
+	assert.Nil(t, err)
+	claims["sub"] = "modified"
+	claims, err = mgr.Validate(token1)
+	assert.Nil(t, err)
+	assert.Equal(t, claims["sub"], "1")
+	assert.Equal(t, authMetrics.tokenHits.Load()-hits, uint64(1))
+	assert.Equal(t, authMetrics.tokenMisses.Load()-misses, uint64(1))
+
+	// token2 evicts token1
+	_, err = mgr.Validate(token2)
+	assert.Nil(t, err)
+	_, ok := mgr.cache.get(token1)
+	assert.Equal(t, ok, false)
+
+	// expired entries are not returned
+	mgr.cache.add(token1, claims, time.Now().Add(-time.Second))
+	_, ok = mgr.cache.get(token1)
+	assert.Equal(t, ok, false)
+
+	// invalid tokens are not cached
+	_, err = mgr.Validate(token2 + "x")
+	assert.NotNil(t, err)
+	_, err = mgr.Validate(token2 + "x")
+	assert.NotNil(t, err)
+}
+
+// TestTokenKeyRefresh function
+func TestTokenKeyRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	jwks := testJWKSServer(t, key, "kid1")
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		jwks.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	mgr := NewTokenManager(server.URL, "https://issuer", "cms")
+	claims := map[string]interface{}{"iss": "https://issuer", "aud": "cms", "exp": time.Now().Add(time.Hour).Unix()}
+	_, err = mgr.Validate(signTestToken(t, key, "kid1", claims))
+	assert.Nil(t, err)
+	assert.Equal(t, atomic.LoadInt32(&fetches), int32(1))
+
+	// tokens with unknown key ID do not trigger JWKS requests within refresh interval
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mgr.Validate(signTestToken(t, key, "unknown", claims))
+			assert.NotNil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, atomic.LoadInt32(&fetches), int32(1))
+
+	mgr.KeyRefreshInterval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	_, err = mgr.Validate(signTestToken(t, key, "unknown", claims))
+	assert.NotNil(t, err)
+	assert.Equal(t, atomic.LoadInt32(&fetches), int32(2))
+
+	// JWKS size is limited
+	size := MaxJWKSSize
+	MaxJWKSSize = 10
+	defer func() { MaxJWKSSize = size }()
+	assert.NotNil(t, mgr.FetchKeys())
+}
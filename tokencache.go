@@ -0,0 +1,79 @@
+package cmsauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// tokenCacheEntry represents validated token claims kept in token cache
+type tokenCacheEntry struct {
+	key    [sha256.Size]byte
+	claims map[string]interface{}
+	expire time.Time
+}
+
+// tokenCache provides bounded LRU cache of validated token claims keyed by
+// token hash
+type tokenCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List
+}
+
+// helper function to create new token cache of given size
+func newTokenCache(size int) *tokenCache {
+	return &tokenCache{
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// helper function to return copy of cached claims for given token
+func (c *tokenCache) get(token string) (map[string]interface{}, bool) {
+	key := sha256.Sum256([]byte(token))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if time.Now().After(entry.expire) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyClaims(entry.claims), true
+}
+
+// helper function to add claims of given token to the cache, the least
+// recently used entry is evicted if cache is full
+func (c *tokenCache) add(token string, claims map[string]interface{}, expire time.Time) {
+	key := sha256.Sum256([]byte(token))
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+	}
+	entry := &tokenCacheEntry{key: key, claims: copyClaims(claims), expire: expire}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*tokenCacheEntry).key)
+	}
+}
+
+// helper function to return shallow copy of token claims
+func copyClaims(claims map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		out[k] = v
+	}
+	return out
+}
@@ -1,13 +1,15 @@
 package cmsauth
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -16,53 +18,76 @@ import (
 )
 
 // TIMEOUT defines timeout for net/url request
+//
+// Deprecated: use Config.Timeout with New instead.
 var TIMEOUT int
 
 // Token defines access token location
+//
+// Deprecated: use Config.Token with New instead.
 var Token string
 
 // Verbose defines verbosity level
+//
+// Deprecated: use Config.Verbose with New instead.
 var Verbose int
 
 // TLSCertsRenewInterval controls interval to re-read TLS certs (in seconds)
+//
+// Deprecated: use Config.TLSCertsRenewInterval with New instead.
 var TLSCertsRenewInterval time.Duration
 
 // TLSCertsManager holds TLS certificates for the server
 type TLSCertsManager struct {
 	Certs  []tls.Certificate
-	Expire time.Time
+	Expire time.Time // time after which certificates are re-read
+	mutex  sync.Mutex
 }
 
-// GetCerts return fresh copy of certificates
+// GetCerts return fresh copy of certificates. If certificates can not be
+// loaded the previously loaded ones are returned while they are valid,
+// otherwise an error is returned.
 func (t *TLSCertsManager) GetCerts() ([]tls.Certificate, error) {
-	var lock = sync.Mutex{}
-	lock.Lock()
-	defer lock.Unlock()
+	return t.getCerts(TLSCertsRenewInterval, Verbose)
+}
+
+// helper function to return fresh copy of certificates using given renew interval and verbosity level
+func (t *TLSCertsManager) getCerts(renewInterval time.Duration, verbose int) ([]tls.Certificate, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	// we'll use existing certs if our window is not expired
-	if t.Certs == nil || time.Since(t.Expire) > TLSCertsRenewInterval {
-		t.Expire = time.Now()
-		if Verbose > 0 {
-			log.Printf("read new certs expire=\"%v\" renewal_interval=%v\n", t.Expire, TLSCertsRenewInterval)
+	if t.Certs == nil || time.Now().After(t.Expire) {
+		t.Expire = time.Now().Add(renewInterval)
+		if verbose > 0 {
+			GetLogger().Infof("read new certs expire=\"%v\" renewal_interval=%v", t.Expire, renewInterval)
 		}
-		certs, err := TlsCerts()
+		certs, err := tlsCerts(verbose)
 		if err == nil {
 			t.Certs = certs
 		} else {
 			// to avoid collision between cron obtaining the proxy and
 			// this code base if we have error we'll increase interval instead of failure
-			if t.Certs != nil {
-				ts := time.Now().Add(time.Duration(600 * time.Second))
-				if CertExpire(t.Certs).After(ts) {
-					t.Expire = ts
-				}
-			} else {
-				log.Fatal("ERROR ", err.Error())
+			if t.Certs == nil {
+				return nil, err
+			}
+			ts := time.Now().Add(time.Duration(600 * time.Second))
+			if CertExpire(t.Certs).After(ts) {
+				t.Expire = ts
 			}
 		}
 	}
 	return t.Certs, nil
 }
 
+// helper function to set loaded certificates which are re-read after given
+// renew interval
+func (t *TLSCertsManager) setCerts(certs []tls.Certificate, renewInterval time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Certs = certs
+	t.Expire = time.Now().Add(renewInterval)
+}
+
 // CertExpire gets minimum certificate expire from list of certificates
 func CertExpire(certs []tls.Certificate) time.Time {
 	var notAfter time.Time
@@ -79,8 +104,69 @@ func CertExpire(certs []tls.Certificate) time.Time {
 // global TLSCerts manager
 var tlsManager TLSCertsManager
 
+// WarmTLSCerts loads and validates X509 certificates and populates global
+// TLS certificates manager. It should be used at service startup to check
+// certificate setup.
+func WarmTLSCerts() error {
+	return WarmTLSCertsCtx(context.Background())
+}
+
+// TlsCertsCtx returns X509 certificates, it returns context error if given
+// context is done before certificates are loaded
+func TlsCertsCtx(ctx context.Context) ([]tls.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		certs []tls.Certificate
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		certs, err := tlsCerts(Verbose)
+		ch <- result{certs, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.certs, res.err
+	}
+}
+
+// WarmTLSCertsCtx loads and validates X509 certificates similar to
+// WarmTLSCerts, loading is abandoned when given context is done
+func WarmTLSCertsCtx(ctx context.Context) error {
+	certs, err := TlsCertsCtx(ctx)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.New("no X509 certificates found, please set X509_USER_PROXY or X509_USER_CERT/X509_USER_KEY")
+	}
+	for _, cert := range certs {
+		if len(cert.Certificate) == 0 {
+			return errors.New("unable to find X509 certificate data")
+		}
+		c, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("unable to parse X509 certificate: %v", err)
+		}
+		if time.Now().After(c.NotAfter) {
+			return fmt.Errorf("X509 certificate %s expired on %v", c.Subject, c.NotAfter)
+		}
+	}
+	tlsManager.setCerts(certs, TLSCertsRenewInterval)
+	return nil
+}
+
 // TlsCerts returns X509 certificates
 func TlsCerts() ([]tls.Certificate, error) {
+	return tlsCerts(Verbose)
+}
+
+// helper function to return X509 certificates using given verbosity level
+func tlsCerts(verbose int) ([]tls.Certificate, error) {
 	uproxy := os.Getenv("X509_USER_PROXY")
 	uckey := os.Getenv("X509_USER_KEY")
 	ucert := os.Getenv("X509_USER_CERT")
@@ -93,8 +179,8 @@ func TlsCerts() ([]tls.Certificate, error) {
 			uproxy = fname
 		}
 	}
-	if Verbose == 1 {
-		log.Printf("tls certs, X509_USER_PROXY=%v, X509_USER_KEY=%v, X509_USER_CERT=%v\n", uproxy, uckey, ucert)
+	if verbose == 1 {
+		GetLogger().Infof("tls certs, X509_USER_PROXY=%v, X509_USER_KEY=%v, X509_USER_CERT=%v", uproxy, uckey, ucert)
 	}
 
 	if uproxy == "" && uckey == "" { // user doesn't have neither proxy or user certs
@@ -106,8 +192,8 @@ func TlsCerts() ([]tls.Certificate, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse X509 proxy: %v", err)
 		}
-		if Verbose == 1 {
-			log.Println("use proxy", uproxy)
+		if verbose == 1 {
+			GetLogger().Infof("use proxy %s", uproxy)
 		}
 		certs := []tls.Certificate{x509cert}
 		return certs, nil
@@ -116,49 +202,184 @@ func TlsCerts() ([]tls.Certificate, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse user X509 certificate: %v", err)
 	}
-	if Verbose == 1 {
-		log.Println("user key", uckey, "cert", ucert)
+	if verbose == 1 {
+		GetLogger().Infof("user key %s cert %s", uckey, ucert)
 	}
 	certs := []tls.Certificate{x509cert}
 	return certs, nil
 }
 
-// ReadToken function to either read file content or return given string
+// ReadToken function to either read file content or return given string.
+// If token file can not be read the error is logged and empty token is
+// returned, use LoadToken to handle the error.
 func ReadToken(r string) string {
+	token, err := LoadToken(r)
+	if err != nil {
+		GetLogger().Errorf("%v", err)
+	}
+	return token
+}
+
+// LoadToken function to either read file content or return given string
+func LoadToken(r string) (string, error) {
 	if _, err := os.Stat(r); err == nil {
 		b, e := os.ReadFile(r)
 		if e != nil {
-			log.Fatalf("Unable to read data from file: %s, error: %s", r, e)
+			return "", fmt.Errorf("unable to read data from file: %s, error: %v", r, e)
 		}
-		return strings.Replace(string(b), "\n", "", -1)
+		return strings.Replace(string(b), "\n", "", -1), nil
 	}
-	return r
+	return r, nil
 }
 
-// HttpClient provides cert/token aware HTTP client
+// HttpClient provides cert/token aware HTTP client. If X509 certs can not be
+// loaded the returned client fails every request with that error, use
+// NewHttpClient to handle the error.
 func HttpClient() *http.Client {
+	return defaultClient().HttpClient()
+}
+
+// NewHttpClient provides cert/token aware HTTP client or an error if X509
+// certs can not be loaded
+func NewHttpClient() (*http.Client, error) {
+	return defaultClient().NewHttpClient()
+}
+
+// HttpClient provides cert/token aware HTTP client based on client
+// configuration. If X509 certs can not be loaded the returned client fails
+// every request with that error, use NewHttpClient to handle the error.
+func (c *Client) HttpClient() *http.Client {
+	client, err := c.NewHttpClient()
+	if err != nil {
+		GetLogger().Errorf("unable to create HTTP client, error %v", err)
+		return &http.Client{Transport: errTransport{err: err}, Timeout: client.Timeout}
+	}
+	return client
+}
+
+// NewHttpClient provides cert/token aware HTTP client based on client
+// configuration or an error if X509 certs can not be loaded. When
+// Config.AllowNoCerts is set the client is built without X509 certs instead.
+func (c *Client) NewHttpClient() (*http.Client, error) {
+	return c.newHttpClient(c.Config.Token == "")
+}
+
+// helper function to build HTTP client based on client configuration, X509
+// certs are used if useCerts is set
+func (c *Client) newHttpClient(useCerts bool) (*http.Client, error) {
+	timeout := time.Duration(c.Config.Timeout) * time.Second
+	tlsConfig, err := c.tlsConfig(useCerts)
+	if err != nil {
+		return &http.Client{Timeout: timeout}, err
+	}
+	if tlsConfig == nil {
+		if c.Config.Timeout > 0 {
+			return &http.Client{Timeout: time.Duration(timeout)}, nil
+		}
+		return &http.Client{}, nil
+	}
+	tr := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	if c.Config.Timeout > 0 {
+		return &http.Client{Transport: tr, Timeout: timeout}, nil
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+// errTransport fails every HTTP request with given error
+type errTransport struct {
+	err error
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (t errTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// helper function to return TLS configuration based on client configuration,
+// X509 certs are loaded if useCerts is set. It returns nil if default TLS
+// configuration can be used, and an error if X509 certs can not be loaded
+// unless Config.AllowNoCerts is set.
+func (c *Client) tlsConfig(useCerts bool) (*tls.Config, error) {
 	var certs []tls.Certificate
 	var err error
-	if Token == "" { // if there is no token back auth we fall back to x509
+	if useCerts { // if there is no token back auth we fall back to x509
 		// get X509 certs
-		certs, err = tlsManager.GetCerts()
+		certs, err = c.tlsManager.getCerts(c.Config.TLSCertsRenewInterval, c.Config.Verbose)
 		if err != nil {
-			log.Fatal("ERROR ", err.Error())
+			if !c.Config.AllowNoCerts {
+				return nil, err
+			}
+			GetLogger().Warnf("unable to load X509 certs, continue without them, error %v", err)
 		}
 	}
-	timeout := time.Duration(TIMEOUT) * time.Second
-	if len(certs) == 0 {
-		if TIMEOUT > 0 {
-			return &http.Client{Timeout: time.Duration(timeout)}
+	rootCAs := c.rootCAs()
+	if len(certs) == 0 && rootCAs == nil && !c.Config.InsecureSkipVerify {
+		return nil, nil
+	}
+	return &tls.Config{Certificates: certs,
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: c.Config.InsecureSkipVerify}, nil
+}
+
+// helper function to return pool of trusted CAs from client CA directory, it
+// returns nil if CA directory is not set or can not be read, i.e. system
+// CAs are used
+func (c *Client) rootCAs() *x509.CertPool {
+	if c.Config.InsecureSkipVerify || c.Config.CADir == "" {
+		return nil
+	}
+	pool, err := LoadCAPool(c.Config.CADir)
+	if err != nil {
+		if c.Config.Verbose > 0 {
+			GetLogger().Warnf("unable to load CA certificates from %s, use system CAs, error %v", c.Config.CADir, err)
 		}
-		return &http.Client{}
+		return nil
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{Certificates: certs,
-			InsecureSkipVerify: true},
+	return pool
+}
+
+// caPools keeps loaded CA pools per CA directory
+var caPools = struct {
+	sync.Mutex
+	pools map[string]*x509.CertPool
+}{pools: make(map[string]*x509.CertPool)}
+
+// LoadCAPool loads PEM encoded CA certificates (*.pem, *.crt and *.0 files)
+// from given directory, e.g. /etc/grid-security/certificates, on top of
+// system CAs. The loaded pools are cached per directory.
+func LoadCAPool(dir string) (*x509.CertPool, error) {
+	caPools.Lock()
+	defer caPools.Unlock()
+	if pool, ok := caPools.pools[dir]; ok {
+		return pool, nil
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	var found bool
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !(strings.HasSuffix(name, ".pem") || strings.HasSuffix(name, ".crt") || strings.HasSuffix(name, ".0")) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if pool.AppendCertsFromPEM(data) {
+			found = true
+		}
 	}
-	if TIMEOUT > 0 {
-		return &http.Client{Transport: tr, Timeout: timeout}
+	if !found {
+		return nil, fmt.Errorf("no CA certificates found in %s", dir)
 	}
-	return &http.Client{Transport: tr}
+	caPools.pools[dir] = pool
+	return pool, nil
 }
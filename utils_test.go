@@ -0,0 +1,144 @@
+package cmsauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to create self-signed X509 certificate and key files and
+// setup X509_USER_CERT and X509_USER_KEY environment
+func testX509Cert(t *testing.T, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	kder, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	ckey := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.Nil(t, err)
+	err = os.WriteFile(ckey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), 0600)
+	assert.Nil(t, err)
+	t.Setenv("X509_USER_PROXY", "")
+	t.Setenv("X509_USER_CERT", cert)
+	t.Setenv("X509_USER_KEY", ckey)
+}
+
+// TestWarmTLSCerts function
+func TestWarmTLSCerts(t *testing.T) {
+	defer func() { tlsManager = TLSCertsManager{} }()
+
+	testX509Cert(t, time.Now().Add(24*time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, WarmTLSCertsCtx(ctx), context.Canceled)
+	err := WarmTLSCerts()
+	assert.Nil(t, err)
+	assert.Equal(t, len(tlsManager.Certs), 1)
+
+	// warmed certificates are used until renewal deadline
+	interval := TLSCertsRenewInterval
+	defer func() { TLSCertsRenewInterval = interval }()
+	TLSCertsRenewInterval = time.Hour
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, WarmTLSCerts())
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		certs, err := tlsManager.GetCerts()
+		assert.Nil(t, err)
+		assert.Equal(t, len(certs), 1)
+	}
+	wg.Wait()
+	assert.Equal(t, tlsManager.Expire.After(time.Now().Add(50*time.Minute)), true)
+	t.Setenv("X509_USER_CERT", "/nonexistent/cert.pem")
+	certs, err := tlsManager.GetCerts()
+	assert.Nil(t, err)
+	assert.Equal(t, len(certs), 1)
+
+	testX509Cert(t, time.Now().Add(-time.Hour))
+	err = WarmTLSCerts()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "expired")
+
+	t.Setenv("X509_USER_CERT", "")
+	t.Setenv("X509_USER_KEY", "")
+	err = WarmTLSCerts()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no X509 certificates found")
+
+	t.Setenv("X509_USER_CERT", "/nonexistent/cert.pem")
+	t.Setenv("X509_USER_KEY", "/nonexistent/key.pem")
+	err = WarmTLSCerts()
+	assert.NotNil(t, err)
+}
+
+// TestHttpClientCertErrors function
+func TestHttpClientCertErrors(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cert.pem")
+	err := os.WriteFile(fname, []byte("invalid"), 0600)
+	assert.Nil(t, err)
+	t.Setenv("X509_USER_PROXY", "")
+	t.Setenv("X509_USER_CERT", fname)
+	t.Setenv("X509_USER_KEY", fname)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	c := New(Config{})
+	_, err = c.NewHttpClient()
+	assert.NotNil(t, err)
+	// requests of client with invalid certs fail instead of process exit
+	_, err = c.HttpClient().Get(server.URL)
+	assert.NotNil(t, err)
+
+	// degraded mode builds client without certs
+	c = New(Config{AllowNoCerts: true})
+	client, err := c.NewHttpClient()
+	assert.Nil(t, err)
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+}
+
+// TestLoadToken function
+func TestLoadToken(t *testing.T) {
+	token, err := LoadToken("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, token, "abc")
+	fname := filepath.Join(t.TempDir(), "token")
+	err = os.WriteFile(fname, []byte("xyz\n"), 0600)
+	assert.Nil(t, err)
+	token, err = LoadToken(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, token, "xyz")
+	assert.Equal(t, ReadToken(fname), "xyz")
+}
@@ -0,0 +1,378 @@
+package cmsauth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VOMSDefaultRole defines role assigned to FQANs without role, e.g. /cms/Role=NULL
+var VOMSDefaultRole = "user"
+
+var (
+	// oidVOMSExtension identifies proxy certificate extension with VOMS attribute certificates
+	oidVOMSExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 8005, 100, 100, 5}
+	// oidVOMSAttribute identifies attribute certificate attribute with FQANs
+	oidVOMSAttribute = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 8005, 100, 100, 4}
+	// oidVOMSCerts identifies attribute certificate extension with VOMS server certificates
+	oidVOMSCerts = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 8005, 100, 100, 10}
+	// oidProxyCertInfo identifies RFC 3820 proxy certificate extension
+	oidProxyCertInfo = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 14}
+)
+
+// acSignatureAlgorithms maps OIDs of attribute certificate signature
+// algorithms to x509 signature algorithms
+var acSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+}
+
+// attributeCertificate represents RFC 3281 attribute certificate
+type attributeCertificate struct {
+	Info               asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// acInfo represents RFC 3281 attribute certificate info
+type acInfo struct {
+	Version        int
+	Holder         acHolder
+	Issuer         asn1.RawValue
+	Signature      pkix.AlgorithmIdentifier
+	SerialNumber   *big.Int
+	Validity       acValidity
+	Attributes     []acAttribute
+	IssuerUniqueID asn1.BitString   `asn1:"optional"`
+	Extensions     []pkix.Extension `asn1:"optional"`
+}
+
+// acHolder represents holder of attribute certificate
+type acHolder struct {
+	BaseCertificateID acIssuerSerial `asn1:"optional,tag:0"`
+	EntityName        asn1.RawValue  `asn1:"optional,tag:1"`
+	ObjectDigestInfo  asn1.RawValue  `asn1:"optional,tag:2"`
+}
+
+// acIssuerSerial represents issuer and serial number of holder certificate
+type acIssuerSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// acValidity represents validity period of attribute certificate
+type acValidity struct {
+	NotBefore time.Time `asn1:"generalized"`
+	NotAfter  time.Time `asn1:"generalized"`
+}
+
+// acAttribute represents attribute of attribute certificate
+type acAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// ietfAttrSyntax represents value of VOMS attribute
+type ietfAttrSyntax struct {
+	PolicyAuthority asn1.RawValue `asn1:"optional,tag:0"`
+	Values          []asn1.RawValue
+}
+
+// VOMSOptions defines trust settings of VOMS proxy validation
+type VOMSOptions struct {
+	Roots     *x509.CertPool      // trusted CAs of user and VOMS server certificates, nil means system CAs
+	ServerDNs []string            // DNs of trusted VOMS servers whose certificates are embedded in attribute certificates, like LSC files
+	Servers   []*x509.Certificate // trusted VOMS server certificates, used if attribute certificate does not embed them
+	VO        string              // accepted VO, e.g. cms, empty accepts any VO
+}
+
+// VerifyProxyChain verifies X509 proxy chain, e.g. TLS peer certificates,
+// ordered from the leaf proxy to the end entity certificate and optional
+// intermediate CAs. Every proxy should be signed by the next certificate and
+// extend its subject, and the end entity certificate should be issued by
+// trusted CA. It returns the end entity certificate.
+func VerifyProxyChain(chain []*x509.Certificate, roots *x509.CertPool) (*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+	now := time.Now()
+	idx := 0
+	for ; idx < len(chain)-1 && isProxyOf(chain[idx], chain[idx+1]); idx++ {
+		proxy, parent := chain[idx], chain[idx+1]
+		if err := parent.CheckSignature(proxy.SignatureAlgorithm, proxy.RawTBSCertificate, proxy.Signature); err != nil {
+			return nil, fmt.Errorf("invalid signature of proxy %s: %v", CertificateDN(proxy), err)
+		}
+		if now.Before(proxy.NotBefore) || now.After(proxy.NotAfter) {
+			return nil, fmt.Errorf("proxy %s is not valid at %v", CertificateDN(proxy), now)
+		}
+	}
+	cert := chain[idx]
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[idx+1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, fmt.Errorf("unable to verify certificate %s: %v", CertificateDN(cert), err)
+	}
+	return cert, nil
+}
+
+// helper function to check if certificate is proxy issued by given parent
+// certificate, i.e. RFC 3820 or legacy Globus proxy
+func isProxyOf(cert, parent *x509.Certificate) bool {
+	if parent.IsCA || !bytes.Equal(cert.RawIssuer, parent.RawSubject) {
+		return false
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidProxyCertInfo) {
+			return true
+		}
+	}
+	return strings.HasPrefix(CertificateDN(cert), CertificateDN(parent)+"/CN=")
+}
+
+// VOMSFQANs verifies given X509 proxy chain and returns FQANs, e.g.
+// /cms/Role=production/Capability=NULL, of VOMS attribute certificates
+// embedded in its proxies. The attribute certificates should be valid, issued
+// for the end entity certificate of the chain and signed by trusted VOMS
+// server.
+func VOMSFQANs(chain []*x509.Certificate, opts VOMSOptions) ([]string, error) {
+	_, fqans, err := vomsFQANs(chain, opts)
+	return fqans, err
+}
+
+// helper function to verify proxy chain and return its end entity
+// certificate and VOMS FQANs
+func vomsFQANs(chain []*x509.Certificate, opts VOMSOptions) (*x509.Certificate, []string, error) {
+	cert, err := VerifyProxyChain(chain, opts.Roots)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fqans []string
+	for _, proxy := range chain {
+		if proxy == cert {
+			break
+		}
+		for _, ext := range proxy.Extensions {
+			if !ext.Id.Equal(oidVOMSExtension) {
+				continue
+			}
+			acs, err := parseVOMSExtension(ext.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, ac := range acs {
+				vals, err := verifyVOMSAC(ac, cert, opts)
+				if err != nil {
+					return nil, nil, err
+				}
+				fqans = append(fqans, vals...)
+			}
+		}
+	}
+	return cert, fqans, nil
+}
+
+// helper function to parse VOMS extension, it contains sequence of
+// attribute certificates or sequence of such sequences
+func parseVOMSExtension(data []byte) ([]attributeCertificate, error) {
+	var seq []asn1.RawValue
+	if rest, err := asn1.Unmarshal(data, &seq); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("invalid VOMS extension: %v", err)
+	}
+	var acs []attributeCertificate
+	for _, v := range seq {
+		var ac attributeCertificate
+		if _, err := asn1.Unmarshal(v.FullBytes, &ac); err == nil {
+			acs = append(acs, ac)
+			continue
+		}
+		var inner []attributeCertificate
+		if _, err := asn1.Unmarshal(v.FullBytes, &inner); err != nil {
+			return nil, fmt.Errorf("invalid VOMS attribute certificate: %v", err)
+		}
+		acs = append(acs, inner...)
+	}
+	return acs, nil
+}
+
+// helper function to verify VOMS attribute certificate and return its FQANs
+func verifyVOMSAC(ac attributeCertificate, holder *x509.Certificate, opts VOMSOptions) ([]string, error) {
+	var info acInfo
+	if _, err := asn1.Unmarshal(ac.Info.FullBytes, &info); err != nil {
+		return nil, fmt.Errorf("invalid VOMS attribute certificate: %v", err)
+	}
+	now := time.Now()
+	if now.Before(info.Validity.NotBefore) || now.After(info.Validity.NotAfter) {
+		return nil, fmt.Errorf("VOMS attribute certificate is not valid at %v", now)
+	}
+	if !isACHolder(info.Holder, holder) {
+		return nil, fmt.Errorf("VOMS attribute certificate is not issued for %s", CertificateDN(holder))
+	}
+	algo, ok := acSignatureAlgorithms[ac.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported VOMS signature algorithm %v", ac.SignatureAlgorithm.Algorithm)
+	}
+	var verified bool
+	for _, signer := range vomsSigners(info, opts) {
+		if signer.CheckSignature(algo, ac.Info.FullBytes, ac.Signature.RightAlign()) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("VOMS attribute certificate is not signed by trusted VOMS server")
+	}
+	var fqans []string
+	for _, attr := range info.Attributes {
+		if !attr.Type.Equal(oidVOMSAttribute) {
+			continue
+		}
+		for _, val := range attr.Values {
+			var syntax ietfAttrSyntax
+			if _, err := asn1.Unmarshal(val.FullBytes, &syntax); err != nil {
+				return nil, fmt.Errorf("invalid VOMS attribute: %v", err)
+			}
+			for _, v := range syntax.Values {
+				fqan := string(v.Bytes)
+				if opts.VO != "" && !strings.HasPrefix(fqan, "/"+opts.VO+"/") && fqan != "/"+opts.VO {
+					return nil, fmt.Errorf("VOMS FQAN %s does not belong to VO %s", fqan, opts.VO)
+				}
+				fqans = append(fqans, fqan)
+			}
+		}
+	}
+	return fqans, nil
+}
+
+// helper function to check if attribute certificate holder refers to given
+// certificate, i.e. both serial number and issuer match since serial numbers
+// are unique only per CA
+func isACHolder(holder acHolder, cert *x509.Certificate) bool {
+	id := holder.BaseCertificateID
+	if id.Serial == nil || id.Serial.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+	// issuer is GeneralNames which should contain directoryName of issuer
+	var names []asn1.RawValue
+	if rest, err := asn1.Unmarshal(id.Issuer.FullBytes, &names); err != nil || len(rest) > 0 {
+		return false
+	}
+	for _, name := range names {
+		if name.Class == asn1.ClassContextSpecific && name.Tag == 4 && bytes.Equal(name.Bytes, cert.RawIssuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function to return trusted candidates of VOMS attribute certificate
+// signer, i.e. embedded VOMS server certificates with trusted DN issued by
+// trusted CA and explicitly trusted VOMS servers
+func vomsSigners(info acInfo, opts VOMSOptions) []*x509.Certificate {
+	var signers []*x509.Certificate
+	for _, ext := range info.Extensions {
+		if !ext.Id.Equal(oidVOMSCerts) {
+			continue
+		}
+		var raw []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+			continue
+		}
+		for _, r := range raw {
+			cert, err := x509.ParseCertificate(r.FullBytes)
+			if err != nil || !contains(opts.ServerDNs, CertificateDN(cert)) {
+				continue
+			}
+			vopts := x509.VerifyOptions{Roots: opts.Roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+			if _, err := cert.Verify(vopts); err == nil {
+				signers = append(signers, cert)
+			}
+		}
+	}
+	return append(signers, opts.Servers...)
+}
+
+// FQANRoles maps VOMS FQANs into CMS roles and groups used in cms-authz
+// headers, e.g. /cms/uscms/Role=production/Capability=NULL is mapped into
+// production role of group:cms/uscms and FQANs without role are mapped into
+// VOMSDefaultRole
+func FQANRoles(fqans []string) map[string][]string {
+	roles := make(map[string][]string)
+	for _, fqan := range fqans {
+		var groups []string
+		role := ""
+		for _, p := range strings.Split(fqan, "/") {
+			switch {
+			case p == "":
+			case strings.HasPrefix(p, "Role="):
+				role = strings.TrimPrefix(p, "Role=")
+			case strings.HasPrefix(p, "Capability="):
+			default:
+				groups = append(groups, p)
+			}
+		}
+		if len(groups) == 0 {
+			continue
+		}
+		if role == "" || role == "NULL" {
+			role = VOMSDefaultRole
+		}
+		group := "group:" + strings.Join(groups, "/")
+		if !contains(roles[role], group) {
+			roles[role] = append(roles[role], group)
+		}
+	}
+	return roles
+}
+
+// CheckVOMSProxy authenticates HTTP request using VOMS proxy provided by TLS
+// connection. The proxy chain and its VOMS attribute certificates are
+// verified and CMS headers are set on given request with roles obtained from
+// VOMS FQANs, i.e. without CRIC lookup. It returns FQANs of the proxy.
+func (a *CMSAuth) CheckVOMSProxy(r *http.Request, opts VOMSOptions) ([]string, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNotAuthenticated
+	}
+	chain := r.TLS.PeerCertificates
+	cert, fqans, err := vomsFQANs(chain, opts)
+	if err != nil {
+		return nil, authErrorf(ErrNotAuthenticated, "%v", err)
+	}
+	if len(fqans) == 0 {
+		return nil, authErrorf(ErrNotAuthenticated, "no VOMS attributes found in proxy")
+	}
+	expire := chain[0].NotAfter
+	for _, c := range chain {
+		if c.NotAfter.Before(expire) {
+			expire = c.NotAfter
+		}
+	}
+	dn := CertificateDN(cert)
+	s := &Session{
+		Name:   CN(dn),
+		DN:     dn,
+		Roles:  FQANRoles(fqans),
+		Method: "VOMSProxy",
+		Expire: expire.Unix(),
+	}
+	a.SetSessionHeaders(r, s, false)
+	return fqans, nil
+}
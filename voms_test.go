@@ -0,0 +1,152 @@
+package cmsauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to create VOMS extension with attribute certificate of
+// given FQANs issued for holder certificate and signed by given VOMS server
+func testVOMSExtension(t *testing.T, holder *x509.Certificate, server tls.Certificate, fqans []string) pkix.Extension {
+	var vals []asn1.RawValue
+	for _, fqan := range fqans {
+		vals = append(vals, asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte(fqan)})
+	}
+	attr, err := asn1.Marshal(ietfAttrSyntax{Values: vals})
+	assert.Nil(t, err)
+	certs, err := asn1.Marshal([]asn1.RawValue{{FullBytes: server.Leaf.Raw}})
+	assert.Nil(t, err)
+	// holder issuer is GeneralNames with directoryName of holder issuer
+	dirName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: holder.RawIssuer})
+	assert.Nil(t, err)
+	issuer, err := asn1.Marshal([]asn1.RawValue{{FullBytes: dirName}})
+	assert.Nil(t, err)
+	algo := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	now := time.Now().UTC().Truncate(time.Second)
+	info := acInfo{
+		Version: 1,
+		Holder: acHolder{BaseCertificateID: acIssuerSerial{
+			Issuer: asn1.RawValue{FullBytes: issuer},
+			Serial: holder.SerialNumber,
+		}},
+		Issuer:       asn1.RawValue{FullBytes: []byte{0xa0, 0}},
+		Signature:    algo,
+		SerialNumber: big.NewInt(1),
+		Validity:     acValidity{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		Attributes:   []acAttribute{{Type: oidVOMSAttribute, Values: []asn1.RawValue{{FullBytes: attr}}}},
+		Extensions:   []pkix.Extension{{Id: oidVOMSCerts, Value: certs}},
+	}
+	infoDER, err := asn1.Marshal(info)
+	assert.Nil(t, err)
+	digest := sha256.Sum256(infoDER)
+	sig, err := server.PrivateKey.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+	ac := attributeCertificate{
+		Info:               asn1.RawValue{FullBytes: infoDER},
+		SignatureAlgorithm: algo,
+		Signature:          asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	}
+	acs, err := asn1.Marshal([]attributeCertificate{ac})
+	assert.Nil(t, err)
+	value, err := asn1.Marshal([]asn1.RawValue{{FullBytes: acs}})
+	assert.Nil(t, err)
+	return pkix.Extension{Id: oidVOMSExtension, Value: value}
+}
+
+// helper function to create legacy proxy of given user certificate
+func testProxy(t *testing.T, user tls.Certificate, exts []pkix.Extension) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	names := append([]pkix.AttributeTypeAndValue{}, user.Leaf.Subject.Names...)
+	names = append(names, pkix.AttributeTypeAndValue{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "1234"})
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1234),
+		Subject:         pkix.Name{ExtraNames: names},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(30 * time.Minute),
+		ExtraExtensions: exts,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, user.Leaf, &key.PublicKey, user.PrivateKey)
+	assert.Nil(t, err)
+	proxy, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return proxy
+}
+
+// TestVOMSProxy function
+func TestVOMSProxy(t *testing.T) {
+	ca := testTLSCert(t, pkix.Name{CommonName: "Test CA"}, nil, true)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	server := testTLSCert(t, pkix.Name{CommonName: "voms-cms.cern.ch"}, &ca, false)
+	cn := asn1.ObjectIdentifier{2, 5, 4, 3}
+	user := testTLSCert(t, pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+		{Type: cn, Value: "user1"},
+	}}, &ca, false)
+	fqans := []string{"/cms/Role=production/Capability=NULL", "/cms/uscms/Role=NULL/Capability=NULL"}
+	proxy := testProxy(t, user, []pkix.Extension{testVOMSExtension(t, user.Leaf, server, fqans)})
+	chain := []*x509.Certificate{proxy, user.Leaf}
+	opts := VOMSOptions{Roots: roots, ServerDNs: []string{"/CN=voms-cms.cern.ch"}, VO: "cms"}
+
+	cert, err := VerifyProxyChain(chain, roots)
+	assert.Nil(t, err)
+	assert.Equal(t, CertificateDN(cert), "/CN=user1")
+	_, err = VerifyProxyChain(chain, x509.NewCertPool())
+	assert.NotNil(t, err)
+
+	vals, err := VOMSFQANs(chain, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, vals, fqans)
+	roles := FQANRoles(vals)
+	assert.Equal(t, roles["production"], []string{"group:cms"})
+	assert.Equal(t, roles[VOMSDefaultRole], []string{"group:cms/uscms"})
+
+	// VOMS server certificate should be explicitly trusted
+	_, err = VOMSFQANs(chain, VOMSOptions{Roots: roots, VO: "cms"})
+	assert.NotNil(t, err)
+	_, err = VOMSFQANs(chain, VOMSOptions{Roots: roots, Servers: []*x509.Certificate{server.Leaf}})
+	assert.Nil(t, err)
+	opts.VO = "atlas"
+	_, err = VOMSFQANs(chain, opts)
+	assert.NotNil(t, err)
+	opts.VO = "cms"
+
+	// attributes signed by user itself should be rejected
+	forged := testProxy(t, user, []pkix.Extension{testVOMSExtension(t, user.Leaf, user, fqans)})
+	_, err = VOMSFQANs([]*x509.Certificate{forged, user.Leaf}, opts)
+	assert.NotNil(t, err)
+
+	// attributes issued for certificate with the same serial number from
+	// other CA should be rejected
+	other := testTLSCert(t, pkix.Name{CommonName: "Other CA"}, nil, true)
+	sameSerial := &x509.Certificate{SerialNumber: user.Leaf.SerialNumber, RawIssuer: other.Leaf.RawSubject}
+	stolen := testProxy(t, user, []pkix.Extension{testVOMSExtension(t, sameSerial, server, fqans)})
+	_, err = VOMSFQANs([]*x509.Certificate{stolen, user.Leaf}, opts)
+	assert.NotNil(t, err)
+
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	_, err = cmsAuth.CheckVOMSProxy(r, opts)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+	r.TLS = &tls.ConnectionState{PeerCertificates: chain}
+	vals, err = cmsAuth.CheckVOMSProxy(r, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, vals, fqans)
+	assert.Equal(t, HeaderValue(r.Header, "cms-authn-dn"), "/CN=user1")
+	assert.Equal(t, HeaderValue(r.Header, "cms-authn-method"), "VOMSProxy")
+	assert.Equal(t, HeaderValue(r.Header, "cms-authz-production"), "group:cms")
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(r.Header, "production", "cms", "cms"), true)
+}
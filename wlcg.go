@@ -0,0 +1,111 @@
+package cmsauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// WLCGRole defines role used in cms-authz header for WLCG token groups
+var WLCGRole = "wlcg"
+
+// WLCGScope represents WLCG token scope, e.g. storage.read:/cms
+type WLCGScope struct {
+	Name string `json:"name"` // scope name, e.g. storage.read
+	Path string `json:"path"` // scope path, e.g. /cms, empty for non storage scopes
+}
+
+// WLCGToken represents claims of token issued according to WLCG JWT profile
+type WLCGToken struct {
+	Version string      `json:"version"` // wlcg.ver claim
+	Subject string      `json:"subject"` // token subject
+	Issuer  string      `json:"issuer"`  // token issuer
+	Groups  []string    `json:"groups"`  // wlcg.groups claim
+	Scopes  []WLCGScope `json:"scopes"`  // token scopes
+}
+
+// ParseWLCGToken parses validated token claims, see TokenManager, according
+// to WLCG JWT profile
+func ParseWLCGToken(claims map[string]interface{}) (*WLCGToken, error) {
+	ver, ok := claims["wlcg.ver"].(string)
+	if !ok || ver == "" {
+		return nil, errors.New("token does not follow WLCG profile, no wlcg.ver claim")
+	}
+	if !strings.HasPrefix(ver, "1.") {
+		return nil, fmt.Errorf("unsupported WLCG token version %s", ver)
+	}
+	token := &WLCGToken{Version: ver}
+	token.Subject, _ = claims["sub"].(string)
+	token.Issuer, _ = claims["iss"].(string)
+	switch groups := claims["wlcg.groups"].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if group, ok := g.(string); ok {
+				token.Groups = append(token.Groups, group)
+			}
+		}
+	case []string:
+		token.Groups = groups
+	}
+	for _, scope := range ScopesFromClaims(claims) {
+		name, spath, _ := strings.Cut(scope, ":")
+		if strings.HasPrefix(name, "storage.") && spath == "" {
+			spath = "/"
+		}
+		token.Scopes = append(token.Scopes, WLCGScope{Name: name, Path: spath})
+	}
+	return token, nil
+}
+
+// Allows checks if token scopes allow given storage operation, e.g. read,
+// create, modify or stage, on given path. The storage.modify scope implies
+// storage.create.
+func (t *WLCGToken) Allows(op, fpath string) bool {
+	fpath = path.Clean("/" + fpath)
+	for _, s := range t.Scopes {
+		if s.Name != "storage."+op && !(op == "create" && s.Name == "storage.modify") {
+			continue
+		}
+		spath := path.Clean(s.Path)
+		if spath == "/" || fpath == spath || strings.HasPrefix(fpath, spath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetWLCGHeaders sets CMS headers on given HTTP request based on validated
+// WLCG token claims. The token groups are mapped onto cms-authz header of
+// WLCGRole, e.g. wlcg.groups ["/cms", "/cms/production"] produces header
+// cms-authz-wlcg: group:cms group:cms/production, and token scopes are set
+// in cms-authn-scope header.
+func (a *CMSAuth) SetWLCGHeaders(r *http.Request, claims map[string]interface{}, verbose bool) (*WLCGToken, error) {
+	token, err := ParseWLCGToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	a.clearCMSHeaders(r)
+	r.Header.Set(a.HeaderKey("auth-status"), "ok")
+	r.Header.Set(a.HeaderKey("authn-login"), token.Subject)
+	r.Header.Set(a.HeaderKey("authn-method"), "WLCGToken")
+	r.Header.Set(a.HeaderKey("auth-expire"), iString(claims["exp"]))
+	var groups []string
+	for _, g := range token.Groups {
+		groups = append(groups, "group:"+strings.TrimPrefix(g, "/"))
+	}
+	if len(groups) > 0 {
+		r.Header.Set(a.RoleHeaderKey(WLCGRole), strings.Join(groups, " "))
+	}
+	if scopes := ScopesFromClaims(claims); len(scopes) > 0 {
+		r.Header.Set(a.HeaderKey("authn-scope"), strings.Join(scopes, " "))
+	}
+	r.Header.Set(a.HeaderKey("request-uri"), r.URL.Path)
+	r.Header.Set(a.HeaderKey("authn-timestamp"), fmt.Sprintf("%d", time.Now().Unix()))
+	if hmac, err := a.GetHmac(r, verbose); err == nil {
+		r.Header.Set(a.HeaderKey("authn-hmac"), hmac)
+	}
+	return token, nil
+}
@@ -0,0 +1,50 @@
+package cmsauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWLCGToken function
+func TestWLCGToken(t *testing.T) {
+	claims := map[string]interface{}{
+		"wlcg.ver":    "1.0",
+		"sub":         "user",
+		"iss":         "https://cms-auth.web.cern.ch/",
+		"exp":         float64(time.Now().Add(time.Hour).Unix()),
+		"scope":       "storage.read:/ storage.modify:/store/user/user compute.read",
+		"wlcg.groups": []interface{}{"/cms", "/cms/production"},
+	}
+	token, err := ParseWLCGToken(claims)
+	assert.Nil(t, err)
+	assert.Equal(t, token.Subject, "user")
+	assert.Equal(t, token.Groups, []string{"/cms", "/cms/production"})
+	assert.Equal(t, token.Scopes[2], WLCGScope{Name: "compute.read"})
+	assert.Equal(t, token.Allows("read", "/store/data/file.root"), true)
+	assert.Equal(t, token.Allows("create", "/store/user/user/file.root"), true)
+	assert.Equal(t, token.Allows("modify", "/store/user/user2/file.root"), false)
+	assert.Equal(t, token.Allows("modify", "/store/user/user/../user2"), false)
+	assert.Equal(t, token.Allows("stage", "/store"), false)
+
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/store/data", nil)
+	r.Header.Set("cms-authz-admin", "group:injected")
+	_, err = cmsAuth.SetWLCGHeaders(r, claims, false)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Header.Get("cms-authz-admin"), "")
+	assert.Equal(t, r.Header.Get("cms-authz-wlcg"), "group:cms group:cms/production")
+	assert.Equal(t, CheckScopes(r.Header, []string{"compute.read"}), true)
+	header := lowerHeaders(r.Header)
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(header), true)
+	assert.Equal(t, cmsAuth.CheckCMSAuthz(header, "wlcg", "group:cms/production", ""), true)
+
+	delete(claims, "wlcg.ver")
+	_, err = ParseWLCGToken(claims)
+	assert.NotNil(t, err)
+	claims["wlcg.ver"] = "2.0"
+	_, err = ParseWLCGToken(claims)
+	assert.NotNil(t, err)
+}
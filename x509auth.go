@@ -0,0 +1,138 @@
+package cmsauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// CheckX509 authenticates HTTP request using client certificate provided by
+// TLS connection. Only the end entity certificate of chains verified by TLS
+// is matched against CRIC records and, if user is found, CMS headers are set
+// on given request. Additional certificates sent by the client are never
+// trusted. If TLS did not verify the chain, e.g. with tls.RequestClientCert
+// used to accept proxy certificates, the chain is verified by
+// VerifyProxyChain against ClientCAs and its end entity certificate is
+// matched, without ClientCAs such requests are rejected.
+func (a *CMSAuth) CheckX509(r *http.Request, cricRecords CricRecords) (*CricEntry, bool) {
+	if r.TLS == nil {
+		return nil, false
+	}
+	for _, cert := range a.x509Leaves(r.TLS) {
+		dn := CertificateDN(cert)
+		rec, ok := findCricEntryByDN(cricRecords, dn)
+		if !ok {
+			continue
+		}
+		userData := map[string]interface{}{
+			"name":     rec.Name,
+			"cern_upn": rec.Login,
+			"dn":       rec.DN,
+			"exp":      cert.NotAfter.Unix(),
+		}
+		// SetCMSHeaders looks up CRIC records by sorted DN
+		records := CricRecords{GetSortedDN(rec.DN): rec}
+		a.SetCMSHeaders(r, userData, records, false)
+		return &rec, true
+	}
+	return nil, false
+}
+
+// helper function to return verified end entity certificates of TLS
+// connection, i.e. leaves of chains verified by TLS or end entity
+// certificate of proxy chain verified against ClientCAs
+func (a *CMSAuth) x509Leaves(state *tls.ConnectionState) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, chain := range state.VerifiedChains {
+		if len(chain) > 0 {
+			certs = append(certs, chain[0])
+		}
+	}
+	if len(certs) > 0 || a.ClientCAs == nil || len(state.PeerCertificates) == 0 {
+		return certs
+	}
+	cert, err := VerifyProxyChain(state.PeerCertificates, a.ClientCAs)
+	if err != nil {
+		GetLogger().Warnf("unable to verify client certificate chain, error %v", err)
+		return nil
+	}
+	return []*x509.Certificate{cert}
+}
+
+// ServerTLSOptions defines options of server TLS configuration created by
+// ServerTLSConfig
+type ServerTLSOptions struct {
+	Certificates []tls.Certificate // server certificates
+	ClientCAs    *x509.CertPool    // CAs used to verify client certificates, takes precedence over CADir
+	CADir        string            // directory with trusted CAs, e.g. /etc/grid-security/certificates
+	RequireCert  bool              // reject connections without client certificate
+	MinVersion   uint16            // minimum TLS version, default is TLS 1.2
+
+	// LookupDN defines optional indexed lookup of CRIC entry by DN, e.g.
+	// CricManager.LookupByDN, it takes precedence over CRIC records
+	LookupDN func(dn string) (CricEntry, bool)
+}
+
+// ServerTLSConfig creates TLS configuration of Go services which terminate
+// TLS themselves. Client certificates are verified against trusted CAs and
+// the subject DN of verified chain is matched against CRIC records returned
+// by given function, e.g. CricManager.Records, i.e. only users registered in
+// CRIC can establish connection. The CMS headers can be set afterwards by
+// CheckX509. Note that RFC 3820 proxy certificates are not accepted by Go TLS
+// verification.
+func ServerTLSConfig(cricRecords func() CricRecords, opts ServerTLSOptions) (*tls.Config, error) {
+	pool := opts.ClientCAs
+	if pool == nil && opts.CADir != "" {
+		var err error
+		pool, err = LoadCAPool(opts.CADir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clientAuth := tls.VerifyClientCertIfGiven
+	if opts.RequireCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	minVersion := opts.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		Certificates: opts.Certificates,
+		ClientCAs:    pool,
+		ClientAuth:   clientAuth,
+		MinVersion:   minVersion,
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(verifiedChains) == 0 {
+				// no client certificate is given
+				return nil
+			}
+			lookup := opts.LookupDN
+			if lookup == nil {
+				records := cricRecords()
+				lookup = func(dn string) (CricEntry, bool) {
+					return findCricEntryByDN(records, dn)
+				}
+			}
+			return verifyCricChains(lookup, verifiedChains)
+		},
+	}, nil
+}
+
+// helper function to check that subject DN of end entity certificate of
+// verified chains belongs to CRIC user found by given lookup function, CA
+// certificates of the chains are never matched
+func verifyCricChains(lookup func(string) (CricEntry, bool), chains [][]*x509.Certificate) error {
+	var dn string
+	for _, chain := range chains {
+		if len(chain) == 0 {
+			continue
+		}
+		dn = CertificateDN(chain[0])
+		if _, ok := lookup(dn); ok {
+			return nil
+		}
+	}
+	recordAuthFailure(ErrUserNotInCric.Reason)
+	return authErrorf(ErrUserNotInCric, "client certificate DN %s is not found in CRIC records", dn)
+}
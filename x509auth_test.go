@@ -0,0 +1,227 @@
+package cmsauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function to create certificate with given subject
+func testCertificate(t *testing.T, subject pkix.Name) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
+
+// TestCheckX509 function
+func TestCheckX509(t *testing.T) {
+	dc := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+	cn := asn1.ObjectIdentifier{2, 5, 4, 3}
+	names := []pkix.AttributeTypeAndValue{
+		{Type: dc, Value: "ch"},
+		{Type: dc, Value: "cern"},
+		{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Organic Units"},
+		{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Users"},
+		{Type: cn, Value: "user1"},
+		{Type: cn, Value: "1"},
+		{Type: cn, Value: "First1 Last1"},
+	}
+	ca := testTLSCert(t, pkix.Name{CommonName: "Test CA"}, nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	user := testTLSCert(t, pkix.Name{ExtraNames: names}, &ca, false)
+	cert := user.Leaf
+	dn := "/DC=ch/DC=cern/OU=Organic Units/OU=Users/CN=user1/CN=1/CN=First1 Last1"
+	assert.Equal(t, CertificateDN(cert), dn)
+	proxyNames := append(append([]pkix.AttributeTypeAndValue{}, names...), pkix.AttributeTypeAndValue{Type: cn, Value: "proxy"})
+	proxy := testTLSCert(t, pkix.Name{ExtraNames: proxyNames}, &user, false).Leaf
+	unknown := testTLSCert(t, pkix.Name{CommonName: "unknown"}, &ca, false).Leaf
+	// self-signed certificate with DN of CRIC user
+	forged := testCertificate(t, pkix.Name{ExtraNames: names})
+
+	records, err := getCricRecords(testCricEntries(), false)
+	assert.Nil(t, err)
+	cmsAuth := initCMSAuth(t)
+	r, _ := http.NewRequest("GET", "/path", nil)
+	_, ok := cmsAuth.CheckX509(r, records)
+	assert.Equal(t, ok, false)
+
+	// end entity certificate of chain verified by TLS is matched
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert, ca.Leaf},
+		VerifiedChains:   [][]*x509.Certificate{{cert, ca.Leaf}},
+	}
+	rec, ok := cmsAuth.CheckX509(r, records)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "user1")
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "user1")
+	assert.Equal(t, r.Header.Get("cms-authn-dn"), dn)
+	assert.Equal(t, r.Header.Get("cms-authz-operator"), "group:dbs")
+	assert.Equal(t, cmsAuth.CheckAuthnAuthz(lowerHeaders(r.Header)), true)
+
+	// unverified certificate appended to valid chain is not trusted
+	r, _ = http.NewRequest("GET", "/path", nil)
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{unknown, forged},
+		VerifiedChains:   [][]*x509.Certificate{{unknown, ca.Leaf}},
+	}
+	_, ok = cmsAuth.CheckX509(r, records)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, r.Header.Get("cms-authn-login"), "")
+
+	// unverified chains are rejected without ClientCAs
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{proxy, cert}}
+	_, ok = cmsAuth.CheckX509(r, records)
+	assert.Equal(t, ok, false)
+
+	// proxy certificate is matched via its verified end entity certificate
+	cmsAuth.ClientCAs = pool
+	rec, ok = cmsAuth.CheckX509(r, records)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rec.Login, "user1")
+
+	for _, chain := range [][]*x509.Certificate{{forged}, {unknown, forged}, {proxy}} {
+		r, _ = http.NewRequest("GET", "/path", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: chain}
+		_, ok = cmsAuth.CheckX509(r, records)
+		assert.Equal(t, ok, false)
+	}
+}
+
+// helper function to create TLS certificate with given subject signed by
+// given CA, self-signed CA is created if parent is nil
+func testTLSCert(t *testing.T, subject pkix.Name, parent *tls.Certificate, isCA bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	issuer, signer := tmpl, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestServerTLSConfig function
+func TestServerTLSConfig(t *testing.T) {
+	ca := testTLSCert(t, pkix.Name{CommonName: "Test CA"}, nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := testTLSCert(t, pkix.Name{CommonName: "localhost"}, &ca, false)
+	dc := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+	cn := asn1.ObjectIdentifier{2, 5, 4, 3}
+	userCert := testTLSCert(t, pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+		{Type: dc, Value: "ch"},
+		{Type: dc, Value: "cern"},
+		{Type: cn, Value: "user"},
+	}}, &ca, false)
+	unknownCert := testTLSCert(t, pkix.Name{CommonName: "unknown"}, &ca, false)
+	records := CricRecords{GetSortedDN("/DC=ch/DC=cern/CN=user"): CricEntry{DN: "/DC=ch/DC=cern/CN=user", Login: "user"}}
+
+	newServer := func(opts ServerTLSOptions) *httptest.Server {
+		opts.Certificates = []tls.Certificate{serverCert}
+		opts.ClientCAs = pool
+		config, err := ServerTLSConfig(func() CricRecords { return records }, opts)
+		assert.Nil(t, err)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var login string
+			if len(r.TLS.PeerCertificates) > 0 {
+				rec, _ := findCricEntryByDN(records, CertificateDN(r.TLS.PeerCertificates[0]))
+				login = rec.Login
+			}
+			w.Write([]byte(login))
+		}))
+		server.TLS = config
+		server.StartTLS()
+		t.Cleanup(server.Close)
+		return server
+	}
+	get := func(server *httptest.Server, cert *tls.Certificate) (string, error) {
+		config := &tls.Config{RootCAs: pool}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	server := newServer(ServerTLSOptions{})
+	login, err := get(server, &userCert)
+	assert.Nil(t, err)
+	assert.Equal(t, login, "user")
+	_, err = get(server, &unknownCert)
+	assert.NotNil(t, err)
+	// client certificate is optional
+	login, err = get(server, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, login, "")
+
+	server = newServer(ServerTLSOptions{RequireCert: true})
+	_, err = get(server, nil)
+	assert.NotNil(t, err)
+	_, err = get(server, &userCert)
+	assert.Nil(t, err)
+
+	// only end entity certificate is matched, not CA certificates of chain
+	records[GetSortedDN("/CN=Test CA")] = CricEntry{DN: "/CN=Test CA", Login: "ca"}
+	lookup := func(dn string) (CricEntry, bool) { return findCricEntryByDN(records, dn) }
+	assert.NotNil(t, verifyCricChains(lookup, [][]*x509.Certificate{{unknownCert.Leaf, ca.Leaf}}))
+	assert.Nil(t, verifyCricChains(lookup, [][]*x509.Certificate{{userCert.Leaf, ca.Leaf}}))
+
+	// indexed lookup, e.g. CricManager.LookupByDN, is used when provided
+	mgr := &CricManager{}
+	mgr.update(CricRecords{"user": records[GetSortedDN("/DC=ch/DC=cern/CN=user")]}, time.Now())
+	server = newServer(ServerTLSOptions{LookupDN: mgr.LookupByDN})
+	_, err = get(server, &userCert)
+	assert.Nil(t, err)
+	mgr.update(CricRecords{}, time.Now())
+	_, err = get(server, &userCert)
+	assert.NotNil(t, err)
+
+	_, err = ServerTLSConfig(func() CricRecords { return records }, ServerTLSOptions{CADir: t.TempDir()})
+	assert.NotNil(t, err)
+}